@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+type AdminStatus struct {
+	Disk      DiskUsage           `json:"disk"`
+	Scheduler SchedulerStatus     `json:"scheduler"`
+	Rollouts  []RolloutStatus     `json:"rollouts"`
+	Deletions DeletionQueueStatus `json:"deletions"`
+	Demand    *DemandStatus       `json:"demand,omitempty"`
+	Capacity  CapacityStatus      `json:"capacity"`
+}
+
+type SchedulerStatus struct {
+	Capacity  Resources      `json:"capacity"`
+	Committed Resources      `json:"committed"`
+	Waiting   map[int]string `json:"waiting"`
+}
+
+// Admin serves coordinator status and metrics for operators.
+type Admin struct {
+	logger    *zap.SugaredLogger
+	config    *AdminConfig
+	disk      *DiskManager
+	scheduler *Scheduler
+	rollouts  *Rollouts
+	deletions *DeletionQueue
+	demand    *Demand
+	capacity  *Capacity
+}
+
+func NewAdmin(
+	logger *zap.SugaredLogger,
+	config *AdminConfig,
+	disk *DiskManager,
+	scheduler *Scheduler,
+	rollouts *Rollouts,
+	deletions *DeletionQueue,
+	demand *Demand,
+	capacity *Capacity,
+) *Admin {
+	return &Admin{
+		logger:    logger.Named("admin"),
+		config:    config,
+		disk:      disk,
+		scheduler: scheduler,
+		rollouts:  rollouts,
+		deletions: deletions,
+		demand:    demand,
+		capacity:  capacity,
+	}
+}
+
+func (a *Admin) Run(ctx context.Context, g *errgroup.Group) {
+	g.Go(func() error {
+		listener, err := net.Listen("tcp", a.config.Addr)
+		if err != nil {
+			return fmt.Errorf("cannot setup admin listener: %w", err)
+		}
+		a.runHTTP(ctx, listener)
+		return nil
+	})
+}
+
+func (a *Admin) runHTTP(ctx context.Context, listener net.Listener) {
+	mux := http.NewServeMux()
+	server := &http.Server{
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+		Handler:      mux,
+		ErrorLog:     zap.NewStdLog(a.logger.Desugar()),
+	}
+	mux.HandleFunc("/status", a.handleStatus)
+	mux.HandleFunc("/metrics", a.handleMetrics)
+	mux.HandleFunc("/rollouts/promote", a.handleRollout(a.rollouts.Promote))
+	mux.HandleFunc("/rollouts/rollback", a.handleRollout(a.rollouts.Rollback))
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	a.logger.Infow("admin server started", "addr", listener.Addr().String())
+	err := server.Serve(listener)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		a.logger.Errorw("failed to start admin server", "error", err)
+	}
+}
+
+func (a *Admin) Status() *AdminStatus {
+	committed, capacity := a.scheduler.Committed()
+	status := &AdminStatus{
+		Disk: a.disk.Usage(),
+		Scheduler: SchedulerStatus{
+			Capacity:  capacity,
+			Committed: committed,
+			Waiting:   a.scheduler.Waiting(),
+		},
+		Rollouts:  a.rollouts.Status(),
+		Deletions: a.deletions.Status(),
+		Capacity:  a.capacity.Status(),
+	}
+	if a.demand != nil {
+		demand := a.demand.Status()
+		status.Demand = &demand
+	}
+	return status
+}
+
+func (a *Admin) handleStatus(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(rw)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(a.Status()); err != nil {
+		a.logger.Warnw("failed to write status", "error", err)
+	}
+}
+
+func (a *Admin) handleMetrics(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	status := a.Status()
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	const mb = 1024 * 1024
+	writeMetric(rw, "gauge", "coordinator_disk_free_bytes",
+		"Free disk space in work root.", float64(status.Disk.FreeMB*mb))
+	writeMetric(rw, "gauge", "coordinator_disk_used_bytes",
+		"Disk space used by live work dirs.", float64(status.Disk.UsedMB*mb))
+	writeMetric(rw, "gauge", "coordinator_disk_min_free_bytes",
+		"Free disk space required before cloning a VM.", float64(status.Disk.MinFreeMB*mb))
+	writeMetric(rw, "gauge", "coordinator_disk_work_dirs",
+		"Number of live work dirs.", float64(status.Disk.WorkDirs))
+	writeMetric(rw, "counter", "coordinator_disk_gc_removed_dirs_total",
+		"Number of stale work dirs removed.", float64(status.Disk.GCRemovedDirs))
+
+	writeMetric(rw, "gauge", "coordinator_scheduler_committed_cpus",
+		"CPUs committed to running instances.", float64(status.Scheduler.Committed.CPUCount))
+	writeMetric(rw, "gauge", "coordinator_scheduler_capacity_cpus",
+		"CPUs available to instances.", float64(status.Scheduler.Capacity.CPUCount))
+	writeMetric(rw, "gauge", "coordinator_scheduler_committed_memory_bytes",
+		"Memory committed to running instances.", float64(status.Scheduler.Committed.MemoryMB*mb))
+	writeMetric(rw, "gauge", "coordinator_scheduler_capacity_memory_bytes",
+		"Memory available to instances; 0 if unlimited.", float64(status.Scheduler.Capacity.MemoryMB*mb))
+	writeMetric(rw, "gauge", "coordinator_scheduler_waiting_slots",
+		"Number of runner slots waiting for capacity.", float64(len(status.Scheduler.Waiting)))
+
+	oldestPending := 0.0
+	if len(status.Deletions.Pending) > 0 {
+		oldestPending = time.Since(status.Deletions.Pending[0].EnqueuedAt).Seconds()
+	}
+	writeMetric(rw, "gauge", "coordinator_deletions_pending",
+		"Number of runner deletions pending retry.", float64(len(status.Deletions.Pending)))
+	writeMetric(rw, "gauge", "coordinator_deletions_oldest_pending_seconds",
+		"Age of oldest pending runner deletion.", oldestPending)
+	writeMetric(rw, "counter", "coordinator_deletions_total",
+		"Number of runners deleted.", float64(status.Deletions.Deleted))
+	writeMetric(rw, "counter", "coordinator_deletion_failures_total",
+		"Number of failed runner deletion attempts.", float64(status.Deletions.Failures))
+	writeMetric(rw, "counter", "coordinator_deletions_dropped_total",
+		"Number of runner deletions given up on permanent errors.", float64(status.Deletions.DroppedTotal))
+
+	activeSchedules := 0
+	for _, schedule := range status.Capacity.Schedules {
+		if schedule.IsActive {
+			activeSchedules++
+		}
+	}
+	writeMetric(rw, "gauge", "coordinator_capacity_active_schedules",
+		"Number of capacity schedules in effect.", float64(activeSchedules))
+	desired := make(map[string]float64)
+	for _, runner := range status.Capacity.Runners {
+		desired[runner.Pool] += float64(runner.Desired)
+	}
+	writeLabelledMetric(rw, "gauge", "coordinator_capacity_desired_slots",
+		"Number of active runner slots desired.", "pool", desired)
+
+	if status.Demand != nil {
+		writeMetric(rw, "gauge", "coordinator_demand_queued_jobs",
+			"Number of queued self-hosted jobs.", float64(status.Demand.Queued))
+		writeMetric(rw, "gauge", "coordinator_demand_in_progress_jobs",
+			"Number of in-progress self-hosted jobs.", float64(status.Demand.InProgress))
+		writeMetric(rw, "gauge", "coordinator_demand_unmatched_jobs",
+			"Number of queued self-hosted jobs no runner config can run.", float64(status.Demand.Unmatched))
+		writeMetric(rw, "gauge", "coordinator_demand_rate_remaining",
+			"Remaining GitHub API requests observed by demand.", float64(status.Demand.RateRemaining))
+
+		queued := make(map[string]float64)
+		for _, runner := range status.Demand.Runners {
+			queued[runner.Pool] += float64(runner.Queued)
+		}
+		writeLabelledMetric(rw, "gauge", "coordinator_demand_runner_queued_jobs",
+			"Number of queued jobs the runner config can run.", "pool", queued)
+	}
+}
+
+// handleRollout handles POST /rollouts/<action>?image=<name>:<version>.
+func (a *Admin) handleRollout(decide func(image string) error) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if !checkBearerToken(r, a.config.Token) {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		image := r.URL.Query().Get("image")
+		if err := decide(image); err != nil {
+			http.Error(rw, err.Error(), http.StatusNotFound)
+			return
+		}
+		a.logger.Infow("rollout decided by admin", "image", image, "path", r.URL.Path)
+		rw.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// writeLabelledMetric writes a metric with one label in Prometheus text
+// format.
+func writeLabelledMetric(w io.Writer, kind string, name string, help string, label string, values map[string]float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s{%s=%q} %g\n", name, label, key, values[key])
+	}
+}
+
+// writeMetric writes a single unlabelled metric in Prometheus text format.
+func writeMetric(w io.Writer, kind string, name string, help string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", name, help, name, kind, name, value)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestAdminRolloutAuthorization(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	catalog, err := NewImageCatalog([]ImageConfig{
+		{Name: "vm", Version: "1", Path: "/images/vm-1"},
+		{Name: "vm", Version: "2", Path: "/images/vm-2"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rollouts, err := NewRollouts(logger, []RolloutConfig{{Image: "vm:2", From: "vm:1"}}, catalog, filepath.Join(t.TempDir(), "rollouts.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin := NewAdmin(logger, &AdminConfig{Token: "t0ken"}, nil, nil, rollouts, nil, nil, nil)
+	handler := admin.handleRollout(rollouts.Promote)
+
+	for _, authz := range []string{"", "Bearer ", "Bearer wrong", "t0ken", "Bearer t0ken"} {
+		req := httptest.NewRequest("POST", "/rollouts/promote?image=vm:2", nil)
+		if authz != "" {
+			req.Header.Set("Authorization", authz)
+		}
+		rw := httptest.NewRecorder()
+		handler(rw, req)
+
+		authorized := authz == "Bearer t0ken"
+		expected := http.StatusUnauthorized
+		if authorized {
+			expected = http.StatusNoContent
+		}
+		if rw.Code != expected {
+			t.Errorf("authz %q: unexpected status %d", authz, rw.Code)
+		}
+		if state := rollouts.Status()[0].State; (state == RolloutStatePromoted) != authorized {
+			t.Errorf("authz %q: unexpected state %s", authz, state)
+		}
+	}
+}
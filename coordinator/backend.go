@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+)
+
+const (
+	BackendVMCtl     = "vmctl"
+	BackendTart      = "tart"
+	BackendContainer = "container"
+	BackendFake      = "fake"
+)
+
+// VM describes the VM of a runner instance managed by a backend.
+type VM struct {
+	// Name is unique among VMs managed by the coordinator.
+	Name    string
+	WorkDir string
+	Config  *RunnerConfig
+	// Image is the catalog image of the VM, nil if not using catalog.
+	Image *Image
+	// ServerHostName is the host name of guest API server.
+	ServerHostName string
+	// Bootstrap is the bootstrap message for the guest.
+	Bootstrap string
+}
+
+// Backend manages VM lifecycle for runner instances.
+type Backend interface {
+	// Clone creates a fresh VM from the base image.
+	Clone(ctx context.Context, vm *VM) error
+	// Command returns the command running the VM until it shuts down.
+	// The bootstrap message is written to its stdin, and its output is
+	// logged as VM console output, unless the backend is a ConsoleBackend.
+	Command(ctx context.Context, vm *VM) (*exec.Cmd, error)
+	// Delete deletes the VM.
+	Delete(ctx context.Context, vm *VM) error
+}
+
+// ConsoleBackend is a backend writing VM console output to a file, e.g.
+// serial port of VM, rather than output of its command.
+type ConsoleBackend interface {
+	Backend
+	// ConsolePath is the file of VM console output, appended while the
+	// command is running.
+	ConsolePath(vm *VM) string
+}
+
+func NewBackends(config *Config) map[string]Backend {
+	return map[string]Backend{
+		BackendVMCtl:     NewVMCtlBackend(config.VMCtlPath),
+		BackendTart:      NewTartBackend(config.TartPath),
+		BackendContainer: NewContainerBackend(config.ContainerPath),
+		BackendFake:      NewFakeBackend(),
+	}
+}
+
+func selectBackend(backends map[string]Backend, config *RunnerConfig) (Backend, error) {
+	name := config.Backend
+	if name == "" {
+		name = BackendVMCtl
+	}
+	backend, ok := backends[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown backend: %s", name)
+	}
+	return backend, nil
+}
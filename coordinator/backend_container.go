@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+const defaultContainerPath = "docker"
+
+// ContainerBackend runs instances as OCI containers through docker or
+// podman CLI; BaseImage of runner config is the container image, which is
+// expected to run the guest agent reading bootstrap message from stdin.
+type ContainerBackend struct {
+	cliPath string
+}
+
+func NewContainerBackend(cliPath string) *ContainerBackend {
+	if cliPath == "" {
+		cliPath = defaultContainerPath
+	}
+	return &ContainerBackend{cliPath: cliPath}
+}
+
+func (b *ContainerBackend) cli(ctx context.Context, args ...string) *exec.Cmd {
+	return exec.CommandContext(ctx, b.cliPath, args...)
+}
+
+func (b *ContainerBackend) Clone(ctx context.Context, vm *VM) error {
+	if vm.Config.BaseImage == "" {
+		return fmt.Errorf("base image is required for container backend")
+	}
+
+	args := []string{
+		"create",
+		"--interactive",
+		"--name", vm.Name,
+		"--label", "github-ci-support.coordinator=true",
+		// Resolve server host name to the container host.
+		"--add-host", vm.ServerHostName + ":host-gateway",
+	}
+	if vm.Config.CPUCount > 0 {
+		args = append(args, "--cpus", strconv.Itoa(vm.Config.CPUCount))
+	}
+	if vm.Config.MemoryMB > 0 {
+		args = append(args, "--memory", strconv.FormatUint(vm.Config.MemoryMB, 10)+"m")
+	}
+	args = append(args, vm.Config.ContainerArgs...)
+	args = append(args, vm.Config.BaseImage)
+	args = append(args, vm.Config.ContainerCommand...)
+
+	if out, err := b.cli(ctx, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, out)
+	}
+	return nil
+}
+
+func (b *ContainerBackend) Command(ctx context.Context, vm *VM) (*exec.Cmd, error) {
+	return b.cli(ctx, "start", "--attach", "--interactive", vm.Name), nil
+}
+
+func (b *ContainerBackend) Delete(ctx context.Context, vm *VM) error {
+	if out, err := b.cli(ctx, "rm", "--force", vm.Name).CombinedOutput(); err != nil {
+		// Container may not be created successfully.
+		if strings.Contains(strings.ToLower(string(out)), "no such container") {
+			return nil
+		}
+		return fmt.Errorf("%w: %s", err, out)
+	}
+	return nil
+}
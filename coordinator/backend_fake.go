@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// FakeBackend runs instances as local processes simulating guests, for
+// running coordinators without VMs, e.g. a local cluster for testing.
+// Simulated job duration is set by FAKE_JOB_DURATION in runner env.
+type FakeBackend struct{}
+
+func NewFakeBackend() *FakeBackend {
+	return &FakeBackend{}
+}
+
+func (b *FakeBackend) Clone(ctx context.Context, vm *VM) error {
+	return nil
+}
+
+func (b *FakeBackend) Command(ctx context.Context, vm *VM) (*exec.Cmd, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("cannot locate coordinator executable: %w", err)
+	}
+	cmd := exec.CommandContext(ctx, exe, "fake-guest")
+	cmd.Dir = vm.WorkDir
+	return cmd, nil
+}
+
+func (b *FakeBackend) Delete(ctx context.Context, vm *VM) error {
+	return nil
+}
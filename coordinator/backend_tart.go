@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+)
+
+const (
+	defaultTartPath = "tart"
+	// tartBootstrapDirName is the shared directory name containing the
+	// bootstrap message; mounted at "/Volumes/My Shared Files/bootstrap"
+	// in macOS guests.
+	tartBootstrapDirName = "bootstrap"
+	// tartConsoleFileName is the file in VM work dir receiving output of
+	// guest serial console.
+	tartConsoleFileName = "console.log"
+)
+
+// TartBackend runs VMs with Tart; BaseImage of runner config is the
+// source VM name or OCI reference to clone from.
+type TartBackend struct {
+	tartPath string
+}
+
+func NewTartBackend(tartPath string) *TartBackend {
+	if tartPath == "" {
+		tartPath = defaultTartPath
+	}
+	return &TartBackend{tartPath: tartPath}
+}
+
+func (b *TartBackend) tart(ctx context.Context, args ...string) *exec.Cmd {
+	return exec.CommandContext(ctx, b.tartPath, args...)
+}
+
+func (b *TartBackend) Clone(ctx context.Context, vm *VM) error {
+	if vm.Config.BaseImage == "" {
+		return fmt.Errorf("base image is required for tart backend")
+	}
+	// VM names restart from first after coordinator restart, so a VM left
+	// over by a crash may have the same name.
+	exists, err := b.exists(ctx, vm.Name)
+	if err != nil {
+		return fmt.Errorf("cannot list VMs: %w", err)
+	}
+	if exists {
+		if out, err := b.tart(ctx, "delete", vm.Name).CombinedOutput(); err != nil {
+			return fmt.Errorf("cannot delete stale VM: %w: %s", err, out)
+		}
+	}
+
+	if out, err := b.tart(ctx, "clone", vm.Config.BaseImage, vm.Name).CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, out)
+	}
+
+	args := []string{"set", vm.Name, "--random-mac"}
+	if vm.Config.CPUCount > 0 {
+		args = append(args, "--cpu", strconv.Itoa(vm.Config.CPUCount))
+	}
+	if vm.Config.MemoryMB > 0 {
+		args = append(args, "--memory", strconv.FormatUint(vm.Config.MemoryMB, 10))
+	}
+	if vm.Config.DiskSizeMB > 0 {
+		// Tart accepts disk size in GB.
+		args = append(args, "--disk-size", strconv.FormatUint((vm.Config.DiskSizeMB+1023)/1024, 10))
+	}
+	if out, err := b.tart(ctx, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, out)
+	}
+	return nil
+}
+
+// exists checks whether a local VM with the name exists.
+func (b *TartBackend) exists(ctx context.Context, name string) (bool, error) {
+	out, err := b.tart(ctx, "list", "--source", "local", "--format", "json").Output()
+	if err != nil {
+		return false, err
+	}
+	var vms []struct {
+		Name string
+	}
+	if err := json.Unmarshal(out, &vms); err != nil {
+		return false, err
+	}
+	for _, vm := range vms {
+		if vm.Name == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (b *TartBackend) Command(ctx context.Context, vm *VM) (*exec.Cmd, error) {
+	// Tart does not forward stdin to guest; provide bootstrap message
+	// through shared directory instead.
+	bootstrapDir := filepath.Join(vm.WorkDir, tartBootstrapDirName)
+	if err := os.MkdirAll(bootstrapDir, 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(bootstrapDir, "bootstrap"), []byte(vm.Bootstrap), 0600); err != nil {
+		return nil, err
+	}
+	// Output of tart itself is not the guest console; guest console is
+	// written to serial port instead.
+	if err := os.WriteFile(b.ConsolePath(vm), nil, 0600); err != nil {
+		return nil, err
+	}
+
+	args := []string{
+		"run",
+		"--no-graphics",
+		"--serial-path=" + b.ConsolePath(vm),
+		fmt.Sprintf("--dir=%s:%s:ro", tartBootstrapDirName, bootstrapDir),
+	}
+	args = append(args, vm.Config.TartRunArgs...)
+	args = append(args, vm.Name)
+	return b.tart(ctx, args...), nil
+}
+
+func (b *TartBackend) ConsolePath(vm *VM) string {
+	return filepath.Join(vm.WorkDir, tartConsoleFileName)
+}
+
+func (b *TartBackend) Delete(ctx context.Context, vm *VM) error {
+	os.RemoveAll(filepath.Join(vm.WorkDir, tartBootstrapDirName))
+	os.Remove(b.ConsolePath(vm))
+	// VM may not be cloned successfully.
+	if exists, err := b.exists(ctx, vm.Name); err != nil {
+		return fmt.Errorf("cannot list VMs: %w", err)
+	} else if !exists {
+		return nil
+	}
+	if out, err := b.tart(ctx, "delete", vm.Name).CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, out)
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// fakeTart is a stub of tart CLI, keeping VMs as files in $TART_STATE/vms
+// and logging invocations to $TART_STATE/log.
+const fakeTart = `#!/bin/sh
+echo "$*" >> "$TART_STATE/log"
+case "$1" in
+list)
+	printf '['
+	sep=''
+	for f in "$TART_STATE"/vms/*; do
+		[ -e "$f" ] || continue
+		printf '%s{"Name":"%s","Source":"local"}' "$sep" "$(basename "$f")"
+		sep=','
+	done
+	printf ']\n'
+	;;
+clone)
+	[ -e "$TART_STATE/vms/$3" ] && { echo "VM already exists" >&2; exit 1; }
+	touch "$TART_STATE/vms/$3"
+	;;
+set)
+	[ -e "$TART_STATE/vms/$2" ] || { echo "VM does not exist" >&2; exit 1; }
+	;;
+delete)
+	[ -e "$TART_STATE/vms/$2" ] || { echo "VM does not exist" >&2; exit 1; }
+	rm "$TART_STATE/vms/$2"
+	;;
+run)
+	echo "$*"
+	for arg in "$@"; do
+		case "$arg" in
+		--serial-path=*) echo "guest console" >> "${arg#--serial-path=}" ;;
+		esac
+	done
+	;;
+esac
+`
+
+func setupFakeTart(t *testing.T) (state string) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "tart"), []byte(fakeTart), 0755); err != nil {
+		t.Fatal(err)
+	}
+	state = t.TempDir()
+	if err := os.Mkdir(filepath.Join(state, "vms"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("TART_STATE", state)
+	return state
+}
+
+func readTartLog(t *testing.T, state string) []string {
+	data, err := os.ReadFile(filepath.Join(state, "log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filepath.Join(state, "log"))
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func TestTartBackend(t *testing.T) {
+	state := setupFakeTart(t)
+	ctx := context.Background()
+	backend := NewTartBackend("")
+
+	vm := &VM{
+		Name:      "runner-0-1",
+		WorkDir:   t.TempDir(),
+		Config:    &RunnerConfig{BaseImage: "base", CPUCount: 2, MemoryMB: 4096, DiskSizeMB: 50000},
+		Bootstrap: "bootstrap message",
+	}
+	if err := backend.Clone(ctx, vm); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"list --source local --format json",
+		"clone base runner-0-1",
+		"set runner-0-1 --random-mac --cpu 2 --memory 4096 --disk-size 49",
+	}
+	if log := readTartLog(t, state); strings.Join(log, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected clone commands: %q", log)
+	}
+
+	cmd, err := backend.Command(ctx, vm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bootstrapDir := filepath.Join(vm.WorkDir, tartBootstrapDirName)
+	consolePath := filepath.Join(vm.WorkDir, tartConsoleFileName)
+	if args := strings.TrimSpace(string(out)); args != "run --no-graphics --serial-path="+consolePath+" --dir=bootstrap:"+bootstrapDir+":ro runner-0-1" {
+		t.Errorf("unexpected run command: %s", args)
+	}
+	if data, err := os.ReadFile(filepath.Join(bootstrapDir, "bootstrap")); err != nil || string(data) != vm.Bootstrap {
+		t.Errorf("unexpected bootstrap file: %q %v", data, err)
+	}
+	readTartLog(t, state)
+
+	if err := backend.Delete(ctx, vm); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(bootstrapDir); !os.IsNotExist(err) {
+		t.Errorf("bootstrap dir not removed: %v", err)
+	}
+	if _, err := os.Stat(consolePath); !os.IsNotExist(err) {
+		t.Errorf("console file not removed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(state, "vms", vm.Name)); !os.IsNotExist(err) {
+		t.Errorf("VM not deleted: %v", err)
+	}
+}
+
+func TestTartBackendStaleVM(t *testing.T) {
+	state := setupFakeTart(t)
+	ctx := context.Background()
+	backend := NewTartBackend("")
+
+	// Left over by previous coordinator process.
+	if err := os.WriteFile(filepath.Join(state, "vms", "runner-0-1"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	vm := &VM{Name: "runner-0-1", WorkDir: t.TempDir(), Config: &RunnerConfig{BaseImage: "base"}}
+	if err := backend.Clone(ctx, vm); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"list --source local --format json",
+		"delete runner-0-1",
+		"clone base runner-0-1",
+		"set runner-0-1 --random-mac",
+	}
+	if log := readTartLog(t, state); strings.Join(log, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected clone commands: %q", log)
+	}
+}
+
+func TestTartBackendCloneError(t *testing.T) {
+	setupFakeTart(t)
+	backend := NewTartBackend("")
+	if err := backend.Clone(context.Background(), &VM{Name: "vm", Config: &RunnerConfig{}}); err == nil {
+		t.Error("expected error without base image")
+	}
+}
+
+func TestTartBackendConsole(t *testing.T) {
+	setupFakeTart(t)
+	core, logs := observer.New(zap.InfoLevel)
+	backend := NewTartBackend("")
+
+	vm := &VM{Name: "runner-0-1", WorkDir: t.TempDir(), Config: &RunnerConfig{BaseImage: "base"}}
+	monitor := &Monitor{messages: make(chan any, 10)}
+	instance := NewRunnerInstance(zap.New(core).Sugar(), 0, backend, vm, monitor, nil)
+	if err := instance.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var console []string
+	for _, entry := range logs.All() {
+		if strings.HasSuffix(entry.LoggerName, ".console") {
+			console = append(console, entry.Message)
+		}
+	}
+	if len(console) != 1 || console[0] != "guest console" {
+		t.Errorf("unexpected console logs: %v", console)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+)
+
+type VMCtlBackend struct {
+	vmctlPath string
+	macs      *MACAllocator
+
+	lock         *sync.Mutex
+	macAddresses map[string]string
+}
+
+func NewVMCtlBackend(vmctlPath string) *VMCtlBackend {
+	return &VMCtlBackend{
+		vmctlPath:    vmctlPath,
+		macs:         NewMACAllocator(),
+		lock:         new(sync.Mutex),
+		macAddresses: make(map[string]string),
+	}
+}
+
+func (b *VMCtlBackend) bundlePath(vm *VM) string {
+	return filepath.Join(vm.WorkDir, "vm.bundle")
+}
+
+func (b *VMCtlBackend) configPath(vm *VM) string {
+	return filepath.Join(vm.WorkDir, "vm.json")
+}
+
+func (b *VMCtlBackend) Clone(ctx context.Context, vm *VM) error {
+	bundlePath := b.bundlePath(vm)
+	if err := exec.CommandContext(ctx, b.vmctlPath, "clone", vm.Config.BaseVMBundlePath, bundlePath).Run(); err != nil {
+		return err
+	}
+
+	if vm.Config.DiskSizeMB > 0 {
+		if err := growDisk(filepath.Join(bundlePath, "disk.img"), vm.Config.DiskSizeMB); err != nil {
+			return fmt.Errorf("cannot resize disk: %w", err)
+		}
+	}
+
+	mac, err := b.macs.Allocate()
+	if err != nil {
+		return err
+	}
+	b.lock.Lock()
+	b.macAddresses[vm.Name] = mac
+	b.lock.Unlock()
+
+	if err := renderVMConfig(vm.Config.VMConfigPath, b.configPath(vm), vm.Config, mac); err != nil {
+		return fmt.Errorf("cannot render VM config: %w", err)
+	}
+	return nil
+}
+
+func (b *VMCtlBackend) Command(ctx context.Context, vm *VM) (*exec.Cmd, error) {
+	return exec.CommandContext(ctx, b.vmctlPath, "start", "--config", b.configPath(vm), "--bundle", b.bundlePath(vm)), nil
+}
+
+func (b *VMCtlBackend) Delete(ctx context.Context, vm *VM) error {
+	b.lock.Lock()
+	if mac, ok := b.macAddresses[vm.Name]; ok {
+		b.macs.Release(mac)
+		delete(b.macAddresses, vm.Name)
+	}
+	b.lock.Unlock()
+
+	os.Remove(b.configPath(vm))
+	return os.RemoveAll(b.bundlePath(vm))
+}
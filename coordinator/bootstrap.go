@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+
+	"github.com/oursky/github-ci-support/coordinatorclient"
+)
+
+func (r *RunnerInstance) bootstrapMessage() (string, error) {
+	hostName, _ := os.Hostname()
+	msg := &coordinatorclient.BootstrapMessage{
+		Version:        coordinatorclient.ProtocolVersion,
+		ServerURLs:     r.server.URLs(),
+		Token:          r.Token,
+		TokenExpiresAt: r.tokenExpiresAt,
+		CACert:         string(r.server.CACertPEM()),
+		Runner: coordinatorclient.BootstrapRunner{
+			GitHubURL: r.server.service.URL(),
+			Group:     r.Config.RunnerGroup,
+			Labels:    r.server.ownership.RunnerLabels(r.Config.Labels),
+		},
+		Env: r.Config.Env,
+		Coordinator: coordinatorclient.BootstrapCoordinator{
+			HostName:   hostName,
+			InstanceID: r.id,
+			Slot:       r.slot,
+		},
+	}
+
+	if r.Config.LegacyBootstrap {
+		return msg.EncodeLegacy(), nil
+	}
+	return msg.Encode()
+}
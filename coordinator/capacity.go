@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+const capacityCheckInterval time.Duration = 30 * time.Second
+
+// CapacityScheduleConfig changes the active runner slots of pools during
+// windows starting on a cron schedule. Later schedules override earlier ones
+// if windows overlap.
+type CapacityScheduleConfig struct {
+	Name string `json:"name"`
+	// Cron is the cron expression of window starts, e.g. "0 20 * * 1-5".
+	Cron     string   `json:"cron"`
+	Duration Duration `json:"duration"`
+	// Timezone is the IANA time zone of cron expression, e.g.
+	// "Asia/Hong_Kong"; defaults to local time zone.
+	Timezone string `json:"timezone,omitempty"`
+	// Pools are the pools of affected runner configs.
+	Pools []string `json:"pools"`
+	// Count is the number of active slots of each runner config, up to its
+	// max count.
+	Count int `json:"count,omitempty"`
+	// Paused deactivates all slots of the pools.
+	Paused bool `json:"paused,omitempty"`
+}
+
+type CapacityStatus struct {
+	Schedules []CapacityScheduleStatus `json:"schedules"`
+	Runners   []RunnerCapacity         `json:"runners"`
+}
+
+type CapacityScheduleStatus struct {
+	Name        string     `json:"name"`
+	IsActive    bool       `json:"isActive"`
+	ActiveUntil *time.Time `json:"activeUntil,omitempty"`
+	NextStart   *time.Time `json:"nextStart,omitempty"`
+}
+
+type RunnerCapacity struct {
+	// Slot is the first runner slot of the config.
+	Slot     int    `json:"slot"`
+	Pool     string `json:"pool"`
+	Count    int    `json:"count"`
+	MaxCount int    `json:"maxCount"`
+	Desired  int    `json:"desired"`
+	// Schedule is the schedule setting desired count, if any.
+	Schedule string `json:"schedule,omitempty"`
+}
+
+type capacitySchedule struct {
+	config   CapacityScheduleConfig
+	cron     *CronExpr
+	location *time.Location
+}
+
+// window returns the window containing t, if any.
+func (s *capacitySchedule) window(t time.Time) (start time.Time, end time.Time, ok bool) {
+	start, ok = s.cron.Prev(t.In(s.location))
+	if !ok {
+		return
+	}
+	end = start.Add(time.Duration(s.config.Duration))
+	ok = t.Before(end)
+	return
+}
+
+type capacityRunner struct {
+	pool     string
+	count    int
+	maxCount int
+	slots    []int
+}
+
+// Capacity decides which runner slots are active according to capacity
+// schedules. Inactive slots do not start new instances; their live
+// instances are drained by monitor.
+type Capacity struct {
+	logger    *zap.SugaredLogger
+	schedules []*capacitySchedule
+	runners   []capacityRunner
+	slots     map[int]int
+
+	lock      *sync.Mutex
+	desired   []int
+	scheduled []string
+	changed   chan struct{}
+}
+
+// RunnerSlots assigns runner slots to runner configs: each config has max
+// count slots, numbered in order of configs.
+func RunnerSlots(configs []RunnerConfig) [][]int {
+	var slots [][]int
+	next := 0
+	for _, config := range configs {
+		_, maxCount := runnerCounts(&config)
+		var configSlots []int
+		for i := 0; i < maxCount; i++ {
+			configSlots = append(configSlots, next)
+			next++
+		}
+		slots = append(slots, configSlots)
+	}
+	return slots
+}
+
+func runnerCounts(config *RunnerConfig) (count int, maxCount int) {
+	count = config.Count
+	if count <= 0 {
+		count = 1
+	}
+	maxCount = config.MaxCount
+	if maxCount <= 0 {
+		maxCount = count
+	}
+	return
+}
+
+func NewCapacity(logger *zap.SugaredLogger, configs []CapacityScheduleConfig, runnerConfigs []RunnerConfig) (*Capacity, error) {
+	c := &Capacity{
+		logger:    logger.Named("capacity"),
+		slots:     make(map[int]int),
+		lock:      new(sync.Mutex),
+		desired:   make([]int, len(runnerConfigs)),
+		scheduled: make([]string, len(runnerConfigs)),
+		changed:   make(chan struct{}),
+	}
+
+	pools := make(map[string]bool)
+	for i, slots := range RunnerSlots(runnerConfigs) {
+		count, maxCount := runnerCounts(&runnerConfigs[i])
+		if count > maxCount {
+			return nil, fmt.Errorf("runner %d: count %d exceeds max count %d", i, count, maxCount)
+		}
+		pool := runnerConfigs[i].Pool
+		c.runners = append(c.runners, capacityRunner{pool: pool, count: count, maxCount: maxCount, slots: slots})
+		for _, slot := range slots {
+			c.slots[slot] = i
+		}
+		c.desired[i] = count
+		pools[pool] = true
+	}
+
+	for _, config := range configs {
+		if config.Name == "" {
+			return nil, errors.New("capacity schedule name is required")
+		}
+		cron, err := ParseCronExpr(config.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("capacity schedule %q: %w", config.Name, err)
+		}
+		location := time.Local
+		if config.Timezone != "" {
+			location, err = time.LoadLocation(config.Timezone)
+			if err != nil {
+				return nil, fmt.Errorf("capacity schedule %q: %w", config.Name, err)
+			}
+		}
+		if config.Duration <= 0 {
+			return nil, fmt.Errorf("capacity schedule %q: duration is required", config.Name)
+		}
+		if !config.Paused && config.Count <= 0 {
+			return nil, fmt.Errorf("capacity schedule %q: count must be positive, or use paused", config.Name)
+		}
+		if len(config.Pools) == 0 {
+			return nil, fmt.Errorf("capacity schedule %q: pools are required", config.Name)
+		}
+		for _, pool := range config.Pools {
+			if !pools[pool] {
+				return nil, fmt.Errorf("capacity schedule %q: unknown pool %q", config.Name, pool)
+			}
+		}
+		c.schedules = append(c.schedules, &capacitySchedule{config: config, cron: cron, location: location})
+	}
+
+	c.update(time.Now())
+	return c, nil
+}
+
+// Slots returns runner slots of the runner config.
+func (c *Capacity) Slots(runner int) []int {
+	return c.runners[runner].slots
+}
+
+// IsActive checks whether the runner slot may run instances.
+func (c *Capacity) IsActive(slot int) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.isActive(slot)
+}
+
+func (c *Capacity) isActive(slot int) bool {
+	runner, ok := c.slots[slot]
+	if !ok {
+		return true
+	}
+	return slot-c.runners[runner].slots[0] < c.desired[runner]
+}
+
+// WaitActive waits until the runner slot is active.
+func (c *Capacity) WaitActive(ctx context.Context, slot int) error {
+	for {
+		c.lock.Lock()
+		isActive := c.isActive(slot)
+		changed := c.changed
+		c.lock.Unlock()
+		if isActive {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+func (c *Capacity) Run(ctx context.Context, g *errgroup.Group) {
+	if len(c.schedules) == 0 {
+		return
+	}
+	g.Go(func() error {
+		ticker := time.NewTicker(capacityCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case now := <-ticker.C:
+				c.update(now)
+			}
+		}
+	})
+}
+
+func (c *Capacity) update(now time.Time) {
+	desired := make([]int, len(c.runners))
+	scheduled := make([]string, len(c.runners))
+	for i, runner := range c.runners {
+		desired[i] = runner.count
+	}
+	for _, s := range c.schedules {
+		if _, _, ok := s.window(now); !ok {
+			continue
+		}
+		for i, runner := range c.runners {
+			if !containsString(s.config.Pools, runner.pool) {
+				continue
+			}
+			desired[i] = 0
+			if !s.config.Paused {
+				desired[i] = s.config.Count
+				if desired[i] > runner.maxCount {
+					desired[i] = runner.maxCount
+				}
+			}
+			scheduled[i] = s.config.Name
+		}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	isChanged := false
+	for i, runner := range c.runners {
+		if desired[i] == c.desired[i] && scheduled[i] == c.scheduled[i] {
+			continue
+		}
+		isChanged = true
+		c.logger.Infow("runner capacity changed",
+			"runner", i,
+			"pool", runner.pool,
+			"desired", desired[i],
+			"previous", c.desired[i],
+			"schedule", scheduled[i],
+		)
+	}
+	if !isChanged {
+		return
+	}
+	c.desired = desired
+	c.scheduled = scheduled
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+func (c *Capacity) Status() CapacityStatus {
+	now := time.Now()
+	status := CapacityStatus{
+		Schedules: []CapacityScheduleStatus{},
+		Runners:   []RunnerCapacity{},
+	}
+	for _, s := range c.schedules {
+		scheduleStatus := CapacityScheduleStatus{Name: s.config.Name}
+		if _, end, ok := s.window(now); ok {
+			scheduleStatus.IsActive = true
+			scheduleStatus.ActiveUntil = &end
+		}
+		if next, ok := s.cron.Next(now.In(s.location)); ok {
+			scheduleStatus.NextStart = &next
+		}
+		status.Schedules = append(status.Schedules, scheduleStatus)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for i, runner := range c.runners {
+		status.Runners = append(status.Runners, RunnerCapacity{
+			Slot:     runner.slots[0],
+			Pool:     runner.pool,
+			Count:    runner.count,
+			MaxCount: runner.maxCount,
+			Desired:  c.desired[i],
+			Schedule: c.scheduled[i],
+		})
+	}
+	return status
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestCapacityUpdate(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	capacity, err := NewCapacity(zap.NewNop().Sugar(), []CapacityScheduleConfig{
+		{
+			Name:     "night",
+			Cron:     "0 20 * * 1-5",
+			Duration: Duration(12 * time.Hour),
+			Timezone: "Asia/Tokyo",
+			Pools:    []string{"mac"},
+			Count:    10,
+		},
+		{
+			Name:     "maintenance",
+			Cron:     "0 2 * * 3",
+			Duration: Duration(2 * time.Hour),
+			Timezone: "Asia/Tokyo",
+			Pools:    []string{"mac", "linux"},
+			Paused:   true,
+		},
+		{
+			Name:     "weekend",
+			Cron:     "0 0 * * 6",
+			Duration: Duration(48 * time.Hour),
+			Timezone: "Asia/Tokyo",
+			Pools:    []string{"linux"},
+			Count:    2,
+		},
+	}, []RunnerConfig{
+		{Pool: "mac", Count: 2, MaxCount: 4},
+		{Pool: "linux", MaxCount: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slots := fmt.Sprint(capacity.Slots(0), capacity.Slots(1)); slots != "[0 1 2 3] [4 5]" {
+		t.Fatalf("unexpected slots: %s", slots)
+	}
+
+	// 2024-01-01 is Monday; times are passed in UTC.
+	cases := []struct {
+		name      string
+		time      time.Time
+		active    string
+		scheduled string
+	}{
+		{"no schedule", time.Date(2024, 1, 1, 12, 0, 0, 0, tokyo), "[0 1 4]", "[ ]"},
+		{"clamped to max count", time.Date(2024, 1, 1, 21, 0, 0, 0, tokyo), "[0 1 2 3 4]", "[night ]"},
+		{"paused overriding earlier schedule", time.Date(2024, 1, 3, 3, 0, 0, 0, tokyo), "[]", "[maintenance maintenance]"},
+		{"earlier schedule after override", time.Date(2024, 1, 3, 5, 0, 0, 0, tokyo), "[0 1 2 3 4]", "[night ]"},
+		{"window ended", time.Date(2024, 1, 3, 8, 0, 0, 0, tokyo), "[0 1 4]", "[ ]"},
+		{"weekend", time.Date(2024, 1, 6, 10, 0, 0, 0, tokyo), "[0 1 4 5]", "[ weekend]"},
+		{"weekend before midnight", time.Date(2024, 1, 7, 23, 59, 0, 0, tokyo), "[0 1 4 5]", "[ weekend]"},
+		{"weekend ended", time.Date(2024, 1, 8, 0, 0, 0, 0, tokyo), "[0 1 4]", "[ ]"},
+	}
+	for _, c := range cases {
+		capacity.lock.Lock()
+		changed := capacity.changed
+		previous := fmt.Sprint(capacity.desired, capacity.scheduled)
+		capacity.lock.Unlock()
+
+		capacity.update(c.time.UTC())
+
+		var active []int
+		for slot := 0; slot < 6; slot++ {
+			if capacity.IsActive(slot) {
+				active = append(active, slot)
+			}
+		}
+		if fmt.Sprint(active) != c.active {
+			t.Errorf("%s: unexpected active slots: %v", c.name, active)
+		}
+		if scheduled := fmt.Sprint(capacity.scheduled); scheduled != c.scheduled {
+			t.Errorf("%s: unexpected schedules: %q", c.name, scheduled)
+		}
+
+		select {
+		case <-changed:
+			if fmt.Sprint(capacity.desired, capacity.scheduled) == previous {
+				t.Errorf("%s: unexpected change notification", c.name)
+			}
+		default:
+			if fmt.Sprint(capacity.desired, capacity.scheduled) != previous {
+				t.Errorf("%s: missing change notification", c.name)
+			}
+		}
+	}
+
+	// Slots of unknown runner configs are always active.
+	if !capacity.IsActive(6) {
+		t.Error("expected unknown slot to be active")
+	}
+}
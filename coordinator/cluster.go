@@ -0,0 +1,230 @@
+package main
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	ClusterRoleController = "controller"
+	ClusterRoleAgent      = "agent"
+)
+
+const (
+	clusterPathInfo         = "/cluster/v1/info"
+	clusterPathRunners      = "/cluster/v1/runners"
+	clusterPathResync       = "/cluster/v1/resync"
+	clusterPathToken        = "/cluster/v1/registration-token"
+	clusterPathDeleteRunner = "/cluster/v1/runners/delete"
+	clusterPathRunnerLabels = "/cluster/v1/runners/labels"
+	clusterPathReport       = "/cluster/v1/report"
+	clusterPathAcquire      = "/cluster/v1/leases/acquire"
+	clusterPathRelease      = "/cluster/v1/leases/release"
+	clusterPathStatus       = "/cluster/v1/status"
+
+	// clusterPollTimeout is the maximum duration of long-polling requests.
+	clusterPollTimeout time.Duration = 30 * time.Second
+	// clusterReportInterval is the interval of agent reports, which also
+	// serve as heartbeat.
+	clusterReportInterval time.Duration = 10 * time.Second
+	// clusterAgentTimeout is the duration without reports after which an
+	// agent is considered dead, and its leases are released.
+	clusterAgentTimeout time.Duration = 1 * time.Minute
+)
+
+// ClusterConfig configures cluster mode: a controller owns GitHub sync,
+// registration tokens and instance scheduling for the cluster, while agents
+// on each host run instances.
+type ClusterConfig struct {
+	// Role is "controller" or "agent".
+	Role string `json:"role"`
+	// Addr is the listen address of controller API.
+	Addr string `json:"addr,omitempty"`
+	// ControllerURL is the URL of controller API, for agents.
+	ControllerURL string `json:"controllerURL,omitempty"`
+	// Secret is the shared secret authenticating agents to controller.
+	Secret string `json:"secret"`
+	// AgentName identifies the agent, and must be unique in cluster.
+	AgentName string `json:"agentName,omitempty"`
+	// MaxInstances is the maximum number of running instances in the
+	// cluster; 0 means unlimited.
+	MaxInstances int `json:"maxInstances,omitempty"`
+
+	// TLSCert and TLSKey are the PEM files of controller API certificate
+	// and key.
+	TLSCert string `json:"tlsCert,omitempty"`
+	TLSKey  string `json:"tlsKey,omitempty"`
+	// CACert is the PEM file of CA certificates verifying controller API
+	// for agents; defaults to system roots.
+	CACert string `json:"caCert,omitempty"`
+	// Insecure allows controller API over plain HTTP, e.g. on a trusted
+	// network.
+	Insecure bool `json:"insecure,omitempty"`
+}
+
+// Validate checks the config of the role. The controller API hands out
+// registration tokens, so it is never served without a secret, nor over
+// plain HTTP unless explicitly allowed.
+func (c *ClusterConfig) Validate() error {
+	if c.Secret == "" {
+		return errors.New("secret is required")
+	}
+	switch c.Role {
+	case ClusterRoleController:
+		if c.Addr == "" {
+			return errors.New("addr is required for controller")
+		}
+		if (c.TLSCert == "") != (c.TLSKey == "") {
+			return errors.New("both tlsCert and tlsKey are required for TLS")
+		}
+		if c.TLSCert == "" && !c.Insecure {
+			return errors.New("tlsCert and tlsKey are required unless insecure")
+		}
+	case ClusterRoleAgent:
+		if c.ControllerURL == "" {
+			return errors.New("controllerURL is required for agent")
+		}
+		controllerURL, err := url.Parse(c.ControllerURL)
+		if err != nil {
+			return fmt.Errorf("invalid controllerURL: %w", err)
+		}
+		switch {
+		case controllerURL.Scheme == "https":
+		case controllerURL.Scheme == "http" && c.Insecure:
+		default:
+			return fmt.Errorf("controllerURL must be https unless insecure: %s", c.ControllerURL)
+		}
+		// Host names are shared by agents on the same host, and runner names
+		// derived from them would collide.
+		if c.AgentName == "" {
+			return errors.New("agentName is required for agent")
+		}
+	default:
+		return fmt.Errorf("unknown role: %s", c.Role)
+	}
+	return nil
+}
+
+type clusterInfo struct {
+	GitHubURL string           `json:"githubURL"`
+	Ownership *OwnershipConfig `json:"ownership,omitempty"`
+}
+
+type clusterRunners struct {
+	Epoch     int64          `json:"epoch"`
+	BeginTime time.Time      `json:"beginTime"`
+	Runners   []RemoteRunner `json:"runners"`
+}
+
+type clusterToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type clusterDeleteRunner struct {
+	ID int64 `json:"id"`
+}
+
+type clusterRunnerLabels struct {
+	ID     int64    `json:"id"`
+	Labels []string `json:"labels"`
+}
+
+type clusterAcquire struct {
+	Agent     string    `json:"agent"`
+	Session   string    `json:"session"`
+	Slot      int       `json:"slot"`
+	Resources Resources `json:"resources"`
+}
+
+type clusterLease struct {
+	ID        string    `json:"id"`
+	Agent     string    `json:"agent"`
+	Slot      int       `json:"slot"`
+	Resources Resources `json:"resources"`
+	GrantedAt time.Time `json:"grantedAt"`
+}
+
+type InstanceStatus struct {
+	ID         uint32      `json:"id"`
+	Slot       int         `json:"slot"`
+	State      RunnerState `json:"state"`
+	RunnerName string      `json:"runnerName,omitempty"`
+	RunnerID   int64       `json:"runnerID,omitempty"`
+	Image      string      `json:"image,omitempty"`
+}
+
+// AgentReport is the status of an agent reported to controller. Session
+// identifies the agent process, to detect agents sharing a name.
+type AgentReport struct {
+	Agent     string           `json:"agent"`
+	Session   string           `json:"session"`
+	Capacity  Resources        `json:"capacity"`
+	Committed Resources        `json:"committed"`
+	Waiting   map[int]string   `json:"waiting"`
+	Instances []InstanceStatus `json:"instances"`
+	Leases    []clusterLease   `json:"leases"`
+}
+
+type AgentStatus struct {
+	AgentReport
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+type ClusterStatus struct {
+	Agents        []AgentStatus  `json:"agents"`
+	Leases        []clusterLease `json:"leases"`
+	WaitingLeases int            `json:"waitingLeases"`
+	MaxInstances  int            `json:"maxInstances"`
+	RemoteRunners int            `json:"remoteRunners"`
+	Epoch         int64          `json:"epoch"`
+}
+
+// serverTLSConfig returns the TLS config of controller API, or nil if
+// without TLS.
+func (c *ClusterConfig) serverTLSConfig() (*tls.Config, error) {
+	if c.TLSCert == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.TLSCert, c.TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load controller certificate: %w", err)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// clientTLSConfig returns the TLS config verifying controller API.
+func (c *ClusterConfig) clientTLSConfig() (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.CACert == "" {
+		return config, nil
+	}
+	data, err := os.ReadFile(c.CACert)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read CA certificate: %w", err)
+	}
+	config.RootCAs = x509.NewCertPool()
+	if !config.RootCAs.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificate found in %s", c.CACert)
+	}
+	return config, nil
+}
+
+func checkBearerToken(r *http.Request, secret string) bool {
+	bearer, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || bearer != "Bearer" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1
+}
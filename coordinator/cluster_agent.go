@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oursky/github-ci-support/githublib"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+const clusterRetryDelay time.Duration = 5 * time.Second
+
+// ClusterAgent runs instances on a host of the cluster. It implements
+// RunnerService through the controller, acquires leases from controller
+// before starting instances, and reports host status to controller.
+type ClusterAgent struct {
+	logger  *zap.SugaredLogger
+	config  *ClusterConfig
+	name    string
+	session string
+	client  *http.Client
+
+	scheduler *Scheduler
+	monitor   *Monitor
+	githubURL string
+	ownership *OwnershipConfig
+	resync    chan struct{}
+
+	lock   *sync.Mutex
+	leases map[string]*clusterLease
+}
+
+func NewClusterAgent(logger *zap.SugaredLogger, config *ClusterConfig, scheduler *Scheduler) (*ClusterAgent, error) {
+	tlsConfig, err := config.clientTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return &ClusterAgent{
+		logger:  logger.Named("agent"),
+		config:  config,
+		name:    config.AgentName,
+		session: newLeaseID(),
+		client: &http.Client{
+			Timeout:   clusterPollTimeout + 10*time.Second,
+			Transport: transport,
+		},
+		scheduler: scheduler,
+		resync:    make(chan struct{}, 1),
+		lock:      new(sync.Mutex),
+		leases:    make(map[string]*clusterLease),
+	}, nil
+}
+
+// Connect fetches cluster info from controller, retrying until success or
+// context is done.
+func (a *ClusterAgent) Connect(ctx context.Context) error {
+	for {
+		var info clusterInfo
+		err := a.call(ctx, "GET", clusterPathInfo, nil, &info)
+		if err == nil {
+			a.githubURL = info.GitHubURL
+			a.ownership = info.Ownership
+			a.logger.Infow("connected to controller", "agent", a.name, "url", a.config.ControllerURL)
+			return nil
+		}
+
+		a.logger.Warnw("cannot connect to controller", "error", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(clusterRetryDelay):
+		}
+	}
+}
+
+func (a *ClusterAgent) Name() string {
+	return a.name
+}
+
+// Ownership returns the ownership config of cluster; available after Connect.
+func (a *ClusterAgent) Ownership() *OwnershipConfig {
+	return a.ownership
+}
+
+// SetMonitor sets the monitor to report instances of.
+func (a *ClusterAgent) SetMonitor(monitor *Monitor) {
+	a.monitor = monitor
+}
+
+func (a *ClusterAgent) Run(ctx context.Context, g *errgroup.Group) {
+	g.Go(func() error {
+		ticker := time.NewTicker(clusterReportInterval)
+		defer ticker.Stop()
+		for {
+			a.report(ctx)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+			}
+		}
+	})
+
+	g.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-a.resync:
+			}
+			if err := a.call(ctx, "POST", clusterPathResync, nil, nil); err != nil && ctx.Err() == nil {
+				a.logger.Warnw("failed to request resync", "error", err)
+			}
+		}
+	})
+}
+
+func (a *ClusterAgent) report(ctx context.Context) {
+	committed, capacity := a.scheduler.Committed()
+	report := AgentReport{
+		Agent:     a.name,
+		Session:   a.session,
+		Capacity:  capacity,
+		Committed: committed,
+		Waiting:   a.scheduler.Waiting(),
+	}
+	if a.monitor != nil {
+		if instances, err := a.monitor.Instances(ctx); err == nil {
+			report.Instances = instances
+		}
+	}
+	a.lock.Lock()
+	for _, lease := range a.leases {
+		report.Leases = append(report.Leases, *lease)
+	}
+	a.lock.Unlock()
+
+	if err := a.call(ctx, "POST", clusterPathReport, report, nil); err != nil && ctx.Err() == nil {
+		a.logger.Warnw("failed to report to controller", "error", err)
+	}
+}
+
+func (a *ClusterAgent) URL() string {
+	return a.githubURL
+}
+
+func (a *ClusterAgent) RegistrationToken(ctx context.Context) (*githublib.RegistrationToken, error) {
+	var token clusterToken
+	if err := a.call(ctx, "POST", clusterPathToken, nil, &token); err != nil {
+		return nil, err
+	}
+	return &githublib.RegistrationToken{Value: token.Token, ExpiresAt: token.ExpiresAt}, nil
+}
+
+func (a *ClusterAgent) DeleteRunner(ctx context.Context, id int64) error {
+	return a.call(ctx, "POST", clusterPathDeleteRunner, clusterDeleteRunner{ID: id}, nil)
+}
+
+func (a *ClusterAgent) SetRunnerLabels(ctx context.Context, id int64, labels []string) error {
+	return a.call(ctx, "POST", clusterPathRunnerLabels, clusterRunnerLabels{ID: id, Labels: labels}, nil)
+}
+
+func (a *ClusterAgent) Resync() {
+	select {
+	case a.resync <- struct{}{}:
+	default:
+	}
+}
+
+// RunSync long-polls remote runners synced by controller. Epochs are
+// renumbered locally, so controller restarts are seen as new syncs.
+func (a *ClusterAgent) RunSync(ctx context.Context, g *errgroup.Group, result chan<- *RemoteRunners) {
+	g.Go(func() error {
+		defer close(result)
+
+		epoch := int64(1)
+		remoteEpoch := int64(0)
+		var beginTime time.Time
+		for ctx.Err() == nil {
+			var runners clusterRunners
+			path := clusterPathRunners + "?epoch=" + strconv.FormatInt(remoteEpoch, 10)
+			if err := a.call(ctx, "GET", path, nil, &runners); err != nil {
+				if ctx.Err() == nil {
+					a.logger.Warnw("failed to get runners", "error", err)
+				}
+				select {
+				case <-ctx.Done():
+				case <-time.After(clusterRetryDelay):
+				}
+				continue
+			}
+			if runners.Epoch == 0 {
+				// Controller has not synced yet, e.g. just restarted; it does
+				// not wait for sync if epoch differs.
+				select {
+				case <-ctx.Done():
+				case <-time.After(clusterRetryDelay):
+				}
+				continue
+			}
+			if runners.Epoch == remoteEpoch && runners.BeginTime.Equal(beginTime) {
+				continue
+			}
+			remoteEpoch, beginTime = runners.Epoch, runners.BeginTime
+
+			remote := &RemoteRunners{
+				BeginTime: runners.BeginTime,
+				Epoch:     epoch,
+				Runners:   make(map[string]RemoteRunner),
+			}
+			for _, runner := range runners.Runners {
+				remote.Runners[runner.Name] = runner
+			}
+			select {
+			case result <- remote:
+			case <-ctx.Done():
+				return nil
+			}
+			epoch++
+		}
+		return nil
+	})
+}
+
+// Acquire waits for a lease from controller to start an instance.
+func (a *ClusterAgent) Acquire(ctx context.Context, slot int, req Resources) (*clusterLease, error) {
+	for {
+		var lease clusterLease
+		err := a.call(ctx, "POST", clusterPathAcquire, clusterAcquire{Agent: a.name, Session: a.session, Slot: slot, Resources: req}, &lease)
+		if err == nil {
+			a.lock.Lock()
+			a.leases[lease.ID] = &lease
+			a.lock.Unlock()
+			return &lease, nil
+		}
+
+		var statusErr *clusterStatusError
+		if !(errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusRequestTimeout) {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			a.logger.Warnw("failed to acquire lease", "slot", slot, "error", err)
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(clusterRetryDelay):
+			}
+		}
+	}
+}
+
+func (a *ClusterAgent) Release(lease *clusterLease) {
+	a.lock.Lock()
+	delete(a.leases, lease.ID)
+	a.lock.Unlock()
+
+	// Lost releases are recovered through reports.
+	if err := a.call(context.Background(), "POST", clusterPathRelease, lease, nil); err != nil {
+		a.logger.Warnw("failed to release lease", "slot", lease.Slot, "error", err)
+	}
+}
+
+type clusterStatusError struct {
+	Path       string
+	StatusCode int
+	Message    string
+}
+
+func (e *clusterStatusError) Error() string {
+	return fmt.Sprintf("%s: unexpected status %d: %s", e.Path, e.StatusCode, e.Message)
+}
+
+func (a *ClusterAgent) call(ctx context.Context, method string, path string, body any, result any) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	url := strings.TrimSuffix(a.config.ControllerURL, "/") + path
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+a.config.Secret)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &clusterStatusError{Path: path, StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	if result != nil {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			return fmt.Errorf("%s: malformed response: %w", path, err)
+		}
+	}
+	return nil
+}
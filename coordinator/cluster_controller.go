@@ -0,0 +1,501 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v45/github"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+type leaseWaiter struct {
+	req   clusterAcquire
+	ready chan *clusterLease
+}
+
+// ClusterController owns GitHub access of the cluster: it syncs remote
+// runners once for all agents, issues registration tokens, deletes runners,
+// and grants instance starts of agents against cluster-wide limit.
+type ClusterController struct {
+	logger    *zap.SugaredLogger
+	config    *ClusterConfig
+	service   RunnerService
+	ownership *Ownership
+	sweeper   *Sweeper
+
+	lock    *sync.Mutex
+	remote  *RemoteRunners
+	updated chan struct{}
+	agents  map[string]*AgentStatus
+	leases  map[string]*clusterLease
+	waiters []*leaseWaiter
+}
+
+func NewClusterController(logger *zap.SugaredLogger, config *ClusterConfig, service RunnerService, ownership *Ownership, sweeper *Sweeper) *ClusterController {
+	return &ClusterController{
+		logger:    logger.Named("controller"),
+		config:    config,
+		service:   service,
+		ownership: ownership,
+		sweeper:   sweeper,
+		lock:      new(sync.Mutex),
+		remote:    &RemoteRunners{Epoch: 0, BeginTime: time.Now(), Runners: nil},
+		updated:   make(chan struct{}),
+		agents:    make(map[string]*AgentStatus),
+		leases:    make(map[string]*clusterLease),
+	}
+}
+
+func (c *ClusterController) Run(ctx context.Context, g *errgroup.Group) {
+	sync := make(chan *RemoteRunners)
+	c.service.RunSync(ctx, g, sync)
+	g.Go(func() error {
+		for remote := range sync {
+			c.setRemote(remote)
+		}
+		return nil
+	})
+
+	g.Go(func() error {
+		ticker := time.NewTicker(clusterReportInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+				c.expireAgents()
+			}
+		}
+	})
+
+	g.Go(func() error {
+		tlsConfig, err := c.config.serverTLSConfig()
+		if err != nil {
+			return err
+		}
+		listener, err := net.Listen("tcp", c.config.Addr)
+		if err != nil {
+			return fmt.Errorf("cannot setup controller listener: %w", err)
+		}
+		if tlsConfig != nil {
+			listener = tls.NewListener(listener, tlsConfig)
+		}
+		c.runHTTP(ctx, listener)
+		return nil
+	})
+}
+
+func (c *ClusterController) runHTTP(ctx context.Context, listener net.Listener) {
+	server := &http.Server{
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: clusterPollTimeout + 10*time.Second,
+		Handler:      c.handler(),
+		ErrorLog:     zap.NewStdLog(c.logger.Desugar()),
+	}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	c.logger.Infow("controller server started", "addr", listener.Addr().String())
+	err := server.Serve(listener)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		c.logger.Errorw("failed to start controller server", "error", err)
+	}
+}
+
+func (c *ClusterController) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(clusterPathInfo, c.handleInfo)
+	mux.HandleFunc(clusterPathRunners, c.handleRunners)
+	mux.HandleFunc(clusterPathResync, c.handleResync)
+	mux.HandleFunc(clusterPathToken, c.handleToken)
+	mux.HandleFunc(clusterPathDeleteRunner, c.handleDeleteRunner)
+	mux.HandleFunc(clusterPathRunnerLabels, c.handleRunnerLabels)
+	mux.HandleFunc(clusterPathReport, c.handleReport)
+	mux.HandleFunc(clusterPathAcquire, c.handleAcquire)
+	mux.HandleFunc(clusterPathRelease, c.handleRelease)
+	mux.HandleFunc(clusterPathStatus, c.handleStatus)
+	return c.authenticate(mux)
+}
+
+func (c *ClusterController) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if !checkBearerToken(r, c.config.Secret) {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
+
+func (c *ClusterController) setRemote(remote *RemoteRunners) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.remote = remote
+	close(c.updated)
+	c.updated = make(chan struct{})
+
+	if c.sweeper != nil {
+		tracked := make(map[string]bool)
+		for _, agent := range c.agents {
+			for _, instance := range agent.Instances {
+				if instance.RunnerName != "" {
+					tracked[instance.RunnerName] = true
+				}
+			}
+		}
+		c.sweeper.Observe(remote, tracked)
+	}
+}
+
+func (c *ClusterController) expireAgents() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
+	for name, agent := range c.agents {
+		if now.Sub(agent.LastSeen) < clusterAgentTimeout {
+			continue
+		}
+		c.logger.Warnw("agent is gone", "agent", name, "lastSeen", agent.LastSeen)
+		delete(c.agents, name)
+		for id, lease := range c.leases {
+			if lease.Agent == name {
+				delete(c.leases, id)
+			}
+		}
+	}
+	c.grant()
+}
+
+// grant grants waiting lease requests in FIFO order, within cluster-wide
+// instance limit.
+func (c *ClusterController) grant() {
+	for len(c.waiters) > 0 {
+		if c.config.MaxInstances > 0 && len(c.leases) >= c.config.MaxInstances {
+			return
+		}
+
+		w := c.waiters[0]
+		c.waiters = c.waiters[1:]
+
+		lease := &clusterLease{
+			ID:        newLeaseID(),
+			Agent:     w.req.Agent,
+			Slot:      w.req.Slot,
+			Resources: w.req.Resources,
+			GrantedAt: time.Now(),
+		}
+		c.leases[lease.ID] = lease
+		w.ready <- lease
+	}
+}
+
+// checkSession checks that no other live agent uses the agent name; agents
+// sharing a name would take over leases and runners of each other.
+func (c *ClusterController) checkSession(agent string, session string) bool {
+	if status, ok := c.agents[agent]; ok && status.Session != session {
+		c.logger.Warnw("rejecting agent with name in use", "agent", agent)
+		return false
+	}
+	return true
+}
+
+func newLeaseID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+func (c *ClusterController) handleInfo(rw http.ResponseWriter, r *http.Request) {
+	writeJSON(rw, clusterInfo{GitHubURL: c.service.URL(), Ownership: c.ownership.Config()})
+}
+
+// handleRunners long-polls remote runners newer than the epoch in query.
+func (c *ClusterController) handleRunners(rw http.ResponseWriter, r *http.Request) {
+	epoch, _ := strconv.ParseInt(r.URL.Query().Get("epoch"), 10, 64)
+
+	c.lock.Lock()
+	remote, updated := c.remote, c.updated
+	c.lock.Unlock()
+
+	if remote.Epoch == epoch {
+		select {
+		case <-updated:
+		case <-time.After(clusterPollTimeout):
+		case <-r.Context().Done():
+			return
+		}
+		c.lock.Lock()
+		remote = c.remote
+		c.lock.Unlock()
+	}
+
+	result := clusterRunners{Epoch: remote.Epoch, BeginTime: remote.BeginTime}
+	for _, runner := range c.ownership.Filter(remote.Runners) {
+		result.Runners = append(result.Runners, runner)
+	}
+	writeJSON(rw, result)
+}
+
+func (c *ClusterController) handleResync(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	c.service.Resync()
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (c *ClusterController) handleToken(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	token, err := c.service.RegistrationToken(r.Context())
+	if err != nil {
+		c.logger.Errorw("cannot get registration token", "error", err)
+		rw.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	writeJSON(rw, clusterToken{Token: token.Value, ExpiresAt: token.ExpiresAt})
+}
+
+func (c *ClusterController) handleDeleteRunner(rw http.ResponseWriter, r *http.Request) {
+	var req clusterDeleteRunner
+	if !readJSON(rw, r, &req) {
+		return
+	}
+
+	if !c.ownsRunner(req.ID) {
+		c.logger.Warnw("refusing to delete runner of other coordinator", "runnerID", req.ID)
+		http.Error(rw, "runner not owned", http.StatusForbidden)
+		return
+	}
+
+	if err := c.service.DeleteRunner(r.Context(), req.ID); err != nil {
+		c.logger.Warnw("failed to delete runner", "runnerID", req.ID, "error", err)
+		status := http.StatusBadGateway
+		var errResp *github.ErrorResponse
+		if isPermanentDeletionError(err) && errors.As(err, &errResp) {
+			// Agent gives up on permanent errors too.
+			status = errResp.Response.StatusCode
+		}
+		http.Error(rw, err.Error(), status)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (c *ClusterController) handleReport(rw http.ResponseWriter, r *http.Request) {
+	var report AgentReport
+	if !readJSON(rw, r, &report) {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if !c.checkSession(report.Agent, report.Session) {
+		http.Error(rw, "agent name in use", http.StatusConflict)
+		return
+	}
+	if _, ok := c.agents[report.Agent]; !ok {
+		c.logger.Infow("agent joined", "agent", report.Agent, "capacity", report.Capacity)
+	}
+	c.agents[report.Agent] = &AgentStatus{AgentReport: report, LastSeen: time.Now()}
+
+	held := make(map[string]bool)
+	for _, lease := range report.Leases {
+		held[lease.ID] = true
+		if _, ok := c.leases[lease.ID]; ok || lease.Agent != report.Agent {
+			continue
+		}
+		// Leases of running instances are unknown after controller restarts.
+		c.logger.Infow("adopted lease", "agent", lease.Agent, "slot", lease.Slot)
+		adopted := lease
+		c.leases[lease.ID] = &adopted
+	}
+	// Leases not known to agent are lost, e.g. response of acquire failed.
+	for id, lease := range c.leases {
+		if lease.Agent == report.Agent && !held[id] && time.Since(lease.GrantedAt) > clusterPollTimeout {
+			c.logger.Warnw("releasing lost lease", "agent", lease.Agent, "slot", lease.Slot)
+			delete(c.leases, id)
+		}
+	}
+	c.grant()
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// handleAcquire long-polls for a lease to start an instance. Responds with
+// 408 if not granted within poll timeout.
+func (c *ClusterController) handleAcquire(rw http.ResponseWriter, r *http.Request) {
+	var req clusterAcquire
+	if !readJSON(rw, r, &req) {
+		return
+	}
+
+	w := &leaseWaiter{req: req, ready: make(chan *clusterLease, 1)}
+	c.lock.Lock()
+	if !c.checkSession(req.Agent, req.Session) {
+		c.lock.Unlock()
+		http.Error(rw, "agent name in use", http.StatusConflict)
+		return
+	}
+	c.waiters = append(c.waiters, w)
+	c.grant()
+	c.lock.Unlock()
+
+	select {
+	case lease := <-w.ready:
+		c.logger.Infow("lease granted", "agent", lease.Agent, "slot", lease.Slot)
+		writeJSON(rw, lease)
+		return
+	case <-time.After(clusterPollTimeout):
+	case <-r.Context().Done():
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	select {
+	case lease := <-w.ready:
+		// Granted concurrently; agent will retry and get a new lease.
+		delete(c.leases, lease.ID)
+		c.grant()
+	default:
+		for i, q := range c.waiters {
+			if q == w {
+				c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+				break
+			}
+		}
+	}
+	rw.WriteHeader(http.StatusRequestTimeout)
+}
+
+func (c *ClusterController) handleRelease(rw http.ResponseWriter, r *http.Request) {
+	var lease clusterLease
+	if !readJSON(rw, r, &lease) {
+		return
+	}
+
+	c.lock.Lock()
+	delete(c.leases, lease.ID)
+	c.grant()
+	c.lock.Unlock()
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (c *ClusterController) Status() *ClusterStatus {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	status := &ClusterStatus{
+		Agents:        []AgentStatus{},
+		Leases:        []clusterLease{},
+		WaitingLeases: len(c.waiters),
+		MaxInstances:  c.config.MaxInstances,
+		RemoteRunners: len(c.remote.Runners),
+		Epoch:         c.remote.Epoch,
+	}
+	for _, agent := range c.agents {
+		status.Agents = append(status.Agents, *agent)
+	}
+	sort.Slice(status.Agents, func(i, j int) bool {
+		return status.Agents[i].Agent < status.Agents[j].Agent
+	})
+	for _, lease := range c.leases {
+		status.Leases = append(status.Leases, *lease)
+	}
+	sort.Slice(status.Leases, func(i, j int) bool {
+		return status.Leases[i].GrantedAt.Before(status.Leases[j].GrantedAt)
+	})
+	return status
+}
+
+func (c *ClusterController) handleRunnerLabels(rw http.ResponseWriter, r *http.Request) {
+	var req clusterRunnerLabels
+	if !readJSON(rw, r, &req) {
+		return
+	}
+
+	if !c.ownsRunner(req.ID) {
+		c.logger.Warnw("refusing to label runner of other coordinator", "runnerID", req.ID)
+		http.Error(rw, "runner not owned", http.StatusForbidden)
+		return
+	}
+
+	if err := c.service.SetRunnerLabels(r.Context(), req.ID, req.Labels); err != nil {
+		c.logger.Warnw("failed to set runner labels", "runnerID", req.ID, "error", err)
+		http.Error(rw, err.Error(), http.StatusBadGateway)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// ownsRunner checks whether the runner is owned by the cluster. Runners
+// unknown to last sync are assumed owned, since they may be newly registered.
+func (c *ClusterController) ownsRunner(id int64) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, runner := range c.remote.Runners {
+		if runner.ID == id {
+			return c.ownership.Owns(runner)
+		}
+	}
+	return true
+}
+
+// reportedRunners lists runners of instances reported by agents.
+func (c *ClusterController) reportedRunners() []RemoteRunner {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var runners []RemoteRunner
+	for _, agent := range c.agents {
+		for _, instance := range agent.Instances {
+			if instance.RunnerID != 0 {
+				runners = append(runners, RemoteRunner{ID: instance.RunnerID, Name: instance.RunnerName, IsOnline: true})
+			}
+		}
+	}
+	return runners
+}
+
+func (c *ClusterController) handleStatus(rw http.ResponseWriter, r *http.Request) {
+	writeJSON(rw, c.Status())
+}
+
+func writeJSON(rw http.ResponseWriter, value any) {
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(value)
+}
+
+func readJSON(rw http.ResponseWriter, r *http.Request, value any) bool {
+	if r.Method != "POST" {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return false
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(rw, r.Body, 1024*1024)).Decode(value); err != nil {
+		http.Error(rw, fmt.Sprintf("malformed request: %s", err), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+const testClusterSecret = "s3cret"
+
+type testCluster struct {
+	controller *ClusterController
+	server     *httptest.Server
+}
+
+func newTestCluster(t *testing.T, ctx context.Context, maxInstances int) *testCluster {
+	logger := zap.NewNop().Sugar()
+	config := &ClusterConfig{Role: ClusterRoleController, Addr: "127.0.0.1:0", Secret: testClusterSecret, MaxInstances: maxInstances}
+	ownership, err := NewOwnership(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var controller *ClusterController
+	service := NewFakeRunnerService(logger, func() []RemoteRunner {
+		return controller.reportedRunners()
+	})
+	controller = NewClusterController(logger, config, service, ownership, nil)
+
+	g, ctx := errgroup.WithContext(ctx)
+	sync := make(chan *RemoteRunners)
+	service.RunSync(ctx, g, sync)
+	go func() {
+		for remote := range sync {
+			controller.setRemote(remote)
+		}
+	}()
+
+	server := httptest.NewServer(controller.handler())
+	t.Cleanup(server.Close)
+	return &testCluster{controller: controller, server: server}
+}
+
+func (c *testCluster) newAgent(t *testing.T, name string) *ClusterAgent {
+	return newTestAgent(t, &ClusterConfig{Role: ClusterRoleAgent, ControllerURL: c.server.URL, Secret: testClusterSecret, AgentName: name, Insecure: true})
+}
+
+func newTestAgent(t *testing.T, config *ClusterConfig) *ClusterAgent {
+	logger := zap.NewNop().Sugar()
+	agent, err := NewClusterAgent(logger, config, NewScheduler(logger, &HostConfig{CPUCount: 4}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return agent
+}
+
+func TestClusterAgents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cluster := newTestCluster(t, ctx, 1)
+
+	agent1 := cluster.newAgent(t, "a1")
+	agent2 := cluster.newAgent(t, "a2")
+	for _, agent := range []*ClusterAgent{agent1, agent2} {
+		if err := agent.Connect(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if agent.URL() != "https://github.com/fake" {
+			t.Errorf("unexpected URL: %s", agent.URL())
+		}
+		if _, err := agent.RegistrationToken(ctx); err != nil {
+			t.Fatal(err)
+		}
+		agent.report(ctx)
+	}
+
+	lease1, err := agent1.Acquire(ctx, 0, Resources{CPUCount: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	granted := make(chan *clusterLease, 1)
+	go func() {
+		lease, err := agent2.Acquire(ctx, 0, Resources{CPUCount: 1})
+		if err == nil {
+			granted <- lease
+		}
+	}()
+	select {
+	case <-granted:
+		t.Fatal("lease granted beyond max instances")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	agent1.Release(lease1)
+	select {
+	case lease := <-granted:
+		if lease.Agent != "a2" {
+			t.Errorf("unexpected lease agent: %s", lease.Agent)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("lease not granted after release")
+	}
+
+	g, syncCtx := errgroup.WithContext(ctx)
+	sync := make(chan *RemoteRunners)
+	agent1.RunSync(syncCtx, g, sync)
+	select {
+	case remote := <-sync:
+		if remote.Epoch != 1 {
+			t.Errorf("unexpected epoch: %d", remote.Epoch)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("runners not synced")
+	}
+
+	status := cluster.controller.Status()
+	if len(status.Agents) != 2 || len(status.Leases) != 1 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+}
+
+func TestClusterDuplicateAgentName(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cluster := newTestCluster(t, ctx, 0)
+
+	agent1 := cluster.newAgent(t, "a1")
+	agent2 := cluster.newAgent(t, "a1")
+	if err := agent1.call(ctx, "POST", clusterPathReport, AgentReport{Agent: "a1", Session: agent1.session}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var statusErr *clusterStatusError
+	err := agent2.call(ctx, "POST", clusterPathReport, AgentReport{Agent: "a1", Session: agent2.session}, nil)
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusConflict {
+		t.Errorf("expected conflict on report, got %v", err)
+	}
+	err = agent2.call(ctx, "POST", clusterPathAcquire, clusterAcquire{Agent: "a1", Session: agent2.session}, nil)
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusConflict {
+		t.Errorf("expected conflict on acquire, got %v", err)
+	}
+}
+
+func TestClusterControllerRestartAdoptsLeases(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cluster := newTestCluster(t, ctx, 1)
+
+	agent1 := cluster.newAgent(t, "a1")
+	agent2 := cluster.newAgent(t, "a2")
+	if _, err := agent1.Acquire(ctx, 0, Resources{CPUCount: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := newTestCluster(t, ctx, 1)
+	agent1.config.ControllerURL = restarted.server.URL
+	agent2.config.ControllerURL = restarted.server.URL
+	agent1.report(ctx)
+
+	if leases := restarted.controller.Status().Leases; len(leases) != 1 || leases[0].Agent != "a1" {
+		t.Fatalf("lease not adopted: %+v", leases)
+	}
+
+	acquireCtx, cancelAcquire := context.WithTimeout(ctx, 500*time.Millisecond)
+	defer cancelAcquire()
+	if _, err := agent2.Acquire(acquireCtx, 0, Resources{CPUCount: 1}); err == nil {
+		t.Error("lease granted beyond max instances after restart")
+	}
+}
+
+func TestClusterUnauthorized(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cluster := newTestCluster(t, ctx, 0)
+
+	for _, authz := range []string{"", "Bearer ", "Bearer wrong"} {
+		req, _ := http.NewRequest("POST", cluster.server.URL+clusterPathToken, nil)
+		if authz != "" {
+			req.Header.Set("Authorization", authz)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("authz %q: unexpected status %d", authz, resp.StatusCode)
+		}
+	}
+}
+
+func TestClusterConfigValidate(t *testing.T) {
+	cases := []struct {
+		config ClusterConfig
+		valid  bool
+	}{
+		{ClusterConfig{Role: ClusterRoleController, Addr: ":7100", Secret: "s", TLSCert: "c.pem", TLSKey: "k.pem"}, true},
+		{ClusterConfig{Role: ClusterRoleController, Addr: ":7100", Secret: "s", Insecure: true}, true},
+		{ClusterConfig{Role: ClusterRoleController, Addr: ":7100", Secret: "s"}, false},
+		{ClusterConfig{Role: ClusterRoleController, Addr: ":7100", Secret: "s", TLSCert: "c.pem"}, false},
+		{ClusterConfig{Role: ClusterRoleController, Addr: ":7100", TLSCert: "c.pem", TLSKey: "k.pem"}, false},
+		{ClusterConfig{Role: ClusterRoleController, Secret: "s", Insecure: true}, false},
+		{ClusterConfig{Role: ClusterRoleAgent, ControllerURL: "https://c", Secret: "s", AgentName: "a"}, true},
+		{ClusterConfig{Role: ClusterRoleAgent, ControllerURL: "http://c", Secret: "s", AgentName: "a", Insecure: true}, true},
+		{ClusterConfig{Role: ClusterRoleAgent, ControllerURL: "http://c", Secret: "s", AgentName: "a"}, false},
+		{ClusterConfig{Role: ClusterRoleAgent, ControllerURL: "c:7100", Secret: "s", AgentName: "a", Insecure: true}, false},
+		{ClusterConfig{Role: ClusterRoleAgent, ControllerURL: "https://c", AgentName: "a"}, false},
+		{ClusterConfig{Role: ClusterRoleAgent, ControllerURL: "https://c", Secret: "s"}, false},
+		{ClusterConfig{Role: ClusterRoleAgent, Secret: "s", AgentName: "a"}, false},
+		{ClusterConfig{Role: "other", Secret: "s"}, false},
+	}
+	for _, c := range cases {
+		err := c.config.Validate()
+		if (err == nil) != c.valid {
+			t.Errorf("%+v: unexpected result %v", c.config, err)
+		}
+	}
+}
+
+func TestClusterTLS(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cluster := newTestCluster(t, ctx, 0)
+
+	ca, err := NewCertificateAuthority()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := ca.IssueServerCertificate([]string{"127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"cert.pem": pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}),
+		"key.pem":  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		"ca.pem":   ca.CertPEM(),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	controllerConfig := &ClusterConfig{TLSCert: filepath.Join(dir, "cert.pem"), TLSKey: filepath.Join(dir, "key.pem")}
+	tlsConfig, err := controllerConfig.serverTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(cluster.controller.handler())
+	server.TLS = tlsConfig
+	server.StartTLS()
+	defer server.Close()
+
+	agent := newTestAgent(t, &ClusterConfig{
+		Role:          ClusterRoleAgent,
+		ControllerURL: server.URL,
+		Secret:        testClusterSecret,
+		AgentName:     "a1",
+		CACert:        filepath.Join(dir, "ca.pem"),
+	})
+	if err := agent.config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := agent.RegistrationToken(ctx); err != nil {
+		t.Errorf("cannot get token over TLS: %v", err)
+	}
+
+	// Controller is not trusted without CA certificate.
+	untrusted := newTestAgent(t, &ClusterConfig{Role: ClusterRoleAgent, ControllerURL: server.URL, Secret: testClusterSecret, AgentName: "a2"})
+	if _, err := untrusted.RegistrationToken(ctx); err == nil {
+		t.Error("untrusted controller certificate accepted")
+	}
+}
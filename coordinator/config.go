@@ -2,24 +2,218 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/oursky/github-ci-support/githublib"
 )
 
 type Config struct {
-	Auth      githublib.AuthConfig `json:"auth"`
-	Target    string               `json:"target"`
-	Runners   []RunnerConfig       `json:"runners"`
-	VMCtlPath string               `json:"vmctlPath"`
+	Auth          githublib.AuthConfig `json:"auth"`
+	Target        string               `json:"target"`
+	Ownership     *OwnershipConfig     `json:"ownership,omitempty"`
+	Runners       []RunnerConfig       `json:"runners"`
+	RunnerGroups  []RunnerGroupConfig  `json:"runnerGroups,omitempty"`
+	Images        []ImageConfig        `json:"images,omitempty"`
+	Registry      RegistryConfig       `json:"registry,omitempty"`
+	Rollouts      []RolloutConfig      `json:"rollouts,omitempty"`
+	VMCtlPath     string               `json:"vmctlPath"`
+	TartPath      string               `json:"tartPath,omitempty"`
+	ContainerPath string               `json:"containerPath,omitempty"`
+	Webhook       *WebhookConfig       `json:"webhook,omitempty"`
+	Server        ServerConfig         `json:"server,omitempty"`
+	Host          HostConfig           `json:"host,omitempty"`
+	Admin         *AdminConfig         `json:"admin,omitempty"`
+	Cluster       *ClusterConfig       `json:"cluster,omitempty"`
+	Sweeper       *SweeperConfig       `json:"sweeper,omitempty"`
+	Demand        *DemandConfig        `json:"demand,omitempty"`
+
+	CapacitySchedules []CapacityScheduleConfig `json:"capacitySchedules,omitempty"`
+
+	// WorkRoot is the directory to hold VM clones, owned by coordinator;
+	// defaults to "github-ci-coordinator/work" in user cache dir.
+	WorkRoot string     `json:"workRoot,omitempty"`
+	Disk     DiskConfig `json:"disk,omitempty"`
+	// StateDir holds coordinator state across restarts; defaults to user
+	// config dir.
+	StateDir string `json:"stateDir,omitempty"`
+}
+
+// StatePath returns path of the named state file in state dir.
+func (c *Config) StatePath(name string) (string, error) {
+	dir := c.StateDir
+	if dir == "" {
+		userConfigDir, err := os.UserConfigDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(userConfigDir, "github-ci-coordinator")
+	}
+	return filepath.Abs(filepath.Join(dir, name))
+}
+
+// DiskConfig guards disk space used by VM clones in work root; zero values
+// use defaults (20GB free space, GC every 10 minutes).
+type DiskConfig struct {
+	// MinFreeMB is the free disk space required before cloning a VM.
+	MinFreeMB  uint64   `json:"minFreeMB,omitempty"`
+	GCInterval Duration `json:"gcInterval,omitempty"`
+}
+
+type AdminConfig struct {
+	Addr string `json:"addr"`
+	// Token is the bearer token required by operations changing state,
+	// e.g. rollout decisions; status and metrics are not authenticated.
+	Token string `json:"token"`
+}
+
+// HostConfig is the capacity of host available to instances; zero values
+// use defaults (all CPUs, unlimited memory, 2 macOS guests).
+type HostConfig struct {
+	CPUCount       int    `json:"cpuCount,omitempty"`
+	MemoryMB       uint64 `json:"memoryMB,omitempty"`
+	MaxMacOSGuests int    `json:"maxMacOSGuests,omitempty"`
+}
+
+type ServerConfig struct {
+	Addr string `json:"addr,omitempty"`
+	Port int    `json:"port,omitempty"`
+	TLS  bool   `json:"tls,omitempty"`
+	// TokenTTL is the lifetime of instance tokens, renewed by guests before
+	// expiry; defaults to 1 hour.
+	TokenTTL Duration `json:"tokenTTL,omitempty"`
+}
+
+type WebhookConfig struct {
+	Addr   string `json:"addr"`
+	Path   string `json:"path,omitempty"`
+	Secret string `json:"secret"`
 }
 
 type RunnerConfig struct {
+	// Backend is the VM backend: "vmctl" (default), "tart", "container" or
+	// "fake".
+	Backend string `json:"backend,omitempty"`
+
+	// Image is the base image in catalog, as "<name>:<version>"; overrides
+	// base VM bundle path and base image.
+	Image string `json:"image,omitempty"`
+
 	BaseVMBundlePath string `json:"baseVMBundlePath"`
 	VMConfigPath     string `json:"vmConfigPath"`
 
+	// Resource overrides; defaults to values of base image or VM config.
+	CPUCount   int    `json:"cpuCount,omitempty"`
+	MemoryMB   uint64 `json:"memoryMB,omitempty"`
+	DiskSizeMB uint64 `json:"diskSizeMB,omitempty"`
+
+	BaseImage   string   `json:"baseImage,omitempty"`
+	TartRunArgs []string `json:"tartRunArgs,omitempty"`
+
+	ContainerArgs    []string `json:"containerArgs,omitempty"`
+	ContainerCommand []string `json:"containerCommand,omitempty"`
+
 	RunnerGroup string   `json:"runnerGroup,omitempty"`
 	Labels      []string `json:"labels,omitempty"`
+	// RuntimeLabels are labels computed at runtime, in addition to Labels.
+	RuntimeLabels RuntimeLabelsConfig `json:"runtimeLabels,omitempty"`
+	// Pool names the runner config in runner names; defaults to
+	// "runner-<index of config>", so it stays stable when slots are
+	// renumbered.
+	Pool string `json:"pool,omitempty"`
+	// NameTemplate is the text/template of runner names, with fields Host,
+	// Pool, Slot and InstanceID; defaults to "{{.Host}}-{{.Pool}}-{{.InstanceID}}".
+	NameTemplate string `json:"nameTemplate,omitempty"`
+
+	// Count is the number of active runner slots by default; defaults to 1.
+	// Capacity schedules may change it, up to MaxCount slots.
+	Count int `json:"count,omitempty"`
+	// MaxCount is the number of runner slots; defaults to Count.
+	MaxCount int `json:"maxCount,omitempty"`
+
+	Env map[string]string `json:"env,omitempty"`
+	// LegacyBootstrap sends the bootstrap message as the legacy
+	// "<serverURL> <token>" line, for images without JSON bootstrap support.
+	// Not supported with server TLS, since CA certificate cannot be sent.
+	LegacyBootstrap bool `json:"legacyBootstrap,omitempty"`
+
+	// Prefetch keeps next VM cloned while current VM is running.
+	Prefetch bool `json:"prefetch,omitempty"`
+
+	Timeouts RunnerTimeouts `json:"timeouts"`
+}
+
+const (
+	defaultPendingTimeout     time.Duration = 5 * time.Minute
+	defaultConfiguringTimeout time.Duration = 5 * time.Minute
+	defaultStartingTimeout    time.Duration = 3 * time.Minute
+	defaultTerminatingTimeout time.Duration = 3 * time.Minute
+)
+
+// RunnerTimeouts are maximum wall-clock times a runner may stay in states.
+// Unset timeouts use defaults; "0s" disables the timeout.
+type RunnerTimeouts struct {
+	Pending     *Duration `json:"pending,omitempty"`
+	Configuring *Duration `json:"configuring,omitempty"`
+	Starting    *Duration `json:"starting,omitempty"`
+	Terminating *Duration `json:"terminating,omitempty"`
+}
+
+func (t RunnerTimeouts) Validate() error {
+	for _, d := range []*Duration{t.Pending, t.Configuring, t.Starting, t.Terminating} {
+		if d != nil && *d < 0 {
+			return fmt.Errorf("negative timeout: %s", time.Duration(*d))
+		}
+	}
+	return nil
+}
+
+// For returns the maximum wall-clock time a runner may stay in the state.
+// Returns 0 if the state has no timeout.
+func (t RunnerTimeouts) For(state RunnerState) time.Duration {
+	pick := func(value *Duration, fallback time.Duration) time.Duration {
+		if value == nil {
+			return fallback
+		}
+		return time.Duration(*value)
+	}
+
+	switch state {
+	case RunnerStatePending:
+		return pick(t.Pending, defaultPendingTimeout)
+	case RunnerStateConfiguring:
+		return pick(t.Configuring, defaultConfiguringTimeout)
+	case RunnerStateStarting:
+		return pick(t.Starting, defaultStartingTimeout)
+	case RunnerStateTerminating:
+		return pick(t.Terminating, defaultTerminatingTimeout)
+	}
+	return 0
+}
+
+// Duration is a time.Duration encoded as a duration string (e.g. "90s") in JSON.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("invalid duration: %w", err)
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
 }
 
 func NewConfig(path string) (*Config, error) {
@@ -32,6 +226,50 @@ func NewConfig(path string) (*Config, error) {
 	if err := json.Unmarshal(data, &config); err != nil {
 		return nil, err
 	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	for i := range config.Runners {
+		if config.Runners[i].Pool == "" {
+			config.Runners[i].Pool = fmt.Sprintf("runner-%d", i)
+		}
+	}
 
 	return &config, nil
 }
+
+// Validate checks settings that are unsafe to run with.
+func (c *Config) Validate() error {
+	if c.Cluster != nil {
+		if err := c.Cluster.Validate(); err != nil {
+			return fmt.Errorf("cluster: %w", err)
+		}
+	}
+	// Signatures of deliveries are not checked without a secret.
+	if c.Webhook != nil && c.Webhook.Secret == "" {
+		return errors.New("webhook: secret is required")
+	}
+	if c.Admin != nil && c.Admin.Token == "" {
+		return errors.New("admin: token is required")
+	}
+	// Job outcomes evaluated by rollouts are only reported by webhook.
+	for _, rollout := range c.Rollouts {
+		if rollout.Auto && c.Webhook == nil {
+			return fmt.Errorf("rollout %s: webhook is required to evaluate automatically", rollout.Image)
+		}
+	}
+	// Without ownership ID, all offline runners of target are orphans,
+	// including those of other coordinators.
+	if c.Sweeper != nil && !c.Sweeper.DryRun && (c.Ownership == nil || c.Ownership.ID == "") {
+		return errors.New("sweeper: ownership ID is required unless in dry-run")
+	}
+	for i, runner := range c.Runners {
+		if err := runner.Timeouts.Validate(); err != nil {
+			return fmt.Errorf("runner %d: %w", i, err)
+		}
+		if runner.LegacyBootstrap && c.Server.TLS {
+			return fmt.Errorf("runner %d: legacy bootstrap is not supported with server TLS", i)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestConfigValidate(t *testing.T) {
+	negative := Duration(-time.Minute)
+	cases := []struct {
+		name   string
+		config Config
+		valid  bool
+	}{
+		{"empty", Config{}, true},
+		{"webhook", Config{Webhook: &WebhookConfig{Addr: ":8080", Secret: "s"}}, true},
+		{"webhook without secret", Config{Webhook: &WebhookConfig{Addr: ":8080"}}, false},
+		{"invalid cluster", Config{Cluster: &ClusterConfig{Role: ClusterRoleAgent}}, false},
+		{"manual rollout", Config{Rollouts: []RolloutConfig{{Image: "vm:2", From: "vm:1"}}}, true},
+		{"auto rollout without webhook", Config{Rollouts: []RolloutConfig{{Image: "vm:2", From: "vm:1", Auto: true}}}, false},
+		{"auto rollout", Config{
+			Rollouts: []RolloutConfig{{Image: "vm:2", From: "vm:1", Auto: true}},
+			Webhook:  &WebhookConfig{Addr: ":8080", Secret: "s"},
+		}, true},
+		{"admin", Config{Admin: &AdminConfig{Addr: ":9090", Token: "t"}}, true},
+		{"admin without token", Config{Admin: &AdminConfig{Addr: ":9090"}}, false},
+		{"sweeper", Config{Sweeper: &SweeperConfig{}, Ownership: &OwnershipConfig{ID: "ci"}}, true},
+		{"sweeper without ownership", Config{Sweeper: &SweeperConfig{}}, false},
+		{"dry-run sweeper without ownership", Config{Sweeper: &SweeperConfig{DryRun: true}}, true},
+		{"disabled timeout", Config{Runners: []RunnerConfig{{Timeouts: RunnerTimeouts{Pending: new(Duration)}}}}, true},
+		{"legacy bootstrap", Config{Runners: []RunnerConfig{{LegacyBootstrap: true}}}, true},
+		{"legacy bootstrap with TLS", Config{Server: ServerConfig{TLS: true}, Runners: []RunnerConfig{{LegacyBootstrap: true}}}, false},
+		{"negative timeout", Config{Runners: []RunnerConfig{{}, {Timeouts: RunnerTimeouts{Starting: &negative}}}}, false},
+	}
+	for _, c := range cases {
+		err := c.config.Validate()
+		if (err == nil) != c.valid {
+			t.Errorf("%s: unexpected result %v", c.name, err)
+		}
+	}
+}
+
+func TestRunnerTimeouts(t *testing.T) {
+	var timeouts RunnerTimeouts
+	if err := json.Unmarshal([]byte(`{"pending": "0s", "starting": "90s"}`), &timeouts); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		state   RunnerState
+		timeout time.Duration
+	}{
+		{RunnerStatePending, 0},
+		{RunnerStateConfiguring, defaultConfiguringTimeout},
+		{RunnerStateStarting, 90 * time.Second},
+		{RunnerStateTerminating, defaultTerminatingTimeout},
+		{RunnerStateReady, 0},
+	}
+	for _, c := range cases {
+		if timeout := timeouts.For(c.state); timeout != c.timeout {
+			t.Errorf("%s: unexpected timeout %s", c.state, timeout)
+		}
+	}
+}
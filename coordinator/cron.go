@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSearchLimit bounds search of matching times, for expressions that
+// never match (e.g. February 30).
+const cronSearchLimit = 5 * 366 * 24 * time.Hour
+
+// CronExpr is a standard 5-field cron expression: minute, hour, day of month,
+// month and day of week (0-7, 0 or 7 is Sunday). Fields are "*", values,
+// ranges ("1-5"), steps ("*/15", "0-30/10"), or comma-separated lists of
+// them.
+type CronExpr struct {
+	minute, hour, dom, month, dow uint64
+	// Day matches either day of month or day of week if both are
+	// restricted, as in standard cron.
+	domAny, dowAny bool
+}
+
+func ParseCronExpr(expr string) (*CronExpr, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q: expected 5 fields", expr)
+	}
+
+	var c CronExpr
+	var err error
+	parsers := []struct {
+		bits     *uint64
+		min, max int
+	}{
+		{&c.minute, 0, 59},
+		{&c.hour, 0, 23},
+		{&c.dom, 1, 31},
+		{&c.month, 1, 12},
+		{&c.dow, 0, 7},
+	}
+	for i, p := range parsers {
+		*p.bits, err = parseCronField(fields[i], p.min, p.max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %w", expr, err)
+		}
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1 << 0
+	}
+	c.domAny = fields[2] == "*"
+	c.dowAny = fields[4] == "*"
+	return &c, nil
+}
+
+func parseCronField(field string, min int, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", part)
+			}
+		}
+
+		lo, hi := min, max
+		if rangePart != "*" {
+			loPart, hiPart, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if lo, err = strconv.Atoi(loPart); err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(hiPart); err != nil {
+					return 0, fmt.Errorf("invalid value %q", part)
+				}
+			} else if hasStep {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("value out of range %q", part)
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func (c *CronExpr) matchDay(t time.Time) bool {
+	dom := c.dom&(1<<t.Day()) != 0
+	dow := c.dow&(1<<t.Weekday()) != 0
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next returns the first matching time after t, in location of t. Local
+// times skipped by DST transitions never match.
+func (c *CronExpr) Next(t time.Time) (time.Time, bool) {
+	limit := t.Add(cronSearchLimit)
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	for t.Before(limit) {
+		y, mo, d := t.Date()
+		h, mi := t.Hour(), t.Minute()
+		next := t.Add(time.Minute)
+		switch {
+		case c.month&(1<<mo) == 0:
+			next = time.Date(y, mo+1, 1, 0, 0, 0, 0, loc)
+		case !c.matchDay(t):
+			next = time.Date(y, mo, d+1, 0, 0, 0, 0, loc)
+		case c.hour&(1<<h) == 0:
+			next = time.Date(y, mo, d, h+1, 0, 0, 0, loc)
+		case c.minute&(1<<mi) != 0:
+			return t, true
+		}
+		// Local times skipped by DST transitions may normalize backward.
+		if !next.After(t) {
+			next = t.Add(time.Minute)
+		}
+		t = next
+	}
+	return time.Time{}, false
+}
+
+// Prev returns the last matching time at or before t, in location of t.
+func (c *CronExpr) Prev(t time.Time) (time.Time, bool) {
+	limit := t.Add(-cronSearchLimit)
+	loc := t.Location()
+	t = t.Truncate(time.Minute)
+	for t.After(limit) {
+		y, mo, d := t.Date()
+		h, mi := t.Hour(), t.Minute()
+		prev := t.Add(-time.Minute)
+		switch {
+		case c.month&(1<<mo) == 0:
+			prev = time.Date(y, mo, 1, 0, 0, 0, 0, loc).Add(-time.Minute)
+		case !c.matchDay(t):
+			prev = time.Date(y, mo, d, 0, 0, 0, 0, loc).Add(-time.Minute)
+		case c.hour&(1<<h) == 0:
+			prev = time.Date(y, mo, d, h, 0, 0, 0, loc).Add(-time.Minute)
+		case c.minute&(1<<mi) != 0:
+			return t, true
+		}
+		if !prev.Before(t) {
+			prev = t.Add(-time.Minute)
+		}
+		t = prev
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronExpr(t *testing.T) {
+	cases := []struct {
+		expr  string
+		valid bool
+	}{
+		{"* * * * *", true},
+		{"0 20 * * 1-5", true},
+		{"*/15 9-17/2 1,15 * 7", true},
+		{"0-30/10 * * 1-12 0", true},
+		{"* * * *", false},
+		{"60 * * * *", false},
+		{"* 24 * * *", false},
+		{"* * 0 * *", false},
+		{"* * * 13 *", false},
+		{"* * * * 8", false},
+		{"5-1 * * * *", false},
+		{"*/0 * * * *", false},
+		{"a * * * *", false},
+	}
+	for _, c := range cases {
+		_, err := ParseCronExpr(c.expr)
+		if (err == nil) != c.valid {
+			t.Errorf("%q: unexpected result %v", c.expr, err)
+		}
+	}
+}
+
+func TestCronExprNext(t *testing.T) {
+	hk, err := time.LoadLocation("Asia/Hong_Kong")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		expr string
+		from time.Time
+		next time.Time
+	}{
+		{"every minute", "* * * * *",
+			time.Date(2024, 3, 1, 10, 0, 30, 0, time.UTC), time.Date(2024, 3, 1, 10, 1, 0, 0, time.UTC)},
+		{"range", "0 9-17 * * *",
+			time.Date(2024, 3, 1, 17, 0, 0, 0, time.UTC), time.Date(2024, 3, 2, 9, 0, 0, 0, time.UTC)},
+		{"step", "*/20 * * * *",
+			time.Date(2024, 3, 1, 10, 41, 0, 0, time.UTC), time.Date(2024, 3, 1, 11, 0, 0, 0, time.UTC)},
+		{"range with step", "10-50/20 * * * *",
+			time.Date(2024, 3, 1, 10, 31, 0, 0, time.UTC), time.Date(2024, 3, 1, 10, 50, 0, 0, time.UTC)},
+		{"list", "0 8,20 * * *",
+			time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC), time.Date(2024, 3, 1, 20, 0, 0, 0, time.UTC)},
+		{"7 is Sunday", "0 0 * * 7",
+			time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 3, 0, 0, 0, 0, time.UTC)},
+		{"weekdays", "0 20 * * 1-5",
+			time.Date(2024, 3, 1, 21, 0, 0, 0, time.UTC), time.Date(2024, 3, 4, 20, 0, 0, 0, time.UTC)},
+		{"day of month or day of week", "0 0 15 * 1",
+			time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC)},
+		{"month rollover", "0 0 31 * *",
+			time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 5, 31, 0, 0, 0, 0, time.UTC)},
+		{"year rollover", "0 0 1 1 *",
+			time.Date(2024, 12, 31, 23, 59, 0, 0, time.UTC), time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"leap day", "0 0 29 2 *",
+			time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"time zone", "0 9 * * *",
+			time.Date(2024, 3, 1, 9, 0, 0, 0, hk), time.Date(2024, 3, 2, 9, 0, 0, 0, hk)},
+		// 02:30 does not exist on 2024-03-10 in New York.
+		{"DST gap", "30 2 * * *",
+			time.Date(2024, 3, 9, 3, 0, 0, 0, ny), time.Date(2024, 3, 11, 2, 30, 0, 0, ny)},
+		{"DST gap hourly", "30 * * * *",
+			time.Date(2024, 3, 10, 1, 45, 0, 0, ny), time.Date(2024, 3, 10, 3, 30, 0, 0, ny)},
+	}
+	for _, c := range cases {
+		expr, err := ParseCronExpr(c.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		next, ok := expr.Next(c.from)
+		if !ok || !next.Equal(c.next) {
+			t.Errorf("%s: next of %s is %s, expected %s", c.name, c.from, next, c.next)
+		}
+		prev, ok := expr.Prev(c.next)
+		if !ok || !prev.Equal(c.next) {
+			t.Errorf("%s: prev of %s is %s", c.name, c.next, prev)
+		}
+	}
+}
+
+func TestCronExprPrev(t *testing.T) {
+	cases := []struct {
+		name string
+		expr string
+		from time.Time
+		prev time.Time
+	}{
+		{"same minute", "30 10 * * *",
+			time.Date(2024, 3, 1, 10, 30, 45, 0, time.UTC), time.Date(2024, 3, 1, 10, 30, 0, 0, time.UTC)},
+		{"previous day", "30 10 * * *",
+			time.Date(2024, 3, 1, 10, 29, 0, 0, time.UTC), time.Date(2024, 2, 29, 10, 30, 0, 0, time.UTC)},
+		{"month rollover", "0 0 31 * *",
+			time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		expr, err := ParseCronExpr(c.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		prev, ok := expr.Prev(c.from)
+		if !ok || !prev.Equal(c.prev) {
+			t.Errorf("%s: prev of %s is %s, expected %s", c.name, c.from, prev, c.prev)
+		}
+	}
+}
+
+func TestCronExprNever(t *testing.T) {
+	expr, err := ParseCronExpr("0 0 30 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next, ok := expr.Next(time.Now()); ok {
+		t.Errorf("unexpected next: %s", next)
+	}
+}
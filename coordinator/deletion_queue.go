@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v45/github"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	deletionMinBackoff    time.Duration = 10 * time.Second
+	deletionMaxBackoff    time.Duration = 30 * time.Minute
+	deletionBackoffFactor float64       = 2
+	// maxDroppedDeletions is the number of recently dropped deletions kept
+	// in status.
+	maxDroppedDeletions = 20
+)
+
+type PendingDeletion struct {
+	RunnerID    int64     `json:"runnerID"`
+	RunnerName  string    `json:"runnerName"`
+	EnqueuedAt  time.Time `json:"enqueuedAt"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"nextAttempt"`
+	LastError   string    `json:"lastError,omitempty"`
+}
+
+type DeletionQueueStatus struct {
+	Pending  []PendingDeletion `json:"pending"`
+	Deleted  int               `json:"deleted"`
+	Failures int               `json:"failures"`
+	// Dropped are recent deletions given up on permanent errors, most
+	// recent last.
+	Dropped      []PendingDeletion `json:"dropped"`
+	DroppedTotal int               `json:"droppedTotal"`
+}
+
+// DeletionQueue deletes remote runners in background, retrying with backoff
+// until success or a permanent error. Pending deletions are persisted in
+// state file, so they survive coordinator restarts and GitHub outages.
+type DeletionQueue struct {
+	logger    *zap.SugaredLogger
+	service   RunnerService
+	statePath string
+	notify    chan struct{}
+
+	lock         *sync.Mutex
+	pending      map[int64]*PendingDeletion
+	deleted      int
+	failures     int
+	dropped      []PendingDeletion
+	droppedTotal int
+}
+
+func NewDeletionQueue(logger *zap.SugaredLogger, service RunnerService, statePath string) (*DeletionQueue, error) {
+	q := &DeletionQueue{
+		logger:    logger.Named("deletions"),
+		service:   service,
+		statePath: statePath,
+		notify:    make(chan struct{}, 1),
+		lock:      new(sync.Mutex),
+		pending:   make(map[int64]*PendingDeletion),
+	}
+
+	data, err := os.ReadFile(statePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return q, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var pending []*PendingDeletion
+	if err := json.Unmarshal(data, &pending); err != nil {
+		return nil, fmt.Errorf("malformed deletion queue: %w", err)
+	}
+	now := time.Now()
+	for _, d := range pending {
+		// Retry promptly after restart; outage may be over.
+		d.NextAttempt = now
+		q.pending[d.RunnerID] = d
+	}
+	if len(pending) > 0 {
+		q.logger.Infow("loaded pending deletions", "count", len(pending))
+	}
+	return q, nil
+}
+
+// Enqueue records the runner for deletion. Runners already pending are
+// not affected.
+func (q *DeletionQueue) Enqueue(id int64, name string) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if _, ok := q.pending[id]; ok {
+		return
+	}
+	now := time.Now()
+	q.pending[id] = &PendingDeletion{
+		RunnerID:    id,
+		RunnerName:  name,
+		EnqueuedAt:  now,
+		NextAttempt: now,
+	}
+	if err := q.save(); err != nil {
+		q.logger.Warnw("failed to save deletion queue", "error", err)
+	}
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (q *DeletionQueue) Run(ctx context.Context, g *errgroup.Group) {
+	g.Go(func() error {
+		for {
+			delay := q.process(ctx)
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil
+			case <-q.notify:
+			case <-timer.C:
+			}
+			timer.Stop()
+		}
+	})
+}
+
+// process attempts due deletions, and returns delay until next attempt.
+func (q *DeletionQueue) process(ctx context.Context) time.Duration {
+	q.lock.Lock()
+	now := time.Now()
+	var due []PendingDeletion
+	for _, d := range q.pending {
+		if !d.NextAttempt.After(now) {
+			due = append(due, *d)
+		}
+	}
+	q.lock.Unlock()
+
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].EnqueuedAt.Before(due[j].EnqueuedAt)
+	})
+
+	deleted := false
+	for _, d := range due {
+		if ctx.Err() != nil {
+			break
+		}
+		err := q.service.DeleteRunner(ctx, d.RunnerID)
+		if ctx.Err() != nil {
+			break
+		}
+		q.complete(d.RunnerID, err)
+		deleted = deleted || err == nil
+	}
+	if deleted {
+		q.service.Resync()
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	delay := deletionMaxBackoff
+	for _, d := range q.pending {
+		if until := time.Until(d.NextAttempt); until < delay {
+			delay = until
+		}
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	return delay
+}
+
+func (q *DeletionQueue) complete(id int64, err error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	d, ok := q.pending[id]
+	if !ok {
+		return
+	}
+
+	if err == nil {
+		q.logger.Infow("deleted runner",
+			"runnerID", d.RunnerID,
+			"runnerName", d.RunnerName,
+			"attempts", d.Attempts+1,
+		)
+		delete(q.pending, id)
+		q.deleted++
+	} else if isPermanentDeletionError(err) {
+		d.Attempts++
+		d.LastError = err.Error()
+		q.failures++
+		q.logger.Errorw("cannot delete runner, giving up",
+			"runnerID", d.RunnerID,
+			"runnerName", d.RunnerName,
+			"attempts", d.Attempts,
+			"error", err,
+		)
+		delete(q.pending, id)
+		q.dropped = append(q.dropped, *d)
+		if len(q.dropped) > maxDroppedDeletions {
+			q.dropped = q.dropped[len(q.dropped)-maxDroppedDeletions:]
+		}
+		q.droppedTotal++
+	} else {
+		backoff := deletionMinBackoff
+		for i := 0; i < d.Attempts && backoff < deletionMaxBackoff; i++ {
+			backoff = time.Duration(float64(backoff) * deletionBackoffFactor)
+		}
+		if backoff > deletionMaxBackoff {
+			backoff = deletionMaxBackoff
+		}
+
+		d.Attempts++
+		d.NextAttempt = time.Now().Add(backoff)
+		d.LastError = err.Error()
+		q.failures++
+		q.logger.Warnw("failed to delete runner",
+			"runnerID", d.RunnerID,
+			"runnerName", d.RunnerName,
+			"attempts", d.Attempts,
+			"retryAfter", backoff.String(),
+			"error", err,
+		)
+	}
+
+	if err := q.save(); err != nil {
+		q.logger.Warnw("failed to save deletion queue", "error", err)
+	}
+}
+
+func (q *DeletionQueue) Status() DeletionQueueStatus {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	pending := make([]PendingDeletion, 0, len(q.pending))
+	for _, d := range q.pending {
+		pending = append(pending, *d)
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].EnqueuedAt.Before(pending[j].EnqueuedAt)
+	})
+	return DeletionQueueStatus{
+		Pending:      pending,
+		Deleted:      q.deleted,
+		Failures:     q.failures,
+		Dropped:      append([]PendingDeletion{}, q.dropped...),
+		DroppedTotal: q.droppedTotal,
+	}
+}
+
+// isPermanentDeletionError checks whether the runner deletion would never
+// succeed on retry, e.g. runner is not owned or no longer exists.
+func isPermanentDeletionError(err error) bool {
+	var errResp *github.ErrorResponse
+	if errors.As(err, &errResp) && errResp.Response != nil {
+		return isPermanentDeletionStatus(errResp.Response.StatusCode)
+	}
+	var statusErr *clusterStatusError
+	if errors.As(err, &statusErr) {
+		return isPermanentDeletionStatus(statusErr.StatusCode)
+	}
+	return false
+}
+
+func isPermanentDeletionStatus(code int) bool {
+	switch code {
+	case http.StatusForbidden, http.StatusNotFound, http.StatusGone:
+		return true
+	}
+	return false
+}
+
+func (q *DeletionQueue) save() error {
+	pending := make([]*PendingDeletion, 0, len(q.pending))
+	for _, d := range q.pending {
+		pending = append(pending, d)
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].EnqueuedAt.Before(pending[j].EnqueuedAt)
+	})
+	data, err := json.MarshalIndent(pending, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(q.statePath), 0700); err != nil {
+		return err
+	}
+	tmpPath := q.statePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, q.statePath)
+}
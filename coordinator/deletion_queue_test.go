@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-github/v45/github"
+	"go.uber.org/zap"
+)
+
+func TestDeletionQueueErrors(t *testing.T) {
+	q, err := NewDeletionQueue(zap.NewNop().Sugar(), nil, filepath.Join(t.TempDir(), "deletions.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	githubErr := func(code int) error {
+		return fmt.Errorf("cannot delete runner: %w", &github.ErrorResponse{Response: &http.Response{StatusCode: code}})
+	}
+	cases := []struct {
+		err     error
+		dropped bool
+	}{
+		{errors.New("connection refused"), false},
+		{githubErr(http.StatusInternalServerError), false},
+		{githubErr(http.StatusUnprocessableEntity), false},
+		{&clusterStatusError{StatusCode: http.StatusBadGateway}, false},
+		{githubErr(http.StatusNotFound), true},
+		{githubErr(http.StatusForbidden), true},
+		{&clusterStatusError{StatusCode: http.StatusForbidden, Message: "runner not owned"}, true},
+	}
+	for i, c := range cases {
+		id := int64(i + 1)
+		q.Enqueue(id, fmt.Sprint("runner-", id))
+		q.complete(id, c.err)
+
+		status := q.Status()
+		pending := false
+		for _, d := range status.Pending {
+			pending = pending || d.RunnerID == id
+		}
+		if pending == c.dropped {
+			t.Errorf("%v: unexpected pending %v", c.err, pending)
+		}
+	}
+
+	status := q.Status()
+	if status.DroppedTotal != 3 || len(status.Dropped) != 3 || status.Failures != len(cases) {
+		t.Errorf("unexpected status: %+v", status)
+	}
+	if d := status.Dropped[0]; d.RunnerID != 5 || d.LastError == "" {
+		t.Errorf("unexpected dropped deletion: %+v", d)
+	}
+}
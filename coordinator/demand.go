@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oursky/github-ci-support/githublib"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	defaultDemandInterval         time.Duration = 1 * time.Minute
+	defaultDemandMinRateRemaining int           = 500
+)
+
+// DemandConfig enables computing demand of runner configs from queued and
+// in-progress jobs listed through GitHub API. Zero values use defaults
+// (every minute, keeping 500 API requests in reserve).
+type DemandConfig struct {
+	Interval Duration `json:"interval,omitempty"`
+	// Repositories limits the organization repositories listed; defaults to
+	// all non-archived repositories.
+	Repositories     []string `json:"repositories,omitempty"`
+	MinRateRemaining int      `json:"minRateRemaining,omitempty"`
+}
+
+type DemandStatus struct {
+	UpdatedAt time.Time `json:"updatedAt"`
+	Error     string    `json:"error,omitempty"`
+	// Queued and InProgress count self-hosted jobs.
+	Queued     int `json:"queued"`
+	InProgress int `json:"inProgress"`
+	// Unmatched counts queued self-hosted jobs no runner config can run.
+	Unmatched     int            `json:"unmatched"`
+	Runners       []RunnerDemand `json:"runners"`
+	RateRemaining int            `json:"rateRemaining"`
+}
+
+// RunnerDemand counts jobs a runner config can run; a job is counted for
+// every matching runner config.
+type RunnerDemand struct {
+	// Slot is the first runner slot of the config.
+	Slot       int      `json:"slot"`
+	Pool       string   `json:"pool"`
+	Labels     []string `json:"labels"`
+	Queued     int      `json:"queued"`
+	InProgress int      `json:"inProgress"`
+}
+
+// Demand periodically computes demand of runner configs. Jobs are matched
+// with configured and implicit labels of runners; runtime labels are not
+// known ahead and so are not matched.
+type Demand struct {
+	logger   *zap.SugaredLogger
+	service  *GitHubRunnerService
+	lister   *githublib.JobLister
+	repos    []string
+	interval time.Duration
+	runners  []RunnerDemand
+
+	lock   *sync.Mutex
+	status DemandStatus
+}
+
+func NewDemand(
+	logger *zap.SugaredLogger,
+	config *DemandConfig,
+	service *GitHubRunnerService,
+	ownership *Ownership,
+	runners []RunnerConfig,
+) *Demand {
+	interval := time.Duration(config.Interval)
+	if interval <= 0 {
+		interval = defaultDemandInterval
+	}
+	lister := githublib.NewJobLister(service.client)
+	lister.MinRateRemaining = config.MinRateRemaining
+	if lister.MinRateRemaining <= 0 {
+		lister.MinRateRemaining = defaultDemandMinRateRemaining
+	}
+
+	var runnerDemands []RunnerDemand
+	for i, slots := range RunnerSlots(runners) {
+		runnerDemands = append(runnerDemands, RunnerDemand{
+			Slot:   slots[0],
+			Pool:   runners[i].Pool,
+			Labels: runnerMatchLabels(&runners[i], ownership),
+		})
+	}
+
+	return &Demand{
+		logger:   logger.Named("demand"),
+		service:  service,
+		lister:   lister,
+		repos:    config.Repositories,
+		interval: interval,
+		runners:  runnerDemands,
+		lock:     new(sync.Mutex),
+	}
+}
+
+// runnerMatchLabels returns labels of runners of the config, including
+// default labels assigned by GitHub.
+func runnerMatchLabels(config *RunnerConfig, ownership *Ownership) []string {
+	labels := []string{"self-hosted"}
+	switch config.Backend {
+	case "", BackendVMCtl, BackendTart:
+		labels = append(labels, "macOS")
+	case BackendContainer:
+		labels = append(labels, "Linux")
+	}
+	switch runtime.GOARCH {
+	case "arm64":
+		labels = append(labels, "ARM64")
+	case "amd64":
+		labels = append(labels, "X64")
+	}
+	return append(labels, ownership.RunnerLabels(config.Labels)...)
+}
+
+func (d *Demand) Status() DemandStatus {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	status := d.status
+	status.Runners = append([]RunnerDemand{}, d.status.Runners...)
+	return status
+}
+
+func (d *Demand) Run(ctx context.Context, g *errgroup.Group) {
+	d.logger.Infow("demand started", "interval", d.interval.String())
+
+	g.Go(func() error {
+		ticker := time.NewTicker(d.interval)
+		defer ticker.Stop()
+		for {
+			d.update(ctx)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+func (d *Demand) update(ctx context.Context) {
+	jobs, err := d.service.target.ListActiveJobs(ctx, d.lister, d.repos)
+	if ctx.Err() != nil {
+		return
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.status.RateRemaining = d.lister.Rate().Remaining
+	if err != nil {
+		// Keep last known demand; it is only refreshed on complete listing.
+		d.status.Error = err.Error()
+		d.logger.Warnw("failed to list jobs", "error", err)
+		return
+	}
+
+	status := DemandStatus{
+		UpdatedAt:     time.Now(),
+		Runners:       append([]RunnerDemand{}, d.runners...),
+		RateRemaining: d.status.RateRemaining,
+	}
+	for _, job := range jobs {
+		if !hasLabel(job.Labels, "self-hosted") {
+			continue
+		}
+		isQueued := job.Status == "queued"
+		if isQueued {
+			status.Queued++
+		} else {
+			status.InProgress++
+		}
+
+		isMatched := false
+		for i := range status.Runners {
+			runner := &status.Runners[i]
+			if !matchLabels(job.Labels, runner.Labels) {
+				continue
+			}
+			isMatched = true
+			if isQueued {
+				runner.Queued++
+			} else {
+				runner.InProgress++
+			}
+		}
+		if isQueued && !isMatched {
+			status.Unmatched++
+		}
+	}
+	d.status = status
+
+	d.logger.Debugw("updated demand",
+		"queued", status.Queued,
+		"inProgress", status.InProgress,
+		"unmatched", status.Unmatched,
+		"rateRemaining", status.RateRemaining,
+	)
+}
+
+// matchLabels checks whether runner has all labels requested by job; labels
+// are case-insensitive.
+func matchLabels(jobLabels []string, runnerLabels []string) bool {
+	for _, label := range jobLabels {
+		if !hasLabel(runnerLabels, label) {
+			return false
+		}
+	}
+	return true
+}
+
+func hasLabel(labels []string, label string) bool {
+	for _, l := range labels {
+		if strings.EqualFold(l, label) {
+			return true
+		}
+	}
+	return false
+}
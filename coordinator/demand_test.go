@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/oursky/github-ci-support/githublib"
+	"go.uber.org/zap"
+)
+
+func TestMatchLabels(t *testing.T) {
+	runner := []string{"self-hosted", "macOS", "ARM64", "xcode-14"}
+	cases := []struct {
+		job   []string
+		match bool
+	}{
+		{[]string{"self-hosted"}, true},
+		{[]string{"self-hosted", "macos", "arm64"}, true},
+		{[]string{"SELF-HOSTED", "Xcode-14"}, true},
+		{[]string{}, true},
+		{[]string{"self-hosted", "Linux"}, false},
+		{[]string{"self-hosted", "xcode"}, false},
+		{[]string{"ubuntu-latest"}, false},
+	}
+	for _, c := range cases {
+		if match := matchLabels(c.job, runner); match != c.match {
+			t.Errorf("%v: got %v", c.job, match)
+		}
+	}
+}
+
+func TestRunnerMatchLabels(t *testing.T) {
+	arch := map[string]string{"arm64": "ARM64", "amd64": "X64"}[runtime.GOARCH]
+	ci, _ := NewOwnership(&OwnershipConfig{ID: "ci"})
+	ciLabel, _ := NewOwnership(&OwnershipConfig{ID: "ci", Label: true})
+
+	cases := []struct {
+		config    RunnerConfig
+		ownership *Ownership
+		labels    string
+	}{
+		{RunnerConfig{}, ci, "self-hosted macOS " + arch},
+		{RunnerConfig{Backend: BackendTart, Labels: []string{"xcode"}}, ci, "self-hosted macOS " + arch + " xcode"},
+		{RunnerConfig{Backend: BackendContainer}, ci, "self-hosted Linux " + arch},
+		{RunnerConfig{Backend: BackendFake}, ci, "self-hosted " + arch},
+		{RunnerConfig{Labels: []string{"xcode"}}, ciLabel, "self-hosted macOS " + arch + " xcode coordinator-ci"},
+	}
+	for _, c := range cases {
+		labels := strings.Join(strings.Fields(strings.Join(runnerMatchLabels(&c.config, c.ownership), " ")), " ")
+		expected := strings.Join(strings.Fields(c.labels), " ")
+		if labels != expected {
+			t.Errorf("%+v: got %q, expected %q", c.config, labels, expected)
+		}
+	}
+}
+
+// fakeJobsTarget lists fixed jobs as active jobs of target.
+type fakeJobsTarget struct {
+	githublib.RunnerTarget
+	jobs []*githublib.ActiveJob
+	err  error
+}
+
+func (t *fakeJobsTarget) ListActiveJobs(ctx context.Context, lister *githublib.JobLister, repos []string) ([]*githublib.ActiveJob, error) {
+	return t.jobs, t.err
+}
+
+func TestDemandUpdate(t *testing.T) {
+	ownership, _ := NewOwnership(&OwnershipConfig{ID: "ci"})
+	target := &fakeJobsTarget{}
+	runners := []RunnerConfig{
+		{Pool: "xcode", Labels: []string{"xcode"}, Count: 2},
+		{Pool: "linux", Backend: BackendContainer},
+	}
+	demand := NewDemand(zap.NewNop().Sugar(), &DemandConfig{}, &GitHubRunnerService{target: target}, ownership, runners)
+
+	job := func(status string, labels ...string) *githublib.ActiveJob {
+		return &githublib.ActiveJob{Status: status, Labels: labels}
+	}
+	cases := []struct {
+		name       string
+		jobs       []*githublib.ActiveJob
+		queued     int
+		inProgress int
+		unmatched  int
+		// runners are queued and in-progress jobs of runner configs.
+		runners [][2]int
+	}{
+		{"no jobs", nil, 0, 0, 0, [][2]int{{0, 0}, {0, 0}}},
+		{
+			"hosted jobs",
+			[]*githublib.ActiveJob{job("queued", "ubuntu-latest"), job("in_progress", "macos-12")},
+			0, 0, 0, [][2]int{{0, 0}, {0, 0}},
+		},
+		{
+			"matched jobs",
+			[]*githublib.ActiveJob{
+				job("queued", "self-hosted", "xcode"),
+				job("queued", "self-hosted", "macOS", "XCODE"),
+				job("in_progress", "self-hosted", "xcode"),
+				job("queued", "self-hosted", "Linux"),
+			},
+			3, 1, 0, [][2]int{{2, 1}, {1, 0}},
+		},
+		{
+			"job matching multiple runners",
+			[]*githublib.ActiveJob{job("queued", "self-hosted")},
+			1, 0, 0, [][2]int{{1, 0}, {1, 0}},
+		},
+		{
+			"unmatched jobs",
+			[]*githublib.ActiveJob{
+				job("queued", "self-hosted", "gpu"),
+				job("in_progress", "self-hosted", "gpu"),
+				job("queued", "self-hosted", "Linux", "xcode"),
+			},
+			2, 1, 2, [][2]int{{0, 0}, {0, 0}},
+		},
+	}
+	for _, c := range cases {
+		target.jobs = c.jobs
+		demand.update(context.Background())
+
+		status := demand.Status()
+		if status.Error != "" {
+			t.Errorf("%s: unexpected error: %s", c.name, status.Error)
+		}
+		if status.Queued != c.queued || status.InProgress != c.inProgress || status.Unmatched != c.unmatched {
+			t.Errorf("%s: unexpected status: %+v", c.name, status)
+		}
+		for i, runner := range status.Runners {
+			if runner.Queued != c.runners[i][0] || runner.InProgress != c.runners[i][1] {
+				t.Errorf("%s: unexpected runner %d: %+v", c.name, i, runner)
+			}
+		}
+	}
+
+	// Last known demand is kept on errors.
+	status := demand.Status()
+	if status.Runners[0].Slot != 0 || status.Runners[1].Slot != 2 {
+		t.Errorf("unexpected runner slots: %+v", status.Runners)
+	}
+	target.err = errors.New("failed")
+	demand.update(context.Background())
+	if failed := demand.Status(); failed.Error != "failed" || failed.Queued != status.Queued || !failed.UpdatedAt.Equal(status.UpdatedAt) {
+		t.Errorf("unexpected status on error: %+v", failed)
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	defaultMinFreeDiskMB  uint64        = 20 * 1024
+	defaultDiskGCInterval time.Duration = 10 * time.Minute
+	diskWaitInterval      time.Duration = 30 * time.Second
+	// Work dirs without owner file are only collected after this age, in case
+	// work root is shared with others.
+	unownedWorkDirMinAge time.Duration = 1 * time.Hour
+
+	workDirPrefix      = "runner-"
+	workDirOwnerFile   = ".coordinator.pid"
+	workDirBackendFile = ".coordinator.backend"
+)
+
+type DiskUsage struct {
+	WorkRoot      string    `json:"workRoot"`
+	FreeMB        uint64    `json:"freeMB"`
+	UsedMB        uint64    `json:"usedMB"`
+	MinFreeMB     uint64    `json:"minFreeMB"`
+	WorkDirs      int       `json:"workDirs"`
+	GCRemovedDirs int       `json:"gcRemovedDirs"`
+	GCLastRun     time.Time `json:"gcLastRun,omitempty"`
+}
+
+// DiskManager owns the runner work dirs in work root, guards free disk space
+// before cloning VMs, and removes stale work dirs left by dead coordinators,
+// along with their VMs.
+type DiskManager struct {
+	logger     *zap.SugaredLogger
+	root       string
+	minFreeMB  uint64
+	gcInterval time.Duration
+	backends   map[string]Backend
+
+	lock          *sync.Mutex
+	workDirs      map[string]struct{}
+	gcRemovedDirs int
+	gcLastRun     time.Time
+}
+
+func NewDiskManager(logger *zap.SugaredLogger, workRoot string, config *DiskConfig, backends map[string]Backend) (*DiskManager, error) {
+	if workRoot == "" {
+		// Stale work dirs are removed, so work root must not be shared
+		// with others.
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, err
+		}
+		workRoot = filepath.Join(cacheDir, "github-ci-coordinator", "work")
+	}
+	root, err := filepath.Abs(workRoot)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return nil, fmt.Errorf("cannot create work root: %w", err)
+	}
+
+	minFreeMB := config.MinFreeMB
+	if minFreeMB == 0 {
+		minFreeMB = defaultMinFreeDiskMB
+	}
+	gcInterval := time.Duration(config.GCInterval)
+	if gcInterval <= 0 {
+		gcInterval = defaultDiskGCInterval
+	}
+
+	return &DiskManager{
+		logger:     logger.Named("disk"),
+		root:       root,
+		minFreeMB:  minFreeMB,
+		gcInterval: gcInterval,
+		backends:   backends,
+		lock:       new(sync.Mutex),
+		workDirs:   make(map[string]struct{}),
+	}, nil
+}
+
+func (d *DiskManager) Run(ctx context.Context, g *errgroup.Group) {
+	g.Go(func() error {
+		d.collect()
+
+		ticker := time.NewTicker(d.gcInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+				d.collect()
+			}
+		}
+	})
+}
+
+// CreateWorkDir creates a work dir for the runner slot, owned by this
+// coordinator process. VMs of the backend are cloned in sub-directories
+// named by VM name.
+func (d *DiskManager) CreateWorkDir(slot int, backend string) (string, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	dir, err := os.MkdirTemp(d.root, fmt.Sprintf("%s%d-*", workDirPrefix, slot))
+	if err != nil {
+		return "", err
+	}
+	pid := []byte(strconv.Itoa(os.Getpid()))
+	if err := os.WriteFile(filepath.Join(dir, workDirOwnerFile), pid, 0600); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(dir, workDirBackendFile), []byte(backend), 0600); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+
+	d.workDirs[dir] = struct{}{}
+	return dir, nil
+}
+
+func (d *DiskManager) RemoveWorkDir(dir string) {
+	if err := os.RemoveAll(dir); err != nil {
+		d.logger.Warnw("failed to remove work dir", "dir", dir, "error", err)
+	}
+
+	d.lock.Lock()
+	delete(d.workDirs, dir)
+	d.lock.Unlock()
+}
+
+// CheckFree checks whether free disk space would stay above the threshold
+// after using extraMB.
+func (d *DiskManager) CheckFree(extraMB uint64) error {
+	free, err := diskFreeMB(d.root)
+	if err != nil {
+		return fmt.Errorf("cannot determine free disk space: %w", err)
+	}
+	if required := d.minFreeMB + extraMB; free < required {
+		return fmt.Errorf("insufficient disk space: free %dMB, required %dMB", free, required)
+	}
+	return nil
+}
+
+// WaitFree waits until free disk space is above the threshold. Returns error
+// only if context is done.
+func (d *DiskManager) WaitFree(ctx context.Context, slot int) error {
+	collected := false
+	for {
+		err := d.CheckFree(0)
+		if err == nil {
+			return nil
+		}
+		d.logger.Warnw("slot waiting for disk space", "slot", slot, "error", err)
+
+		if !collected {
+			d.collect()
+			collected = true
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(diskWaitInterval):
+		}
+	}
+}
+
+func (d *DiskManager) Usage() DiskUsage {
+	d.lock.Lock()
+	dirs := make([]string, 0, len(d.workDirs))
+	for dir := range d.workDirs {
+		dirs = append(dirs, dir)
+	}
+	usage := DiskUsage{
+		WorkRoot:      d.root,
+		MinFreeMB:     d.minFreeMB,
+		WorkDirs:      len(dirs),
+		GCRemovedDirs: d.gcRemovedDirs,
+		GCLastRun:     d.gcLastRun,
+	}
+	d.lock.Unlock()
+
+	if free, err := diskFreeMB(d.root); err == nil {
+		usage.FreeMB = free
+	}
+	for _, dir := range dirs {
+		// Work dir may be removed concurrently; count what remains.
+		size, _ := dirSizeMB(dir)
+		usage.UsedMB += size
+	}
+	return usage
+}
+
+// collect removes stale work dirs in work root, which are not owned by live
+// coordinator processes.
+func (d *DiskManager) collect() {
+	entries, err := os.ReadDir(d.root)
+	if err != nil {
+		d.logger.Warnw("failed to read work root", "error", err)
+		return
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), workDirPrefix) {
+			continue
+		}
+		dir := filepath.Join(d.root, entry.Name())
+
+		d.lock.Lock()
+		_, live := d.workDirs[dir]
+		d.lock.Unlock()
+		if live || !d.isStale(dir, entry) {
+			continue
+		}
+
+		d.logger.Infow("removing stale work dir", "dir", dir)
+		if err := d.deleteVMs(dir); err != nil {
+			// Keep work dir to retry, so VMs are not leaked.
+			d.logger.Warnw("failed to delete VMs of stale work dir", "dir", dir, "error", err)
+			continue
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			d.logger.Warnw("failed to remove stale work dir", "dir", dir, "error", err)
+			continue
+		}
+		removed++
+	}
+
+	d.lock.Lock()
+	d.gcRemovedDirs += removed
+	d.gcLastRun = time.Now()
+	d.lock.Unlock()
+}
+
+// deleteVMs deletes VMs in the stale work dir through its backend.
+func (d *DiskManager) deleteVMs(dir string) error {
+	name, err := os.ReadFile(filepath.Join(dir, workDirBackendFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	config := &RunnerConfig{Backend: string(name)}
+	backend, err := selectBackend(d.backends, config)
+	if err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		vm := &VM{Name: entry.Name(), WorkDir: filepath.Join(dir, entry.Name()), Config: config}
+		d.logger.Infow("deleting stale VM", "name", vm.Name, "backend", config.Backend)
+		if err := backend.Delete(context.Background(), vm); err != nil {
+			return fmt.Errorf("cannot delete VM %s: %w", vm.Name, err)
+		}
+	}
+	return nil
+}
+
+func (d *DiskManager) isStale(dir string, entry fs.DirEntry) bool {
+	data, err := os.ReadFile(filepath.Join(dir, workDirOwnerFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		info, err := entry.Info()
+		return err == nil && time.Since(info.ModTime()) > unownedWorkDirMinAge
+	} else if err != nil {
+		return false
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return false
+	}
+	if pid == os.Getpid() {
+		// Owned by us, but not live anymore.
+		return true
+	}
+	return !processAlive(pid)
+}
+
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// diskFreeMB returns the free disk space available to unprivileged users
+// on the file system containing path.
+func diskFreeMB(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize) / 1024 / 1024, nil
+}
+
+// dirSizeMB returns the apparent size of files in the directory.
+func dirSizeMB(path string) (uint64, error) {
+	var size uint64
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += uint64(info.Size())
+		}
+		return nil
+	})
+	return size / 1024 / 1024, err
+}
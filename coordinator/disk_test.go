@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+type recordingBackend struct {
+	FakeBackend
+	deleted []string
+	err     error
+}
+
+func (b *recordingBackend) Delete(ctx context.Context, vm *VM) error {
+	b.deleted = append(b.deleted, vm.Name)
+	return b.err
+}
+
+// deadPID returns PID of an exited process.
+func deadPID(t *testing.T) int {
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return cmd.Process.Pid
+}
+
+func createStaleWorkDir(t *testing.T, root string, name string, backend string, vms ...string) string {
+	dir := filepath.Join(root, name)
+	for _, vm := range vms {
+		if err := os.MkdirAll(filepath.Join(dir, vm), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, workDirOwnerFile), []byte(strconv.Itoa(deadPID(t))), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, workDirBackendFile), []byte(backend), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestDiskCollect(t *testing.T) {
+	root := t.TempDir()
+	backend := &recordingBackend{}
+	disk, err := NewDiskManager(zap.NewNop().Sugar(), root, &DiskConfig{}, map[string]Backend{BackendFake: backend})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	live, err := disk.CreateWorkDir(0, BackendFake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale := createStaleWorkDir(t, root, "runner-1-1", BackendFake, "runner-1-1-1", "runner-1-1-2")
+	other := filepath.Join(root, "other")
+	if err := os.Mkdir(other, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	disk.collect()
+	sort.Strings(backend.deleted)
+	if len(backend.deleted) != 2 || backend.deleted[0] != "runner-1-1-1" || backend.deleted[1] != "runner-1-1-2" {
+		t.Errorf("unexpected deleted VMs: %v", backend.deleted)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("stale work dir not removed: %v", err)
+	}
+	for _, dir := range []string{live, other} {
+		if _, err := os.Stat(dir); err != nil {
+			t.Errorf("%s removed: %v", dir, err)
+		}
+	}
+}
+
+func TestDiskCollectDeleteFailure(t *testing.T) {
+	root := t.TempDir()
+	backend := &recordingBackend{err: errors.New("busy")}
+	disk, err := NewDiskManager(zap.NewNop().Sugar(), root, &DiskConfig{}, map[string]Backend{BackendFake: backend})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stale := createStaleWorkDir(t, root, "runner-1-1", BackendFake, "runner-1-1-1")
+	disk.collect()
+	if _, err := os.Stat(stale); err != nil {
+		t.Errorf("work dir removed with VM not deleted: %v", err)
+	}
+
+	backend.err = nil
+	disk.collect()
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("stale work dir not removed: %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/oursky/github-ci-support/coordinatorclient"
+)
+
+const defaultFakeJobDuration time.Duration = 1 * time.Minute
+
+// runFakeGuest simulates a guest for fake backend: it registers to the
+// coordinator, reports a fake runner ID, and exits after the simulated job
+// duration or when coordinator requests stop.
+func runFakeGuest() error {
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	if !scanner.Scan() {
+		return errors.New("no bootstrap message received")
+	}
+	msg, err := coordinatorclient.ParseBootstrapMessage(scanner.Text())
+	if err != nil {
+		return err
+	}
+
+	duration := defaultFakeJobDuration
+	if value, ok := msg.Env["FAKE_JOB_DURATION"]; ok {
+		if duration, err = time.ParseDuration(value); err != nil {
+			return fmt.Errorf("invalid FAKE_JOB_DURATION: %w", err)
+		}
+	}
+
+	client, err := coordinatorclient.NewClientFromBootstrap(msg)
+	if err != nil {
+		return err
+	}
+
+	runnerID := rand.New(rand.NewSource(time.Now().UnixNano())).Int63n(1<<31) + 1
+	name := fmt.Sprintf("fake-%x", runnerID)
+	reg, err := client.Register(ctx, &coordinatorclient.RegisterRequest{
+		Name:            name,
+		HostName:        name,
+		ProtocolVersion: coordinatorclient.ProtocolVersion,
+		Capabilities:    coordinatorclient.Capabilities,
+	})
+	if err != nil {
+		return fmt.Errorf("cannot register: %w", err)
+	}
+	fmt.Printf("registered as %s with labels %s\n", reg.Name, reg.Labels)
+
+	var xcodeVersions []string
+	if value, ok := msg.Env["FAKE_XCODE_VERSIONS"]; ok {
+		xcodeVersions = strings.Split(value, ",")
+	}
+	if err := client.Update(ctx, &coordinatorclient.UpdateRequest{RunnerID: &runnerID, XcodeVersions: xcodeVersions}); err != nil {
+		return fmt.Errorf("cannot update runner ID: %w", err)
+	}
+
+	waitCtx, cancelWait := context.WithTimeout(ctx, duration)
+	defer cancelWait()
+	if !coordinatorclient.HasCapability(reg.Capabilities, coordinatorclient.CapabilityWait) {
+		<-waitCtx.Done()
+		fmt.Println("fake job completed")
+		return nil
+	}
+	if err := client.WaitStop(waitCtx); err == nil {
+		fmt.Println("coordinator requested stop")
+	} else {
+		fmt.Println("fake job completed")
+	}
+	return nil
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// Layer annotations: path of file in bundle, and offset of the layer in
+	// the file, so large files can be split into chunked layers.
+	ociTitleAnnotation       = "org.opencontainers.image.title"
+	ociChunkOffsetAnnotation = "com.oursky.vm.chunk.offset"
+
+	pulledDigestFile = ".oci-digest"
+	pullProgressFile = ".oci-layers"
+	// pullBlobsDir holds blobs downloaded for a pull, so concurrent pulls
+	// of images sharing layers do not interfere.
+	pullBlobsDir = ".oci-blobs"
+)
+
+// ImagePuller pulls VM bundles from OCI registry into local image cache.
+// Each layer is a file, or a chunk of a file, in the bundle.
+type ImagePuller struct {
+	logger   *zap.SugaredLogger
+	config   *RegistryConfig
+	client   *RegistryClient
+	cacheDir string
+
+	lock *sync.Mutex
+	// bundleLocks guard pulled images by path; replacing an image waits for
+	// readers, e.g. VM clones, of the pulled image.
+	bundleLocks map[string]*sync.RWMutex
+}
+
+func NewImagePuller(logger *zap.SugaredLogger, config *RegistryConfig) (*ImagePuller, error) {
+	cacheDir := config.CacheDir
+	if cacheDir == "" {
+		userCacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, err
+		}
+		cacheDir = filepath.Join(userCacheDir, "github-ci-coordinator")
+	}
+	cacheDir, err := filepath.Abs(cacheDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ImagePuller{
+		logger:      logger.Named("puller"),
+		config:      config,
+		client:      NewRegistryClient(config),
+		cacheDir:    cacheDir,
+		lock:        new(sync.Mutex),
+		bundleLocks: make(map[string]*sync.RWMutex),
+	}, nil
+}
+
+// BundleLock returns the lock of image pulled into path. Image is not
+// replaced while read-locked.
+func (p *ImagePuller) BundleLock(path string) *sync.RWMutex {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	l, ok := p.bundleLocks[path]
+	if !ok {
+		l = new(sync.RWMutex)
+		p.bundleLocks[path] = l
+	}
+	return l
+}
+
+// ImagePath is the path of the pulled image in cache.
+func (p *ImagePuller) ImagePath(name string, version string) string {
+	return filepath.Join(p.cacheDir, "images", name, version)
+}
+
+// IsPulled checks whether the image is completely pulled into path.
+func (p *ImagePuller) IsPulled(path string) bool {
+	return p.PulledDigest(path) != ""
+}
+
+// PulledDigest returns the manifest digest of image pulled into path, or
+// empty if not pulled.
+func (p *ImagePuller) PulledDigest(path string) string {
+	data, err := os.ReadFile(filepath.Join(path, pulledDigestFile))
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// Pull pulls the image into path, unless the image pulled into path has the
+// same digest as the reference. Image pulled by tag is kept if registry is
+// not reachable. Interrupted pulls are resumed from the last completed layer
+// and partially downloaded blob.
+func (p *ImagePuller) Pull(ctx context.Context, ociRef string, path string) error {
+	ref, err := parseOCIReference(ociRef, p.config.Host)
+	if err != nil {
+		return err
+	}
+	logger := p.logger.With("ref", ref.String())
+
+	pulledDigest := p.PulledDigest(path)
+	if pulledDigest != "" && pulledDigest == ref.Reference {
+		return nil
+	}
+
+	manifest, digest, err := p.client.FetchManifest(ctx, ref)
+	if err != nil {
+		if pulledDigest != "" && ctx.Err() == nil {
+			logger.Warnw("cannot check image digest, using pulled image", "digest", pulledDigest, "error", err)
+			return nil
+		}
+		return err
+	}
+	if digest == pulledDigest {
+		return nil
+	}
+	if pulledDigest != "" {
+		logger.Infow("image changed, pulling again", "digest", digest, "pulledDigest", pulledDigest)
+	}
+
+	stagingPath := path + ".partial"
+	if data, err := os.ReadFile(filepath.Join(stagingPath, pulledDigestFile)); err == nil && string(data) != digest {
+		logger.Infow("image changed, discarding partial pull", "digest", digest)
+		if err := os.RemoveAll(stagingPath); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(stagingPath, 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(stagingPath, pulledDigestFile), []byte(digest), 0644); err != nil {
+		return err
+	}
+
+	blobDir := filepath.Join(stagingPath, pullBlobsDir)
+	if err := os.MkdirAll(blobDir, 0755); err != nil {
+		return err
+	}
+
+	done, err := readPullProgress(filepath.Join(stagingPath, pullProgressFile))
+	if err != nil {
+		return err
+	}
+	progress, err := os.OpenFile(filepath.Join(stagingPath, pullProgressFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer progress.Close()
+
+	logger.Infow("pulling image", "digest", digest, "layers", len(manifest.Layers))
+	for i, layer := range manifest.Layers {
+		if done[layerKey(layer)] {
+			continue
+		}
+
+		name, offset, err := layerTarget(layer)
+		if err != nil {
+			return err
+		}
+		logger.Infow("pulling layer",
+			"layer", fmt.Sprintf("%d/%d", i+1, len(manifest.Layers)),
+			"file", name,
+			"offset", offset,
+			"size", layer.Size,
+		)
+
+		blobPath := filepath.Join(blobDir, strings.ReplaceAll(layer.Digest, ":", "-"))
+		if _, err := os.Stat(blobPath); err != nil {
+			if err := p.client.FetchBlob(ctx, ref, layer, blobPath); err != nil {
+				return err
+			}
+		}
+		if err := writeChunk(filepath.Join(stagingPath, name), offset, blobPath); err != nil {
+			return fmt.Errorf("cannot write %s: %w", name, err)
+		}
+		if _, err := fmt.Fprintln(progress, layerKey(layer)); err != nil {
+			return err
+		}
+		// Blob is no longer needed once written to bundle.
+		os.Remove(blobPath)
+	}
+
+	progress.Close()
+	if err := os.Remove(filepath.Join(stagingPath, pullProgressFile)); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(blobDir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := p.replace(path, stagingPath, pulledDigest != ""); err != nil {
+		return err
+	}
+	logger.Infow("pulled image", "path", path, "digest", digest)
+	return nil
+}
+
+// replace moves pulled image from staging path to path, once no reader
+// uses image pulled before.
+func (p *ImagePuller) replace(path string, stagingPath string, exists bool) error {
+	oldPath := path + ".old"
+	if exists {
+		if err := os.RemoveAll(oldPath); err != nil {
+			return err
+		}
+	}
+
+	bundleLock := p.BundleLock(path)
+	bundleLock.Lock()
+	if exists {
+		if err := os.Rename(path, oldPath); err != nil {
+			bundleLock.Unlock()
+			return err
+		}
+	}
+	err := os.Rename(stagingPath, path)
+	bundleLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// Readers of old image have completed.
+	if exists {
+		os.RemoveAll(oldPath)
+	}
+	return nil
+}
+
+func layerKey(layer ociDescriptor) string {
+	return layer.Digest + " " + layer.Annotations[ociTitleAnnotation] + " " + layer.Annotations[ociChunkOffsetAnnotation]
+}
+
+func layerTarget(layer ociDescriptor) (name string, offset int64, err error) {
+	name = filepath.Clean(layer.Annotations[ociTitleAnnotation])
+	if name == "." || filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") ||
+		name == pulledDigestFile || name == pullProgressFile ||
+		name == pullBlobsDir || strings.HasPrefix(name, pullBlobsDir+"/") {
+		return "", 0, fmt.Errorf("invalid layer %s: invalid file name %q", layer.Digest, name)
+	}
+
+	if value, ok := layer.Annotations[ociChunkOffsetAnnotation]; ok {
+		offset, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || offset < 0 {
+			return "", 0, fmt.Errorf("invalid layer %s: invalid offset %q", layer.Digest, value)
+		}
+	}
+	return name, offset, nil
+}
+
+func readPullProgress(path string) (map[string]bool, error) {
+	done := make(map[string]bool)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return done, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		done[scanner.Text()] = true
+	}
+	return done, scanner.Err()
+}
+
+func writeChunk(path string, offset int64, blobPath string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	src, err := os.Open(blobPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	if _, err := dst.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		return err
+	}
+	return dst.Close()
+}
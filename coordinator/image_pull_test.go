@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func newTestPuller(t *testing.T, registry *testRegistry) *ImagePuller {
+	puller, err := NewImagePuller(zap.NewNop().Sugar(), &RegistryConfig{
+		Host:      registry.host(),
+		PlainHTTP: true,
+		CacheDir:  t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return puller
+}
+
+func checkFiles(t *testing.T, path string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		data, err := os.ReadFile(filepath.Join(path, name))
+		if err != nil || string(data) != content {
+			t.Errorf("%s: unexpected content %q, %v", name, data, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(path, pullBlobsDir)); !os.IsNotExist(err) {
+		t.Errorf("blobs not removed: %v", err)
+	}
+}
+
+func TestImagePull(t *testing.T) {
+	registry := newTestRegistry(t)
+	puller := newTestPuller(t, registry)
+	ctx := context.Background()
+	path := puller.ImagePath("vm", "1")
+
+	files := map[string]string{"disk.img": "disk content of image", "config.json": "{}"}
+	digest := registry.pushImage(t, "1", files, 8)
+	if err := puller.Pull(ctx, "vm:1", path); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, path, files)
+	if puller.PulledDigest(path) != digest {
+		t.Errorf("unexpected pulled digest: %s", puller.PulledDigest(path))
+	}
+	registry.takeRequests()
+
+	// Unchanged image is reused.
+	if err := puller.Pull(ctx, "vm:1", path); err != nil {
+		t.Fatal(err)
+	}
+	if requests := registry.takeRequests(); len(requests) != 1 {
+		t.Errorf("unexpected requests: %v", requests)
+	}
+
+	// Changed image is pulled again.
+	files = map[string]string{"disk.img": "new disk content", "config.json": "{}"}
+	digest = registry.pushImage(t, "1", files, 8)
+	if err := puller.Pull(ctx, "vm:1", path); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, path, files)
+	if puller.PulledDigest(path) != digest {
+		t.Errorf("unexpected pulled digest: %s", puller.PulledDigest(path))
+	}
+
+	// Pulled image is used if registry is not reachable.
+	registry.server.Close()
+	if err := puller.Pull(ctx, "vm:1", path); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestImagePullByDigest(t *testing.T) {
+	registry := newTestRegistry(t)
+	puller := newTestPuller(t, registry)
+	ctx := context.Background()
+	path := puller.ImagePath("vm", "1")
+
+	files := map[string]string{"disk.img": "disk content"}
+	digest := registry.pushImage(t, "1", files, 1024)
+	if err := puller.Pull(ctx, "vm@"+digest, path); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, path, files)
+	registry.takeRequests()
+
+	if err := puller.Pull(ctx, "vm@"+digest, path); err != nil {
+		t.Fatal(err)
+	}
+	if requests := registry.takeRequests(); len(requests) != 0 {
+		t.Errorf("unexpected requests: %v", requests)
+	}
+}
+
+func TestImagePullResume(t *testing.T) {
+	registry := newTestRegistry(t)
+	puller := newTestPuller(t, registry)
+	ctx := context.Background()
+	path := puller.ImagePath("vm", "1")
+
+	files := map[string]string{"disk.img": "0123456789abcdef"}
+	registry.pushImage(t, "1", files, 4)
+
+	// Interrupt pull after first layer, by failing the rest.
+	var manifest ociManifest
+	registry.lock.Lock()
+	json.Unmarshal(registry.manifests["1"], &manifest)
+	blobs := make(map[string][]byte)
+	for _, layer := range manifest.Layers[1:] {
+		blobs[layer.Digest] = registry.blobs[layer.Digest]
+		delete(registry.blobs, layer.Digest)
+	}
+	registry.lock.Unlock()
+	if err := puller.Pull(ctx, "vm:1", path); err == nil {
+		t.Fatal("expected pull to fail")
+	}
+
+	registry.lock.Lock()
+	for digest, data := range blobs {
+		registry.blobs[digest] = data
+	}
+	registry.lock.Unlock()
+	registry.takeRequests()
+	if err := puller.Pull(ctx, "vm:1", path); err != nil {
+		t.Fatal(err)
+	}
+	for _, request := range registry.takeRequests() {
+		if strings.HasSuffix(request, manifest.Layers[0].Digest) {
+			t.Error("completed layer fetched again")
+		}
+	}
+	checkFiles(t, path, files)
+	if _, err := os.Stat(path + ".partial"); !os.IsNotExist(err) {
+		t.Errorf("staging path not removed: %v", err)
+	}
+}
+
+func TestImagePullConcurrent(t *testing.T) {
+	registry := newTestRegistry(t)
+	puller := newTestPuller(t, registry)
+	ctx := context.Background()
+
+	// Images share layers of identical chunks.
+	files := map[string]string{"disk.img": "aaaaaaaabbbbbbbb"}
+	registry.pushImage(t, "1", files, 8)
+	registry.pushImage(t, "2", map[string]string{"disk.img": "aaaaaaaabbbbbbbb", "extra": "x"}, 8)
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i, version := range []string{"1", "2"} {
+		wg.Add(1)
+		go func(i int, version string) {
+			defer wg.Done()
+			errs[i] = puller.Pull(ctx, "vm:"+version, puller.ImagePath("vm", version))
+		}(i, version)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkFiles(t, puller.ImagePath("vm", "1"), files)
+	checkFiles(t, puller.ImagePath("vm", "2"), files)
+}
+
+func TestImagePullWhileInUse(t *testing.T) {
+	registry := newTestRegistry(t)
+	puller := newTestPuller(t, registry)
+	ctx := context.Background()
+
+	registry.pushImage(t, "1", map[string]string{"disk.img": "old disk"}, 8)
+	image := &Image{
+		Config: ImageConfig{Name: "vm", Version: "1", OCIRef: "vm:1", Path: puller.ImagePath("vm", "1")},
+		puller: puller,
+		lock:   new(sync.Mutex),
+	}
+	if err := image.Prepare(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Tag moves while a VM is cloned from the image.
+	files := map[string]string{"disk.img": "new disk"}
+	registry.pushImage(t, "1", files, 8)
+	cloning := make(chan struct{})
+	pulled := make(chan error, 1)
+	err := image.Use(func() error {
+		go func() {
+			<-cloning
+			pulled <- image.Prepare(ctx)
+		}()
+		close(cloning)
+
+		select {
+		case err := <-pulled:
+			t.Errorf("image replaced while in use: %v", err)
+		case <-time.After(200 * time.Millisecond):
+		}
+		checkFiles(t, image.Config.Path, map[string]string{"disk.img": "old disk"})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-pulled; err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, image.Config.Path, files)
+	if _, err := os.Stat(image.Config.Path + ".old"); !os.IsNotExist(err) {
+		t.Errorf("old image not removed: %v", err)
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// ImageConfig is an entry of base image catalog.
+type ImageConfig struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	// Path is the VM bundle path for vmctl, or the image for tart/container.
+	// Defaults to path in image cache if pulled from OCI registry.
+	Path string `json:"path,omitempty"`
+	// OCIRef is the OCI reference to pull the VM bundle from, as
+	// "[host/]repository[:tag|@digest]".
+	OCIRef string `json:"ociRef,omitempty"`
+	// Manifest is the checksum manifest of bundle files in sha256sum format,
+	// relative to bundle path. Image is not verified if empty.
+	Manifest string `json:"manifest,omitempty"`
+}
+
+type Image struct {
+	Config ImageConfig
+
+	puller   *ImagePuller
+	lock     *sync.Mutex
+	verified bool
+}
+
+// Ref is the reference to the image in runner config.
+func (i *Image) Ref() string {
+	return i.Config.Name + ":" + i.Config.Version
+}
+
+// Label is the runner label identifying the image.
+func (i *Image) Label() string {
+	return "image:" + i.Ref()
+}
+
+// Prepare pulls the image from OCI registry if needed, and verifies it.
+func (i *Image) Prepare(ctx context.Context) error {
+	if i.Config.OCIRef != "" {
+		if err := i.Pull(ctx); err != nil {
+			return err
+		}
+	}
+	return i.Verify()
+}
+
+// Pull pulls the image into image cache, if not already pulled or the image
+// of reference changed.
+func (i *Image) Pull(ctx context.Context) error {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	digest := i.puller.PulledDigest(i.Config.Path)
+	if err := i.puller.Pull(ctx, i.Config.OCIRef, i.Config.Path); err != nil {
+		return fmt.Errorf("cannot pull image %s: %w", i.Ref(), err)
+	}
+	if i.puller.PulledDigest(i.Config.Path) != digest {
+		// Verify pulled files again.
+		i.verified = false
+	}
+	return nil
+}
+
+// Use runs f with files of image, e.g. cloning VM from image; image is not
+// replaced by pulls until f returns.
+func (i *Image) Use(f func() error) error {
+	if i.Config.OCIRef == "" {
+		return f()
+	}
+	bundleLock := i.puller.BundleLock(i.Config.Path)
+	bundleLock.RLock()
+	defer bundleLock.RUnlock()
+	return f()
+}
+
+// Verify checks bundle files against the checksum manifest. Successful
+// verification is remembered, so it is performed only before first clone.
+func (i *Image) Verify() error {
+	if i.Config.Manifest == "" {
+		return nil
+	}
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	if i.verified {
+		return nil
+	}
+
+	manifest := i.Config.Manifest
+	if !filepath.IsAbs(manifest) {
+		manifest = filepath.Join(i.Config.Path, manifest)
+	}
+	if err := verifyManifest(i.Config.Path, manifest); err != nil {
+		return fmt.Errorf("image %s failed verification: %w", i.Ref(), err)
+	}
+	i.verified = true
+	return nil
+}
+
+func verifyManifest(root string, manifestPath string) error {
+	f, err := os.Open(manifestPath)
+	if err != nil {
+		return fmt.Errorf("cannot read manifest: %w", err)
+	}
+	defer f.Close()
+
+	files := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		sum, name, ok := strings.Cut(line, " ")
+		if !ok {
+			return fmt.Errorf("malformed manifest line: %q", line)
+		}
+		// sha256sum marks binary mode with '*'.
+		name = strings.TrimPrefix(strings.TrimLeft(name, " "), "*")
+		if name = filepath.Clean(name); filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("invalid file in manifest: %q", name)
+		}
+
+		actual, err := fileSHA256(filepath.Join(root, name))
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(actual, sum) {
+			return fmt.Errorf("checksum mismatch: %s", name)
+		}
+		files++
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("cannot read manifest: %w", err)
+	}
+	if files == 0 {
+		return errors.New("empty manifest")
+	}
+	return nil
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+type ImageCatalog struct {
+	Images []*Image
+}
+
+func NewImageCatalog(configs []ImageConfig, puller *ImagePuller) (*ImageCatalog, error) {
+	catalog := &ImageCatalog{}
+	refs := make(map[string]struct{})
+	for _, config := range configs {
+		if config.Name == "" || config.Version == "" {
+			return nil, fmt.Errorf("image %q: name and version are required", config.Name)
+		}
+		if strings.ContainsAny(config.Name, ":/") || strings.Contains(config.Version, "/") {
+			return nil, fmt.Errorf("image %q: name and version must not contain ':' or '/'", config.Name)
+		}
+		if config.Path == "" {
+			if config.OCIRef == "" {
+				return nil, fmt.Errorf("image %q: path or OCI reference is required", config.Name)
+			}
+			config.Path = puller.ImagePath(config.Name, config.Version)
+		}
+
+		image := &Image{Config: config, puller: puller, lock: new(sync.Mutex)}
+		if _, ok := refs[image.Ref()]; ok {
+			return nil, fmt.Errorf("image %s: duplicated", image.Ref())
+		}
+		refs[image.Ref()] = struct{}{}
+		catalog.Images = append(catalog.Images, image)
+	}
+	return catalog, nil
+}
+
+// Resolve finds image by "<name>:<version>", or by "<name>" if only one
+// version of the image is in catalog.
+func (c *ImageCatalog) Resolve(ref string) (*Image, error) {
+	name, version, hasVersion := strings.Cut(ref, ":")
+
+	var found *Image
+	for _, image := range c.Images {
+		if image.Config.Name != name {
+			continue
+		}
+		if hasVersion {
+			if image.Config.Version == version {
+				return image, nil
+			}
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("image %s: multiple versions in catalog, version is required", ref)
+		}
+		found = image
+	}
+
+	if found == nil {
+		return nil, fmt.Errorf("image %s: not found in catalog", ref)
+	}
+	return found, nil
+}
+
+// selectImages resolves the image references, or returns all images if
+// none is given.
+func (c *ImageCatalog) selectImages(refs []string) ([]*Image, error) {
+	if len(refs) == 0 {
+		return c.Images, nil
+	}
+
+	var images []*Image
+	for _, ref := range refs {
+		image, err := c.Resolve(ref)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, image)
+	}
+	return images, nil
+}
+
+// ApplyImage points the runner config to the image and labels the runner
+// with it.
+func ApplyImage(config *RunnerConfig, image *Image) {
+	switch config.Backend {
+	case "", BackendVMCtl:
+		config.BaseVMBundlePath = image.Config.Path
+	default:
+		config.BaseImage = image.Config.Path
+	}
+
+	labels := make([]string, 0, len(config.Labels)+1)
+	labels = append(labels, config.Labels...)
+	config.Labels = append(labels, image.Label())
+}
+
+// runImagesCommand implements "images list", "images pull [ref...]" and
+// "images verify [ref...]".
+func runImagesCommand(ctx context.Context, config *Config, catalog *ImageCatalog, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: images list|pull|verify [image...]")
+	}
+
+	switch args[0] {
+	case "list":
+		usedBy := make(map[*Image][]string)
+		for i, runnerConfig := range config.Runners {
+			if runnerConfig.Image == "" {
+				continue
+			}
+			if image, err := catalog.Resolve(runnerConfig.Image); err == nil {
+				usedBy[image] = append(usedBy[image], fmt.Sprint(i))
+			}
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tVERSION\tPATH\tSOURCE\tMANIFEST\tRUNNERS")
+		for _, image := range catalog.Images {
+			manifest := image.Config.Manifest
+			if manifest == "" {
+				manifest = "-"
+			}
+			source := "-"
+			if image.Config.OCIRef != "" {
+				source = image.Config.OCIRef
+				if !image.puller.IsPulled(image.Config.Path) {
+					source += " (not pulled)"
+				}
+			}
+			runners := strings.Join(usedBy[image], ",")
+			if runners == "" {
+				runners = "-"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+				image.Config.Name, image.Config.Version, image.Config.Path, source, manifest, runners)
+		}
+		return w.Flush()
+
+	case "pull":
+		images, err := catalog.selectImages(args[1:])
+		if err != nil {
+			return err
+		}
+		for _, image := range images {
+			if image.Config.OCIRef == "" {
+				continue
+			}
+			if err := image.Pull(ctx); err != nil {
+				return err
+			}
+			fmt.Printf("%s: pulled to %s\n", image.Ref(), image.Config.Path)
+		}
+		return nil
+
+	case "verify":
+		images, err := catalog.selectImages(args[1:])
+		if err != nil {
+			return err
+		}
+
+		failed := 0
+		for _, image := range images {
+			switch err := image.Verify(); {
+			case err != nil:
+				fmt.Printf("%s: FAILED: %s\n", image.Ref(), err)
+				failed++
+			case image.Config.Manifest == "":
+				fmt.Printf("%s: SKIPPED: no manifest\n", image.Ref())
+			default:
+				fmt.Printf("%s: OK\n", image.Ref())
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d image(s) failed verification", failed)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unknown images command: %s", args[0])
+}
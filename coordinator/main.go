@@ -22,6 +22,14 @@ func main() {
 
 	flag.Parse()
 
+	if flag.Arg(0) == "fake-guest" {
+		if err := runFakeGuest(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if configPath == "" {
 		panic("config is required")
 	}
@@ -35,30 +43,27 @@ func main() {
 		panic(fmt.Sprintf("cannot load config: %s", err))
 	}
 
-	httpClient, err := config.Auth.CreateClient()
+	puller, err := NewImagePuller(logger, &config.Registry)
 	if err != nil {
-		panic(fmt.Sprintf("cannot create client: %s", err))
+		panic(fmt.Sprintf("cannot setup image puller: %s", err))
 	}
-	httpClient.Timeout = 10 * time.Second
-	client := github.NewClient(httpClient)
-
-	target, err := githublib.NewRunnerTarget(config.Target)
+	catalog, err := NewImageCatalog(config.Images, puller)
 	if err != nil {
-		panic(fmt.Sprintf("cannot load target: %s", err))
+		panic(fmt.Sprintf("cannot load image catalog: %s", err))
 	}
 
-	server := NewServer(logger, target, client)
-	monitor := NewMonitor(logger, target, client)
-
-	var runners []*Runner
-	for i, runnerConfig := range config.Runners {
-		runner := NewRunner(i, logger, config, runnerConfig, server, monitor)
-		runners = append(runners, runner)
+	if args := flag.Args(); len(args) > 0 {
+		ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+		defer cancel()
+		if err := runCommand(ctx, config, catalog, args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	g, ctx := errgroup.WithContext(ctx)
-	start(ctx, g, server, monitor, runners)
 
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
@@ -68,16 +73,230 @@ func main() {
 		cancel()
 	}()
 
-	err = g.Wait()
+	scheduler := NewScheduler(logger, &config.Host)
+
+	var service RunnerService
+	var githubService *GitHubRunnerService
+	var agent *ClusterAgent
+	switch {
+	case config.Cluster == nil:
+		githubService = newGitHubRunnerService(ctx, logger, config)
+		service = githubService
+
+	case config.Cluster.Role == ClusterRoleController:
+		var controller *ClusterController
+		var controllerService RunnerService
+		if config.Target == FakeTarget {
+			controllerService = NewFakeRunnerService(logger, func() []RemoteRunner {
+				return controller.reportedRunners()
+			})
+		} else {
+			controllerService = newGitHubRunnerService(ctx, logger, config)
+		}
+		ownership := newOwnership(config)
+		sweeper := newSweeper(logger, config, controllerService, ownership)
+		controller = NewClusterController(logger, config.Cluster, controllerService, ownership, sweeper)
+		controller.Run(ctx, g)
+		if sweeper != nil {
+			sweeper.Run(ctx, g)
+		}
+		wait(logger, g)
+		return
+
+	case config.Cluster.Role == ClusterRoleAgent:
+		agent, err = NewClusterAgent(logger, config.Cluster, scheduler)
+		if err != nil {
+			panic(fmt.Sprintf("cannot setup cluster agent: %s", err))
+		}
+		if err := agent.Connect(ctx); err != nil {
+			logger.Fatalw("cannot connect to controller", "error", err)
+		}
+		service = agent
+		if config.Ownership == nil {
+			config.Ownership = agent.Ownership()
+		}
+
+	default:
+		panic(fmt.Sprintf("unknown cluster role: %s", config.Cluster.Role))
+	}
+
+	ownership := newOwnership(config)
+	server, err := NewServer(logger, &config.Server, service, ownership)
+	if err != nil {
+		panic(fmt.Sprintf("cannot create server: %s", err))
+	}
+	if agent != nil {
+		server.SetRunnerHost(agent.Name())
+	}
+	rolloutStatePath, err := config.StatePath("rollouts.json")
+	if err != nil {
+		panic(fmt.Sprintf("cannot setup state dir: %s", err))
+	}
+	rollouts, err := NewRollouts(logger, config.Rollouts, catalog, rolloutStatePath)
+	if err != nil {
+		panic(fmt.Sprintf("cannot load rollouts: %s", err))
+	}
+
+	var sweeper *Sweeper
+	if agent == nil {
+		sweeper = newSweeper(logger, config, service, ownership)
+	} else if config.Sweeper != nil {
+		logger.Warn("sweeper is run by cluster controller, ignoring sweeper config")
+	}
+	deletionStatePath, err := config.StatePath("deletions.json")
+	if err != nil {
+		panic(fmt.Sprintf("cannot setup state dir: %s", err))
+	}
+	deletions, err := NewDeletionQueue(logger, service, deletionStatePath)
+	if err != nil {
+		panic(fmt.Sprintf("cannot load deletion queue: %s", err))
+	}
+	backends := NewBackends(config)
+	disk, err := NewDiskManager(logger, config.WorkRoot, &config.Disk, backends)
+	if err != nil {
+		panic(fmt.Sprintf("cannot setup work root: %s", err))
+	}
+
+	var demand *Demand
+	if config.Demand != nil {
+		if githubService != nil {
+			demand = NewDemand(logger, config.Demand, githubService, ownership, config.Runners)
+		} else {
+			logger.Warn("demand is only computed in standalone mode, ignoring demand config")
+		}
+	}
+
+	capacity, err := NewCapacity(logger, config.CapacitySchedules, config.Runners)
+	if err != nil {
+		panic(fmt.Sprintf("cannot load capacity schedules: %s", err))
+	}
+
+	labeler := NewRunnerLabeler(logger, service, ownership, disk, server.RunnerHost())
+	monitor := NewMonitor(logger, service, rollouts, ownership, sweeper, deletions, labeler, capacity)
+
+	var runners []*Runner
+	for i, runnerConfig := range config.Runners {
+		var image *Image
+		if runnerConfig.Image != "" {
+			image, err = catalog.Resolve(runnerConfig.Image)
+			if err != nil {
+				panic(fmt.Sprintf("cannot load runner %d: %s", i, err))
+			}
+		}
+		slots := capacity.Slots(i)
+
+		backend, err := selectBackend(backends, &runnerConfig)
+		if err != nil {
+			panic(fmt.Sprintf("cannot load runner %d: %s", i, err))
+		}
+		if _, err := RenderRunnerName(&runnerConfig, NewRunnerNameData(server.HostName(), &runnerConfig, slots[0], 0)); err != nil {
+			panic(fmt.Sprintf("cannot load runner %d: %s", i, err))
+		}
+		resources, err := RunnerResources(&runnerConfig)
+		if err != nil {
+			panic(fmt.Sprintf("cannot load runner %d resources: %s", i, err))
+		}
+		if err := scheduler.Validate(resources); err != nil {
+			panic(fmt.Sprintf("cannot load runner %d: %s", i, err))
+		}
+		for _, slot := range slots {
+			if image != nil {
+				rollouts.AddSlot(runnerConfig.Pool, slot, image)
+			}
+			runner := NewRunner(slot, logger, backend, runnerConfig, image, rollouts, resources, scheduler, server, monitor, disk, agent, capacity)
+			runners = append(runners, runner)
+		}
+	}
+
+	start(ctx, g, server, monitor, disk, runners)
+	capacity.Run(ctx, g)
+	labeler.Run(ctx, g)
+
+	if agent != nil {
+		agent.SetMonitor(monitor)
+		agent.Run(ctx, g)
+	}
+	if sweeper != nil {
+		sweeper.Run(ctx, g)
+	}
+	if demand != nil {
+		demand.Run(ctx, g)
+	}
+	if config.Webhook != nil {
+		webhook := NewWebhook(logger, config.Webhook, monitor)
+		webhook.Run(ctx, g)
+	}
+	if config.Admin != nil {
+		admin := NewAdmin(logger, config.Admin, disk, scheduler, rollouts, deletions, demand, capacity)
+		admin.Run(ctx, g)
+	}
+
+	wait(logger, g)
+}
+
+func newGitHubRunnerService(ctx context.Context, logger *zap.SugaredLogger, config *Config) *GitHubRunnerService {
+	httpClient, err := config.Auth.CreateClient()
+	if err != nil {
+		panic(fmt.Sprintf("cannot create client: %s", err))
+	}
+	httpClient.Timeout = 10 * time.Second
+	client := github.NewClient(httpClient)
+
+	target, err := githublib.NewRunnerTarget(config.Target)
+	if err != nil {
+		panic(fmt.Sprintf("cannot load target: %s", err))
+	}
+
+	service := NewGitHubRunnerService(logger, target, client)
+	if err := SetupRunnerGroups(ctx, logger, service, config.RunnerGroups, config.Runners); err != nil {
+		panic(fmt.Sprintf("cannot setup runner groups: %s", err))
+	}
+	return service
+}
+
+func newOwnership(config *Config) *Ownership {
+	ownership, err := NewOwnership(config.Ownership)
+	if err != nil {
+		panic(fmt.Sprintf("cannot load ownership: %s", err))
+	}
+	return ownership
+}
+
+func newSweeper(logger *zap.SugaredLogger, config *Config, service RunnerService, ownership *Ownership) *Sweeper {
+	if config.Sweeper == nil {
+		return nil
+	}
+	auditLog := config.Sweeper.AuditLog
+	if auditLog == "" {
+		var err error
+		auditLog, err = config.StatePath("sweeper-audit.log")
+		if err != nil {
+			panic(fmt.Sprintf("cannot setup state dir: %s", err))
+		}
+	}
+	return NewSweeper(logger, config.Sweeper, service, ownership, auditLog)
+}
+
+func wait(logger *zap.SugaredLogger, g *errgroup.Group) {
+	err := g.Wait()
 	if err != nil {
 		logger.Fatalw("error occured", "error", err)
 	}
 }
 
-func start(ctx context.Context, g *errgroup.Group, server *Server, monitor *Monitor, runners []*Runner) {
-	port := server.Run(ctx, g)
+func runCommand(ctx context.Context, config *Config, catalog *ImageCatalog, args []string) error {
+	switch args[0] {
+	case "images":
+		return runImagesCommand(ctx, config, catalog, args[1:])
+	}
+	return fmt.Errorf("unknown command: %s", args[0])
+}
+
+func start(ctx context.Context, g *errgroup.Group, server *Server, monitor *Monitor, disk *DiskManager, runners []*Runner) {
+	server.Run(ctx, g)
 	monitor.Run(ctx, g)
+	disk.Run(ctx, g)
 	for _, runner := range runners {
-		runner.Run(ctx, g, port)
+		runner.Run(ctx, g)
 	}
 }
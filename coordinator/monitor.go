@@ -4,18 +4,14 @@ import (
 	"context"
 	"time"
 
-	"github.com/google/go-github/v45/github"
-	"github.com/oursky/github-ci-support/githublib"
 	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
 )
 
-const (
-	transitionTimeoutEpochs int64 = 10
-)
-
 type RunnerState string
 
+const drainRetryDelay time.Duration = 30 * time.Second
+
 const (
 	RunnerStatePending     RunnerState = "pending"
 	RunnerStateConfiguring RunnerState = "configuring"
@@ -27,14 +23,21 @@ const (
 type localRunner struct {
 	instanceID uint32
 	instance   *RunnerInstance
+	config     *RunnerConfig
+	image      *Image
 	isDead     bool
 
 	epoch              int64
 	lastTransitionTime time.Time
 	state              RunnerState
 
-	runnerName string
-	runnerID   int64
+	runnerName    string
+	runnerID      int64
+	xcodeVersions []string
+
+	// Draining runners are being deleted before instance is stopped.
+	isDraining   bool
+	drainRetryAt time.Time
 }
 
 func (r *localRunner) update(epoch int64, state RunnerState) {
@@ -46,34 +49,86 @@ func (r *localRunner) update(epoch int64, state RunnerState) {
 	r.state = state
 }
 
+// elapsed returns the wall-clock time spent in current state, as observed by
+// the remote runners sync. Syncs begun before the transition count as zero,
+// so a fresh sync is always needed before a runner is considered overdue.
+func (r *localRunner) elapsed(remote *RemoteRunners) time.Duration {
+	elapsed := remote.BeginTime.Sub(r.lastTransitionTime)
+	if elapsed < 0 {
+		return 0
+	}
+	return elapsed
+}
+
+// isBooting checks whether the runner has not become ready yet.
+func (r *localRunner) isBooting() bool {
+	switch r.state {
+	case RunnerStatePending, RunnerStateConfiguring, RunnerStateStarting:
+		return true
+	}
+	return false
+}
+
+func (r *localRunner) isOverdue(remote *RemoteRunners) bool {
+	timeout := r.config.Timeouts.For(r.state)
+	return timeout > 0 && r.elapsed(remote) > timeout
+}
+
 type Monitor struct {
-	logger *zap.SugaredLogger
-	target githublib.RunnerTarget
-	client *github.Client
+	logger    *zap.SugaredLogger
+	service   RunnerService
+	rollouts  *Rollouts
+	ownership *Ownership
+	sweeper   *Sweeper
+	deletions *DeletionQueue
+	labeler   *RunnerLabeler
+	capacity  *Capacity
+	// ctx is the context of monitor run, for background operations.
+	ctx context.Context
 
 	localRunners map[uint32]*localRunner
 	remote       *RemoteRunners
+	// remoteUpdates are incremental updates not yet reflected in a full sync.
+	remoteUpdates map[string]MonitorMsgRemoteUpdate
 
 	messages chan any
 }
 
-func NewMonitor(logger *zap.SugaredLogger, target githublib.RunnerTarget, client *github.Client) *Monitor {
+func NewMonitor(
+	logger *zap.SugaredLogger,
+	service RunnerService,
+	rollouts *Rollouts,
+	ownership *Ownership,
+	sweeper *Sweeper,
+	deletions *DeletionQueue,
+	labeler *RunnerLabeler,
+	capacity *Capacity,
+) *Monitor {
 	return &Monitor{
-		logger:       logger.Named("monitor"),
-		target:       target,
-		client:       client,
-		localRunners: make(map[uint32]*localRunner),
-		remote:       &RemoteRunners{Epoch: 0, BeginTime: time.Now(), Runners: nil},
-		messages:     make(chan any),
+		logger:        logger.Named("monitor"),
+		service:       service,
+		rollouts:      rollouts,
+		ownership:     ownership,
+		sweeper:       sweeper,
+		deletions:     deletions,
+		labeler:       labeler,
+		capacity:      capacity,
+		ctx:           context.Background(),
+		localRunners:  make(map[uint32]*localRunner),
+		remote:        &RemoteRunners{Epoch: 0, BeginTime: time.Now(), Runners: nil},
+		remoteUpdates: make(map[string]MonitorMsgRemoteUpdate),
+		messages:      make(chan any),
 	}
 }
 
 func (m *Monitor) Run(ctx context.Context, g *errgroup.Group) {
 	syncContext, stopSync := context.WithCancel(context.Background())
-	synchronizer := NewSynchronizer(m.logger, m.target, m.client)
 	sync := make(chan *RemoteRunners)
 
-	synchronizer.Run(syncContext, g, sync)
+	m.service.RunSync(syncContext, g, sync)
+	// Deletions of runners cleaned up on exit are attempted until exit.
+	m.deletions.Run(syncContext, g)
+	m.ctx = ctx
 	g.Go(func() error {
 		m.run(ctx, sync, stopSync)
 		return nil
@@ -84,6 +139,24 @@ func (m *Monitor) Post(msg any) {
 	m.messages <- msg
 }
 
+func (m *Monitor) PostContext(ctx context.Context, msg any) error {
+	select {
+	case m.messages <- msg:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Instances returns status of instances tracked by monitor.
+func (m *Monitor) Instances(ctx context.Context) ([]InstanceStatus, error) {
+	result := make(chan []InstanceStatus, 1)
+	if err := m.PostContext(ctx, MonitorMsgInstances{Result: result}); err != nil {
+		return nil, err
+	}
+	return <-result, nil
+}
+
 func (m *Monitor) run(ctx context.Context, sync <-chan *RemoteRunners, stopSync func()) {
 	exit := false
 
@@ -93,8 +166,9 @@ func (m *Monitor) run(ctx context.Context, sync <-chan *RemoteRunners, stopSync
 			exit = true
 
 		case remote := <-sync:
-			m.remote = remote
+			m.setRemote(remote)
 			m.checkRunners()
+			m.observeOrphans()
 
 		case msg := <-m.messages:
 			m.handleMessage(msg)
@@ -106,7 +180,7 @@ func (m *Monitor) run(ctx context.Context, sync <-chan *RemoteRunners, stopSync
 	for len(m.localRunners) > 0 {
 		select {
 		case remote := <-sync:
-			m.remote = remote
+			m.setRemote(remote)
 			m.checkRunners()
 
 		case msg := <-m.messages:
@@ -117,11 +191,51 @@ func (m *Monitor) run(ctx context.Context, sync <-chan *RemoteRunners, stopSync
 	stopSync()
 }
 
+func (m *Monitor) setRemote(remote *RemoteRunners) {
+	// Runners of other coordinators sharing the target are invisible.
+	m.remote = &RemoteRunners{
+		BeginTime: remote.BeginTime,
+		Epoch:     remote.Epoch,
+		Runners:   m.ownership.Filter(remote.Runners),
+	}
+	for name, update := range m.remoteUpdates {
+		if update.Time.Before(remote.BeginTime) {
+			// Superseded by full sync.
+			delete(m.remoteUpdates, name)
+			continue
+		}
+		m.applyRemoteUpdate(update)
+	}
+}
+
+func (m *Monitor) observeOrphans() {
+	if m.sweeper == nil {
+		return
+	}
+	tracked := make(map[string]bool)
+	for _, runner := range m.localRunners {
+		if runner.runnerName != "" {
+			tracked[runner.runnerName] = true
+		}
+	}
+	m.sweeper.Observe(m.remote, tracked)
+}
+
+func (m *Monitor) applyRemoteUpdate(update MonitorMsgRemoteUpdate) {
+	if m.remote.Runners == nil {
+		m.remote.Runners = make(map[string]RemoteRunner)
+	}
+	if update.Deleted {
+		delete(m.remote.Runners, update.Runner.Name)
+	} else {
+		m.remote.Runners[update.Runner.Name] = update.Runner
+	}
+}
+
 func (m *Monitor) terminate(runner *localRunner) {
-	isOverdue := (m.remote.Epoch - runner.epoch) > transitionTimeoutEpochs
 	done := true
 	if !runner.isDead {
-		runner.instance.Terminate(isOverdue)
+		runner.instance.Terminate(runner.isOverdue(m.remote))
 		done = false
 	}
 
@@ -130,15 +244,8 @@ func (m *Monitor) terminate(runner *localRunner) {
 			"runnerID", r.ID,
 			"runnerName", runner.runnerName,
 		)
-
-		if err := m.target.DeleteRunner(context.Background(), m.client, r.ID); err != nil {
-			m.logger.Warnw("failed to delete runner", "error", err)
-			if isOverdue {
-				m.logger.Warnw("retry count exceeded, abandoning")
-			} else {
-				done = false
-			}
-		}
+		// Deletions are retried by queue, even after runner is removed.
+		m.deletions.Enqueue(r.ID, runner.runnerName)
 	}
 
 	if m.remote.Epoch == runner.epoch {
@@ -155,6 +262,7 @@ func (m *Monitor) terminate(runner *localRunner) {
 		"runnerName", runner.runnerName,
 	)
 	delete(m.localRunners, runner.instanceID)
+	m.labeler.Forget(runner.runnerID)
 }
 
 func (m *Monitor) handleMessage(msg any) {
@@ -163,6 +271,8 @@ func (m *Monitor) handleMessage(msg any) {
 		runner := &localRunner{
 			instanceID: msg.InstanceID,
 			instance:   msg.Instance,
+			config:     msg.Instance.Config,
+			image:      msg.Instance.vm.Image,
 		}
 		runner.update(m.remote.Epoch, RunnerStatePending)
 
@@ -192,6 +302,11 @@ func (m *Monitor) handleMessage(msg any) {
 
 			runner.runnerID = msg.RunnerID
 			runner.update(m.remote.Epoch, RunnerStateStarting)
+			m.service.Resync()
+		}
+
+		if msg.XcodeVersions != nil {
+			runner.xcodeVersions = msg.XcodeVersions
 		}
 
 	case MonitorMsgExited:
@@ -202,20 +317,109 @@ func (m *Monitor) handleMessage(msg any) {
 			"runnerID", runner.runnerID,
 		)
 
+		if runner.isBooting() {
+			m.rollouts.RecordBoot(runner.image, false)
+		}
 		runner.update(m.remote.Epoch, RunnerStateTerminating)
 		runner.isDead = true
 		m.terminate(runner)
+		m.service.Resync()
+
+	case MonitorMsgInstances:
+		instances := make([]InstanceStatus, 0, len(m.localRunners))
+		for _, runner := range m.localRunners {
+			status := InstanceStatus{
+				ID:         runner.instanceID,
+				Slot:       runner.instance.slot,
+				State:      runner.state,
+				RunnerName: runner.runnerName,
+				RunnerID:   runner.runnerID,
+			}
+			if runner.image != nil {
+				status.Image = runner.image.Ref()
+			}
+			instances = append(instances, status)
+		}
+		msg.Result <- instances
+
+	case MonitorMsgJobCompleted:
+		for _, runner := range m.localRunners {
+			if runner.runnerName != msg.RunnerName {
+				continue
+			}
+			m.logger.Infow("job completed",
+				"id", runner.instanceID,
+				"runnerName", runner.runnerName,
+				"conclusion", msg.Conclusion,
+			)
+			switch msg.Conclusion {
+			case "success":
+				m.rollouts.RecordJob(runner.image, true)
+			case "failure", "timed_out":
+				m.rollouts.RecordJob(runner.image, false)
+			}
+		}
+
+	case MonitorMsgDrained:
+		runner, ok := m.localRunners[msg.InstanceID]
+		if !ok {
+			break
+		}
+		runner.isDraining = false
+		if msg.Error != nil {
+			m.logger.Infow("cannot drain runner, retrying later",
+				"id", runner.instanceID,
+				"runnerName", runner.runnerName,
+				"error", msg.Error,
+			)
+			runner.drainRetryAt = time.Now().Add(drainRetryDelay)
+			break
+		}
+		if runner.state != RunnerStateReady {
+			break
+		}
+		m.logger.Infow("runner drained",
+			"id", runner.instanceID,
+			"runnerName", runner.runnerName,
+		)
+		// Already deleted, no need to unregister again.
+		m.applyRemoteUpdate(MonitorMsgRemoteUpdate{
+			Runner:  RemoteRunner{ID: runner.runnerID, Name: runner.runnerName},
+			Deleted: true,
+			Time:    time.Now(),
+		})
+		runner.update(m.remote.Epoch, RunnerStateTerminating)
+		m.terminate(runner)
+		m.service.Resync()
+
+	case MonitorMsgRemoteUpdate:
+		if !m.ownership.Owns(msg.Runner) {
+			break
+		}
+		m.logger.Debugw("received remote runner update",
+			"runnerID", msg.Runner.ID,
+			"runnerName", msg.Runner.Name,
+			"online", msg.Runner.IsOnline,
+			"deleted", msg.Deleted,
+		)
+		m.remoteUpdates[msg.Runner.Name] = msg
+		m.applyRemoteUpdate(msg)
+		m.checkRunners()
 	}
 }
 
 func (m *Monitor) checkTimeout(runner *localRunner) bool {
-	if (m.remote.Epoch - runner.epoch) > transitionTimeoutEpochs {
+	if runner.isOverdue(m.remote) {
 		m.logger.Warnw("runner timed out, terminating",
 			"id", runner.instanceID,
 			"runnerName", runner.runnerName,
-			"elapsed", m.remote.BeginTime.Sub(runner.lastTransitionTime).String(),
+			"state", runner.state,
+			"elapsed", runner.elapsed(m.remote).String(),
 		)
 
+		if runner.isBooting() {
+			m.rollouts.RecordBoot(runner.image, false)
+		}
 		runner.update(m.remote.Epoch, RunnerStateTerminating)
 		runner.instance.Terminate(true)
 		m.terminate(runner)
@@ -229,7 +433,9 @@ func (m *Monitor) checkRunners() {
 		"count", len(m.localRunners),
 	)
 
+	transitioned := false
 	for _, runner := range m.localRunners {
+		state := runner.state
 		switch runner.state {
 		case RunnerStatePending:
 			m.checkTimeout(runner)
@@ -248,6 +454,8 @@ func (m *Monitor) checkRunners() {
 					"runnerName", runner.runnerName,
 				)
 				runner.update(m.remote.Epoch, RunnerStateReady)
+				m.rollouts.RecordBoot(runner.image, true)
+				m.updateLabels(runner)
 			}
 
 		case RunnerStateReady:
@@ -261,12 +469,61 @@ func (m *Monitor) checkRunners() {
 
 				runner.update(m.remote.Epoch, RunnerStateTerminating)
 				m.terminate(runner)
+			} else if !m.capacity.IsActive(runner.instance.slot) {
+				// Busy runners are ephemeral and exit after job completed;
+				// busy flag may be stale, so it only saves attempts.
+				if !r.IsBusy {
+					m.drain(runner)
+				}
+			} else {
+				m.updateLabels(runner)
 			}
 
 		case RunnerStateTerminating:
 			m.terminate(runner)
 		}
+
+		if runner.state != state {
+			transitioned = true
+		}
+	}
+
+	if transitioned {
+		// Terminating runners need a fresh sync to be removed, check again
+		// soon; otherwise follow regular sync interval.
+		m.service.Resync()
+	}
+}
+
+// drain deletes the runner of inactive slot in background, and stops its
+// instance once deleted. GitHub refuses to delete busy runners, so a runner
+// picking up a job meanwhile is not interrupted.
+func (m *Monitor) drain(runner *localRunner) {
+	if runner.isDraining || time.Now().Before(runner.drainRetryAt) {
+		return
+	}
+	m.logger.Infow("draining idle runner of inactive slot",
+		"id", runner.instanceID,
+		"runnerName", runner.runnerName,
+		"slot", runner.instance.slot,
+	)
+	runner.isDraining = true
+
+	ctx := m.ctx
+	instanceID, runnerID := runner.instanceID, runner.runnerID
+	go func() {
+		err := m.service.DeleteRunner(ctx, runnerID)
+		m.PostContext(ctx, MonitorMsgDrained{InstanceID: instanceID, Error: err})
+	}()
+}
+
+// updateLabels updates labels of ready runner, if runtime labels are enabled.
+func (m *Monitor) updateLabels(runner *localRunner) {
+	if !runner.config.RuntimeLabels.Enabled() {
+		return
 	}
+	labels := m.labeler.Labels(runner.config, runner.image, runner.xcodeVersions)
+	m.labeler.Update(runner.runnerID, runner.runnerName, labels)
 }
 
 func (m *Monitor) cleanupRunners() {
@@ -1,17 +1,40 @@
 package main
 
+import "time"
+
 type MonitorMsgRegister struct {
 	InstanceID uint32
 	Instance   *RunnerInstance
 }
 
 type MonitorMsgUpdate struct {
-	InstanceID uint32
-	RunnerName string
-	RunnerID   int64
+	InstanceID    uint32
+	RunnerName    string
+	RunnerID      int64
+	XcodeVersions []string
 }
 
 type MonitorMsgExited struct {
 	InstanceID uint32
 	RunnerName string
 }
+
+type MonitorMsgInstances struct {
+	Result chan<- []InstanceStatus
+}
+
+type MonitorMsgJobCompleted struct {
+	RunnerName string
+	Conclusion string
+}
+
+type MonitorMsgDrained struct {
+	InstanceID uint32
+	Error      error
+}
+
+type MonitorMsgRemoteUpdate struct {
+	Runner  RemoteRunner
+	Deleted bool
+	Time    time.Time
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ownershipIDPattern excludes "-", the separator of runner name prefix, so
+// that no ID is a prefix of another (e.g. "ci" and "ci-mac").
+var ownershipIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.]*$`)
+
+// OwnershipConfig namespaces runners of this coordinator, so that multiple
+// coordinators can share a runner target.
+type OwnershipConfig struct {
+	// ID is the coordinator ID, used as prefix of runner names; letters,
+	// digits, "_" and ".".
+	ID string `json:"id"`
+	// Label additionally labels runners with "coordinator-<ID>".
+	Label bool `json:"label,omitempty"`
+}
+
+// Ownership identifies runners owned by this coordinator. Without ID, all
+// runners of target are owned.
+type Ownership struct {
+	id    string
+	label bool
+}
+
+func NewOwnership(config *OwnershipConfig) (*Ownership, error) {
+	if config == nil {
+		return &Ownership{}, nil
+	}
+	if !ownershipIDPattern.MatchString(config.ID) {
+		return nil, fmt.Errorf("invalid coordinator ID: %q", config.ID)
+	}
+	return &Ownership{id: config.ID, label: config.Label}, nil
+}
+
+func (o *Ownership) ID() string {
+	return o.id
+}
+
+// Config returns the config of ownership, or nil if without ID.
+func (o *Ownership) Config() *OwnershipConfig {
+	if o.id == "" {
+		return nil
+	}
+	return &OwnershipConfig{ID: o.id, Label: o.label}
+}
+
+func (o *Ownership) prefix() string {
+	return o.id + "-"
+}
+
+func (o *Ownership) ownerLabel() string {
+	return "coordinator-" + o.id
+}
+
+// RunnerName returns the runner name to register with, prefixed by the
+// coordinator ID.
+func (o *Ownership) RunnerName(name string) string {
+	if o.id == "" || strings.HasPrefix(name, o.prefix()) {
+		return name
+	}
+	return o.prefix() + name
+}
+
+// RunnerLabels returns the labels to register with, including the owner
+// label if enabled.
+func (o *Ownership) RunnerLabels(labels []string) []string {
+	if o.id == "" || !o.label {
+		return labels
+	}
+	for _, l := range labels {
+		if l == o.ownerLabel() {
+			return labels
+		}
+	}
+	return append(append([]string(nil), labels...), o.ownerLabel())
+}
+
+// Owns checks whether the remote runner is owned by this coordinator. Labels
+// are checked only if known, since webhook events may not include them.
+func (o *Ownership) Owns(runner RemoteRunner) bool {
+	if o.id == "" {
+		return true
+	}
+	if !strings.HasPrefix(runner.Name, o.prefix()) {
+		return false
+	}
+	if o.label && runner.Labels != nil {
+		for _, l := range runner.Labels {
+			if l == o.ownerLabel() {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Filter returns the runners owned by this coordinator.
+func (o *Ownership) Filter(runners map[string]RemoteRunner) map[string]RemoteRunner {
+	if o.id == "" {
+		return runners
+	}
+	owned := make(map[string]RemoteRunner)
+	for name, runner := range runners {
+		if o.Owns(runner) {
+			owned[name] = runner
+		}
+	}
+	return owned
+}
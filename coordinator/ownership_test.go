@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestOwnershipID(t *testing.T) {
+	for _, id := range []string{"ci", "ci.mac", "ci_2"} {
+		if _, err := NewOwnership(&OwnershipConfig{ID: id}); err != nil {
+			t.Errorf("%q: %v", id, err)
+		}
+	}
+	for _, id := range []string{"", "ci-mac", "-ci", ".ci", "ci mac"} {
+		if _, err := NewOwnership(&OwnershipConfig{ID: id}); err == nil {
+			t.Errorf("%q: expected invalid", id)
+		}
+	}
+}
+
+func TestOwnershipOwns(t *testing.T) {
+	ci, _ := NewOwnership(&OwnershipConfig{ID: "ci"})
+	ciMac, _ := NewOwnership(&OwnershipConfig{ID: "ci.mac", Label: true})
+
+	cases := []struct {
+		ownership *Ownership
+		runner    RemoteRunner
+		owns      bool
+	}{
+		{ci, RemoteRunner{Name: "ci-host-1"}, true},
+		{ci, RemoteRunner{Name: "ci.mac-host-1"}, false},
+		{ci, RemoteRunner{Name: "other-host-1"}, false},
+		{ciMac, RemoteRunner{Name: "ci.mac-host-1"}, true},
+		{ciMac, RemoteRunner{Name: "ci.mac-host-1", Labels: []string{"coordinator-ci.mac"}}, true},
+		{ciMac, RemoteRunner{Name: "ci.mac-host-1", Labels: []string{"coordinator-ci"}}, false},
+		{ciMac, RemoteRunner{Name: "ci-host-1"}, false},
+	}
+	for _, c := range cases {
+		if owns := c.ownership.Owns(c.runner); owns != c.owns {
+			t.Errorf("%s owns %s: got %v", c.ownership.ID(), c.runner.Name, owns)
+		}
+	}
+}
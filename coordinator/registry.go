@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ociManifestMediaType    = "application/vnd.oci.image.manifest.v1+json"
+	dockerManifestMediaType = "application/vnd.docker.distribution.manifest.v2+json"
+)
+
+type RegistryConfig struct {
+	// Host is the default registry for references without registry host.
+	Host     string `json:"host,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// PlainHTTP uses HTTP instead of HTTPS, e.g. for local registries.
+	PlainHTTP bool `json:"plainHTTP,omitempty"`
+	// CacheDir holds pulled images; defaults to user cache dir.
+	CacheDir string `json:"cacheDir,omitempty"`
+}
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ociManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType,omitempty"`
+	Layers        []ociDescriptor `json:"layers"`
+}
+
+type ociReference struct {
+	Host       string
+	Repository string
+	// Reference is a tag or digest.
+	Reference string
+}
+
+func (r ociReference) String() string {
+	if strings.HasPrefix(r.Reference, "sha256:") {
+		return fmt.Sprintf("%s/%s@%s", r.Host, r.Repository, r.Reference)
+	}
+	return fmt.Sprintf("%s/%s:%s", r.Host, r.Repository, r.Reference)
+}
+
+// parseOCIReference parses "[host/]repository[:tag|@digest]".
+func parseOCIReference(ref string, defaultHost string) (ociReference, error) {
+	var r ociReference
+
+	name := ref
+	if before, digest, ok := strings.Cut(ref, "@"); ok {
+		name, r.Reference = before, digest
+	} else if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		name, r.Reference = ref[:i], ref[i+1:]
+	} else {
+		r.Reference = "latest"
+	}
+
+	host, repo, ok := strings.Cut(name, "/")
+	if ok && (strings.ContainsAny(host, ".:") || host == "localhost") {
+		r.Host, r.Repository = host, repo
+	} else {
+		r.Host, r.Repository = defaultHost, name
+	}
+
+	if r.Host == "" {
+		return r, fmt.Errorf("invalid reference %q: registry host is required", ref)
+	}
+	if r.Repository == "" || r.Reference == "" {
+		return r, fmt.Errorf("invalid reference %q", ref)
+	}
+	return r, nil
+}
+
+// RegistryClient is a minimal client of OCI distribution API, supporting
+// basic and bearer token authentication.
+type RegistryClient struct {
+	config *RegistryConfig
+	client *http.Client
+
+	lock   *sync.Mutex
+	tokens map[string]string
+}
+
+func NewRegistryClient(config *RegistryConfig) *RegistryClient {
+	return &RegistryClient{
+		config: config,
+		client: &http.Client{},
+		lock:   new(sync.Mutex),
+		tokens: make(map[string]string),
+	}
+}
+
+func (c *RegistryClient) url(ref ociReference, kind string, reference string) string {
+	scheme := "https"
+	if c.config.PlainHTTP {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s/v2/%s/%s/%s", scheme, ref.Host, ref.Repository, kind, reference)
+}
+
+// FetchManifest fetches the image manifest, and returns it with its digest.
+func (c *RegistryClient) FetchManifest(ctx context.Context, ref ociReference) (*ociManifest, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 1*time.Minute)
+	defer cancel()
+
+	resp, err := c.do(ctx, ref, "GET", c.url(ref, "manifests", ref.Reference), func(req *http.Request) {
+		req.Header.Set("Accept", ociManifestMediaType+", "+dockerManifestMediaType)
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("cannot fetch manifest of %s: unexpected status %d", ref, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4*1024*1024))
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(body)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	if strings.HasPrefix(ref.Reference, "sha256:") && ref.Reference != digest {
+		return nil, "", fmt.Errorf("manifest digest mismatch: expected %s, got %s", ref.Reference, digest)
+	}
+
+	var manifest ociManifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return nil, "", fmt.Errorf("malformed manifest: %w", err)
+	}
+	if manifest.SchemaVersion != 2 {
+		return nil, "", fmt.Errorf("unsupported manifest schema version %d", manifest.SchemaVersion)
+	}
+	return &manifest, digest, nil
+}
+
+// FetchBlob downloads the blob to path. Partial download is kept in
+// "<path>.partial" and resumed on next call. Blob is moved to path only if
+// its digest is verified.
+func (c *RegistryClient) FetchBlob(ctx context.Context, ref ociReference, desc ociDescriptor, path string) error {
+	alg, expected, ok := strings.Cut(desc.Digest, ":")
+	if !ok || alg != "sha256" {
+		return fmt.Errorf("unsupported digest: %s", desc.Digest)
+	}
+
+	partialPath := path + ".partial"
+	f, err := os.OpenFile(partialPath, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	if offset < desc.Size {
+		resp, err := c.do(ctx, ref, "GET", c.url(ref, "blobs", desc.Digest), func(req *http.Request) {
+			if offset > 0 {
+				req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+			}
+		})
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		switch resp.StatusCode {
+		case http.StatusPartialContent:
+		case http.StatusOK:
+			// Range not supported; restart download.
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			if err := f.Truncate(0); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("cannot fetch blob %s: unexpected status %d", desc.Digest, resp.StatusCode)
+		}
+
+		if _, err := io.Copy(f, resp.Body); err != nil {
+			return fmt.Errorf("cannot fetch blob %s: %w", desc.Digest, err)
+		}
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if actual := hex.EncodeToString(h.Sum(nil)); actual != expected {
+		// Discard corrupted download to restart on next attempt.
+		os.Remove(partialPath)
+		return fmt.Errorf("blob digest mismatch: expected %s, got sha256:%s", desc.Digest, actual)
+	}
+
+	return os.Rename(partialPath, path)
+}
+
+func (c *RegistryClient) do(
+	ctx context.Context,
+	ref ociReference,
+	method string,
+	url string,
+	prepare func(req *http.Request),
+) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		prepare(req)
+
+		c.lock.Lock()
+		auth := c.tokens[ref.Host+"/"+ref.Repository]
+		c.lock.Unlock()
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusUnauthorized || attempt > 0 {
+			return resp, nil
+		}
+
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		auth, err = c.authenticate(ctx, challenge)
+		if err != nil {
+			return nil, fmt.Errorf("cannot authenticate to %s: %w", ref.Host, err)
+		}
+		c.lock.Lock()
+		c.tokens[ref.Host+"/"+ref.Repository] = auth
+		c.lock.Unlock()
+	}
+}
+
+// authenticate responds to the authentication challenge, and returns the
+// authorization header value.
+func (c *RegistryClient) authenticate(ctx context.Context, challenge string) (string, error) {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if c.config.Username == "" {
+			return "", errors.New("credentials required")
+		}
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.SetBasicAuth(c.config.Username, c.config.Password)
+		return req.Header.Get("Authorization"), nil
+
+	case "bearer":
+		values := parseChallengeParams(params)
+		realm, err := url.Parse(values["realm"])
+		if err != nil || values["realm"] == "" {
+			return "", fmt.Errorf("invalid token realm: %q", values["realm"])
+		}
+		query := realm.Query()
+		if service := values["service"]; service != "" {
+			query.Set("service", service)
+		}
+		if scope := values["scope"]; scope != "" {
+			query.Set("scope", scope)
+		}
+		realm.RawQuery = query.Encode()
+
+		req, err := http.NewRequestWithContext(ctx, "GET", realm.String(), nil)
+		if err != nil {
+			return "", err
+		}
+		if c.config.Username != "" {
+			req.SetBasicAuth(c.config.Username, c.config.Password)
+		}
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("token request failed: unexpected status %d", resp.StatusCode)
+		}
+
+		var token struct {
+			Token       string `json:"token"`
+			AccessToken string `json:"access_token"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+			return "", fmt.Errorf("malformed token response: %w", err)
+		}
+		if token.Token == "" {
+			token.Token = token.AccessToken
+		}
+		return "Bearer " + token.Token, nil
+	}
+	return "", fmt.Errorf("unsupported authentication scheme %q", scheme)
+}
+
+// parseChallengeParams parses `key="value",key2="value2"`.
+func parseChallengeParams(params string) map[string]string {
+	values := make(map[string]string)
+	for params != "" {
+		var key, value string
+		key, params, _ = strings.Cut(params, "=")
+		key = strings.TrimSpace(key)
+		if strings.HasPrefix(params, `"`) {
+			value, params, _ = strings.Cut(params[1:], `"`)
+			params = strings.TrimPrefix(params, ",")
+		} else {
+			value, params, _ = strings.Cut(params, ",")
+		}
+		values[strings.ToLower(key)] = value
+	}
+	return values
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testRegistryToken = "t0ken"
+
+// testRegistry is a stand-in OCI registry serving manifests and blobs of
+// repository "vm", with bearer token authentication.
+type testRegistry struct {
+	server *httptest.Server
+
+	lock      *sync.Mutex
+	manifests map[string][]byte
+	blobs     map[string][]byte
+	requests  []string
+}
+
+func newTestRegistry(t *testing.T) *testRegistry {
+	r := &testRegistry{
+		lock:      new(sync.Mutex),
+		manifests: make(map[string][]byte),
+		blobs:     make(map[string][]byte),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Query().Get("scope") != "repository:vm:pull" {
+			rw.WriteHeader(http.StatusForbidden)
+			return
+		}
+		json.NewEncoder(rw).Encode(map[string]string{"token": testRegistryToken})
+	})
+	mux.HandleFunc("/v2/vm/", r.handle)
+	r.server = httptest.NewServer(mux)
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+func (r *testRegistry) host() string {
+	return strings.TrimPrefix(r.server.URL, "http://")
+}
+
+func (r *testRegistry) handle(rw http.ResponseWriter, req *http.Request) {
+	if req.Header.Get("Authorization") != "Bearer "+testRegistryToken {
+		rw.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test",scope="repository:vm:pull"`, r.server.URL))
+		rw.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.requests = append(r.requests, req.URL.Path)
+
+	kind, reference, _ := strings.Cut(strings.TrimPrefix(req.URL.Path, "/v2/vm/"), "/")
+	var data []byte
+	switch kind {
+	case "manifests":
+		data = r.manifests[reference]
+		rw.Header().Set("Content-Type", ociManifestMediaType)
+	case "blobs":
+		data = r.blobs[reference]
+	}
+	if data == nil {
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	}
+	http.ServeContent(rw, req, "", time.Time{}, bytes.NewReader(data))
+}
+
+func (r *testRegistry) addBlob(data []byte) string {
+	sum := sha256.Sum256(data)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	r.lock.Lock()
+	r.blobs[digest] = data
+	r.lock.Unlock()
+	return digest
+}
+
+// pushImage pushes an image of files, splitting files into chunks of
+// chunkSize, and returns the manifest digest.
+func (r *testRegistry) pushImage(t *testing.T, tag string, files map[string]string, chunkSize int) string {
+	manifest := ociManifest{SchemaVersion: 2, MediaType: ociManifestMediaType}
+	for name, content := range files {
+		for offset := 0; offset < len(content); offset += chunkSize {
+			end := offset + chunkSize
+			if end > len(content) {
+				end = len(content)
+			}
+			chunk := []byte(content[offset:end])
+			manifest.Layers = append(manifest.Layers, ociDescriptor{
+				MediaType: "application/octet-stream",
+				Digest:    r.addBlob(chunk),
+				Size:      int64(len(chunk)),
+				Annotations: map[string]string{
+					ociTitleAnnotation:       name,
+					ociChunkOffsetAnnotation: fmt.Sprint(offset),
+				},
+			})
+		}
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := r.addBlob(data)
+	r.lock.Lock()
+	r.manifests[tag] = data
+	r.manifests[digest] = data
+	r.lock.Unlock()
+	return digest
+}
+
+func (r *testRegistry) takeRequests() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	requests := r.requests
+	r.requests = nil
+	return requests
+}
+
+func TestParseOCIReference(t *testing.T) {
+	cases := []struct {
+		ref      string
+		expected string
+	}{
+		{"vm", "registry.local/vm:latest"},
+		{"vm:14.1", "registry.local/vm:14.1"},
+		{"org/vm:14.1", "registry.local/org/vm:14.1"},
+		{"ghcr.io/org/vm:14.1", "ghcr.io/org/vm:14.1"},
+		{"localhost:5000/vm", "localhost:5000/vm:latest"},
+		{"ghcr.io/org/vm@sha256:abcd", "ghcr.io/org/vm@sha256:abcd"},
+	}
+	for _, c := range cases {
+		ref, err := parseOCIReference(c.ref, "registry.local")
+		if err != nil {
+			t.Errorf("%q: %v", c.ref, err)
+			continue
+		}
+		if ref.String() != c.expected {
+			t.Errorf("%q: got %s, expected %s", c.ref, ref, c.expected)
+		}
+	}
+
+	if _, err := parseOCIReference("vm", ""); err == nil {
+		t.Error("expected error without registry host")
+	}
+}
+
+func TestRegistryFetchBlobResume(t *testing.T) {
+	registry := newTestRegistry(t)
+	client := NewRegistryClient(&RegistryConfig{Host: registry.host(), PlainHTTP: true})
+	ref, _ := parseOCIReference("vm:1", registry.host())
+
+	data := []byte("0123456789")
+	desc := ociDescriptor{Digest: registry.addBlob(data), Size: int64(len(data))}
+	path := filepath.Join(t.TempDir(), "blob")
+	if err := os.WriteFile(path+".partial", data[:4], 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.FetchBlob(context.Background(), ref, desc, path); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(path); !bytes.Equal(content, data) {
+		t.Errorf("unexpected blob: %q", content)
+	}
+
+	// Corrupted partial download is discarded.
+	if err := os.WriteFile(path+".partial", []byte("xxxx"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.FetchBlob(context.Background(), ref, desc, path); err == nil {
+		t.Error("expected digest mismatch")
+	}
+	if _, err := os.Stat(path + ".partial"); !os.IsNotExist(err) {
+		t.Error("corrupted partial download not discarded")
+	}
+}
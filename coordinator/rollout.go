@@ -0,0 +1,397 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultRolloutFraction      = 0.25
+	defaultRolloutMinSamples    = 10
+	defaultRolloutMaxRegression = 0.1
+)
+
+// RolloutConfig rolls out a new image version to a fraction of runner slots
+// using the old version first, before promoting it to all slots.
+type RolloutConfig struct {
+	// Image is the new image, as "<name>:<version>".
+	Image string `json:"image"`
+	// From is the old image used by runner slots.
+	From string `json:"from"`
+	// Fraction is the fraction of slots using new image as canary.
+	Fraction float64 `json:"fraction,omitempty"`
+	// MinSamples is the number of boots of both canary and old image
+	// needed before deciding.
+	MinSamples int `json:"minSamples,omitempty"`
+	// MaxRegression is the maximum drop of boot/job success rate of new
+	// image, compared to the old one.
+	MaxRegression float64 `json:"maxRegression,omitempty"`
+	// Auto promotes or rolls back the new image automatically; otherwise it
+	// stays as canary until decided through admin API.
+	Auto bool `json:"auto,omitempty"`
+}
+
+type RolloutState string
+
+const (
+	RolloutStateCanary     RolloutState = "canary"
+	RolloutStatePromoted   RolloutState = "promoted"
+	RolloutStateRolledBack RolloutState = "rolledBack"
+)
+
+type RolloutStats struct {
+	BootSuccess int `json:"bootSuccess"`
+	BootFailure int `json:"bootFailure"`
+	JobSuccess  int `json:"jobSuccess"`
+	JobFailure  int `json:"jobFailure"`
+}
+
+func (s RolloutStats) Boots() int {
+	return s.BootSuccess + s.BootFailure
+}
+
+func (s RolloutStats) Jobs() int {
+	return s.JobSuccess + s.JobFailure
+}
+
+func successRate(success int, failure int) float64 {
+	if success+failure == 0 {
+		return 1
+	}
+	return float64(success) / float64(success+failure)
+}
+
+type RolloutStatus struct {
+	Image       string       `json:"image"`
+	From        string       `json:"from"`
+	State       RolloutState `json:"state"`
+	Reason      string       `json:"reason,omitempty"`
+	CanarySlots []int        `json:"canarySlots"`
+	Old         RolloutStats `json:"old"`
+	New         RolloutStats `json:"new"`
+}
+
+type rollout struct {
+	config RolloutConfig
+	from   *Image
+	to     *Image
+
+	state       RolloutState
+	reason      string
+	canarySlots map[int]bool
+	old         RolloutStats
+	new         RolloutStats
+}
+
+func (r *rollout) key() string {
+	return r.from.Ref() + "->" + r.to.Ref()
+}
+
+// regression compares success rates of new image against old image, and
+// returns the reason if new image is worse beyond threshold.
+func (r *rollout) regression() string {
+	maxRegression := r.config.MaxRegression
+	if maxRegression <= 0 {
+		maxRegression = defaultRolloutMaxRegression
+	}
+
+	oldBoot := successRate(r.old.BootSuccess, r.old.BootFailure)
+	newBoot := successRate(r.new.BootSuccess, r.new.BootFailure)
+	if newBoot < oldBoot-maxRegression {
+		return fmt.Sprintf("boot success rate %.2f, old image %.2f", newBoot, oldBoot)
+	}
+
+	oldJob := successRate(r.old.JobSuccess, r.old.JobFailure)
+	newJob := successRate(r.new.JobSuccess, r.new.JobFailure)
+	if newJob < oldJob-maxRegression {
+		return fmt.Sprintf("job success rate %.2f, old image %.2f", newJob, oldJob)
+	}
+	return ""
+}
+
+// rolloutSlot is a runner slot using the old image of rollouts.
+type rolloutSlot struct {
+	pool string
+	slot int
+}
+
+type rolloutRecord struct {
+	State  RolloutState `json:"state"`
+	Reason string       `json:"reason,omitempty"`
+}
+
+// Rollouts assigns images to runner slots according to rollouts, and decides
+// on canary images by comparing their lifecycle outcomes to old images.
+// Decisions are persisted in state file, so they survive restarts.
+type Rollouts struct {
+	logger    *zap.SugaredLogger
+	statePath string
+
+	lock     *sync.Mutex
+	rollouts []*rollout
+	slots    map[*Image][]rolloutSlot
+}
+
+func NewRollouts(
+	logger *zap.SugaredLogger,
+	configs []RolloutConfig,
+	catalog *ImageCatalog,
+	statePath string,
+) (*Rollouts, error) {
+	r := &Rollouts{
+		logger:    logger.Named("rollouts"),
+		statePath: statePath,
+		lock:      new(sync.Mutex),
+		slots:     make(map[*Image][]rolloutSlot),
+	}
+
+	records := make(map[string]rolloutRecord)
+	if data, err := os.ReadFile(statePath); err == nil {
+		if err := json.Unmarshal(data, &records); err != nil {
+			return nil, fmt.Errorf("malformed rollout state: %w", err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	for _, config := range configs {
+		from, err := catalog.Resolve(config.From)
+		if err != nil {
+			return nil, fmt.Errorf("rollout %s: %w", config.Image, err)
+		}
+		to, err := catalog.Resolve(config.Image)
+		if err != nil {
+			return nil, fmt.Errorf("rollout %s: %w", config.Image, err)
+		}
+		if from == to {
+			return nil, fmt.Errorf("rollout %s: same image as old one", config.Image)
+		}
+		for _, other := range r.rollouts {
+			if other.from == from {
+				return nil, fmt.Errorf("rollout %s: multiple rollouts from %s", config.Image, from.Ref())
+			}
+		}
+
+		ro := &rollout{config: config, from: from, to: to, state: RolloutStateCanary}
+		if record, ok := records[ro.key()]; ok {
+			ro.state = record.State
+			ro.reason = record.Reason
+		}
+		r.rollouts = append(r.rollouts, ro)
+	}
+	return r, nil
+}
+
+// AddSlot registers the runner slot of the pool using the image, for canary
+// slot assignment. Canary slots are assigned in order of pools, then slots in
+// pool, so the assignment of a pool is unaffected by slots renumbered due to
+// other runner configs.
+func (r *Rollouts) AddSlot(pool string, slot int, image *Image) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.slots[image] = append(r.slots[image], rolloutSlot{pool: pool, slot: slot})
+	for _, ro := range r.rollouts {
+		if ro.from != image {
+			continue
+		}
+
+		fraction := ro.config.Fraction
+		if fraction <= 0 {
+			fraction = defaultRolloutFraction
+		}
+		slots := r.slots[image]
+		sort.Slice(slots, func(i, j int) bool {
+			if slots[i].pool != slots[j].pool {
+				return slots[i].pool < slots[j].pool
+			}
+			return slots[i].slot < slots[j].slot
+		})
+		count := int(math.Ceil(fraction * float64(len(slots))))
+		if count > len(slots) {
+			count = len(slots)
+		}
+
+		ro.canarySlots = make(map[int]bool)
+		for _, s := range slots[:count] {
+			ro.canarySlots[s.slot] = true
+		}
+	}
+}
+
+// ImageFor returns the image to be used by the slot for its next VM.
+func (r *Rollouts) ImageFor(slot int, image *Image) *Image {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, ro := range r.rollouts {
+		if ro.from != image {
+			continue
+		}
+		switch ro.state {
+		case RolloutStatePromoted:
+			return ro.to
+		case RolloutStateCanary:
+			if ro.canarySlots[slot] {
+				return ro.to
+			}
+		}
+	}
+	return image
+}
+
+// RecordBoot records whether an instance using the image became ready.
+func (r *Rollouts) RecordBoot(image *Image, success bool) {
+	r.record(image, func(stats *RolloutStats) {
+		if success {
+			stats.BootSuccess++
+		} else {
+			stats.BootFailure++
+		}
+	})
+}
+
+// RecordJob records whether a job run by an instance using the image
+// succeeded.
+func (r *Rollouts) RecordJob(image *Image, success bool) {
+	r.record(image, func(stats *RolloutStats) {
+		if success {
+			stats.JobSuccess++
+		} else {
+			stats.JobFailure++
+		}
+	})
+}
+
+func (r *Rollouts) record(image *Image, update func(stats *RolloutStats)) {
+	if image == nil {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, ro := range r.rollouts {
+		if ro.state != RolloutStateCanary {
+			continue
+		}
+		switch image {
+		case ro.from:
+			update(&ro.old)
+			r.evaluate(ro)
+		case ro.to:
+			update(&ro.new)
+			r.evaluate(ro)
+		}
+	}
+}
+
+func (r *Rollouts) evaluate(ro *rollout) {
+	minSamples := ro.config.MinSamples
+	if minSamples <= 0 {
+		minSamples = defaultRolloutMinSamples
+	}
+	// Without samples of old image, there is no baseline to compare with.
+	if !ro.config.Auto || ro.new.Boots() < minSamples || ro.old.Boots() < minSamples {
+		return
+	}
+
+	if reason := ro.regression(); reason != "" {
+		r.decide(ro, RolloutStateRolledBack, reason)
+	} else {
+		r.decide(ro, RolloutStatePromoted, "success rate within threshold")
+	}
+}
+
+func (r *Rollouts) Promote(image string) error {
+	return r.manualDecide(image, RolloutStatePromoted)
+}
+
+func (r *Rollouts) Rollback(image string) error {
+	return r.manualDecide(image, RolloutStateRolledBack)
+}
+
+func (r *Rollouts) manualDecide(image string, state RolloutState) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, ro := range r.rollouts {
+		if ro.config.Image == image || ro.to.Ref() == image {
+			r.decide(ro, state, "manual")
+			return nil
+		}
+	}
+	return fmt.Errorf("rollout of %s not found", image)
+}
+
+func (r *Rollouts) decide(ro *rollout, state RolloutState, reason string) {
+	ro.state = state
+	ro.reason = reason
+	r.logger.Infow("rollout decided",
+		"image", ro.to.Ref(),
+		"from", ro.from.Ref(),
+		"state", state,
+		"reason", reason,
+		"old", ro.old,
+		"new", ro.new,
+	)
+
+	if err := r.save(); err != nil {
+		r.logger.Warnw("failed to save rollout state", "error", err)
+	}
+}
+
+func (r *Rollouts) save() error {
+	records := make(map[string]rolloutRecord)
+	for _, ro := range r.rollouts {
+		if ro.state != RolloutStateCanary {
+			records[ro.key()] = rolloutRecord{State: ro.state, Reason: ro.reason}
+		}
+	}
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(r.statePath), 0700); err != nil {
+		return err
+	}
+	tmpPath := r.statePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, r.statePath)
+}
+
+func (r *Rollouts) Status() []RolloutStatus {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	status := make([]RolloutStatus, 0, len(r.rollouts))
+	for _, ro := range r.rollouts {
+		slots := make([]int, 0, len(ro.canarySlots))
+		for slot := range ro.canarySlots {
+			slots = append(slots, slot)
+		}
+		sort.Ints(slots)
+
+		status = append(status, RolloutStatus{
+			Image:       ro.to.Ref(),
+			From:        ro.from.Ref(),
+			State:       ro.state,
+			Reason:      ro.reason,
+			CanarySlots: slots,
+			Old:         ro.old,
+			New:         ro.new,
+		})
+	}
+	return status
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func newTestRollouts(t *testing.T, config RolloutConfig, statePath string) (*Rollouts, *Image, *Image) {
+	t.Helper()
+	catalog, err := NewImageCatalog([]ImageConfig{
+		{Name: "vm", Version: "1", Path: "/images/vm-1"},
+		{Name: "vm", Version: "2", Path: "/images/vm-2"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Image, config.From = "vm:2", "vm:1"
+	rollouts, err := NewRollouts(zap.NewNop().Sugar(), []RolloutConfig{config}, catalog, statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	from, _ := catalog.Resolve("vm:1")
+	to, _ := catalog.Resolve("vm:2")
+	return rollouts, from, to
+}
+
+func TestRolloutCanarySlots(t *testing.T) {
+	type poolSlot struct {
+		pool string
+		slot int
+	}
+	cases := []struct {
+		name     string
+		fraction float64
+		slots    []poolSlot
+		canaries []int
+	}{
+		{"default fraction", 0, []poolSlot{{"a", 0}, {"a", 1}, {"a", 2}, {"a", 3}}, []int{0}},
+		{"rounded up", 0.5, []poolSlot{{"a", 0}, {"a", 1}, {"a", 2}}, []int{0, 1}},
+		{"all", 1, []poolSlot{{"a", 0}, {"a", 1}}, []int{0, 1}},
+		{"ordered by pool", 0.5, []poolSlot{{"b", 0}, {"b", 1}, {"a", 2}, {"a", 3}}, []int{2, 3}},
+		{"ordered by slot", 0.5, []poolSlot{{"a", 3}, {"a", 1}, {"a", 2}, {"a", 0}}, []int{0, 1}},
+	}
+	for _, c := range cases {
+		rollouts, from, to := newTestRollouts(t, RolloutConfig{Fraction: c.fraction}, filepath.Join(t.TempDir(), "rollouts.json"))
+		for _, s := range c.slots {
+			rollouts.AddSlot(s.pool, s.slot, from)
+		}
+
+		if status := rollouts.Status()[0]; !reflect.DeepEqual(status.CanarySlots, c.canaries) {
+			t.Errorf("%s: unexpected canary slots %v", c.name, status.CanarySlots)
+		}
+		for _, s := range c.slots {
+			canary := false
+			for _, slot := range c.canaries {
+				canary = canary || slot == s.slot
+			}
+			if image := rollouts.ImageFor(s.slot, from); (image == to) != canary {
+				t.Errorf("%s: slot %d: unexpected image %s", c.name, s.slot, image.Ref())
+			}
+		}
+	}
+}
+
+func TestRolloutEvaluate(t *testing.T) {
+	type outcomes struct {
+		bootSuccess, bootFailure, jobSuccess, jobFailure int
+	}
+	cases := []struct {
+		name     string
+		auto     bool
+		old, new outcomes
+		state    RolloutState
+	}{
+		{"manual", false, outcomes{10, 0, 10, 0}, outcomes{10, 0, 10, 0}, RolloutStateCanary},
+		{"too few new samples", true, outcomes{10, 0, 10, 0}, outcomes{9, 0, 9, 0}, RolloutStateCanary},
+		{"too few old samples", true, outcomes{0, 0, 0, 0}, outcomes{10, 0, 10, 0}, RolloutStateCanary},
+		{"no regression", true, outcomes{10, 0, 10, 0}, outcomes{10, 0, 10, 0}, RolloutStatePromoted},
+		{"better", true, outcomes{8, 2, 8, 2}, outcomes{10, 0, 10, 0}, RolloutStatePromoted},
+		{"regression within threshold", true, outcomes{10, 0, 10, 0}, outcomes{9, 1, 10, 0}, RolloutStatePromoted},
+		{"boot regression", true, outcomes{10, 0, 10, 0}, outcomes{8, 2, 8, 0}, RolloutStateRolledBack},
+		{"job regression", true, outcomes{10, 0, 10, 0}, outcomes{10, 0, 8, 2}, RolloutStateRolledBack},
+	}
+	for _, c := range cases {
+		rollouts, from, to := newTestRollouts(t, RolloutConfig{Auto: c.auto}, filepath.Join(t.TempDir(), "rollouts.json"))
+		for _, o := range []struct {
+			image    *Image
+			outcomes outcomes
+		}{{to, c.new}, {from, c.old}} {
+			for i := 0; i < o.outcomes.jobSuccess; i++ {
+				rollouts.RecordJob(o.image, true)
+			}
+			for i := 0; i < o.outcomes.jobFailure; i++ {
+				rollouts.RecordJob(o.image, false)
+			}
+			for i := 0; i < o.outcomes.bootFailure; i++ {
+				rollouts.RecordBoot(o.image, false)
+			}
+			for i := 0; i < o.outcomes.bootSuccess; i++ {
+				rollouts.RecordBoot(o.image, true)
+			}
+		}
+
+		if state := rollouts.Status()[0].State; state != c.state {
+			t.Errorf("%s: unexpected state %s", c.name, state)
+		}
+	}
+}
+
+func TestRolloutRestoreState(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "rollouts.json")
+	rollouts, from, to := newTestRollouts(t, RolloutConfig{}, statePath)
+	rollouts.AddSlot("a", 0, from)
+	rollouts.AddSlot("a", 1, from)
+	if err := rollouts.Promote("vm:2"); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, from, to := newTestRollouts(t, RolloutConfig{}, statePath)
+	restored.AddSlot("a", 0, from)
+	restored.AddSlot("a", 1, from)
+	if status := restored.Status()[0]; status.State != RolloutStatePromoted || status.Reason != "manual" {
+		t.Errorf("unexpected restored status: %+v", status)
+	}
+	for _, slot := range []int{0, 1} {
+		if image := restored.ImageFor(slot, from); image != to {
+			t.Errorf("slot %d: unexpected image %s", slot, image.Ref())
+		}
+	}
+
+	if err := restored.Rollback("vm:2"); err != nil {
+		t.Fatal(err)
+	}
+	restored, from, _ = newTestRollouts(t, RolloutConfig{}, statePath)
+	if image := restored.ImageFor(0, from); image != from {
+		t.Errorf("unexpected image after rollback: %s", image.Ref())
+	}
+	if err := restored.Promote("vm:3"); err == nil {
+		t.Error("expected error deciding unknown rollout")
+	}
+}
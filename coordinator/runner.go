@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
@@ -13,45 +14,154 @@ import (
 type Runner struct {
 	id        int
 	logger    *zap.SugaredLogger
-	vmctlPath string
+	backend   Backend
 	config    *RunnerConfig
+	image     *Image
+	rollouts  *Rollouts
+	resources Resources
+	scheduler *Scheduler
 	server    *Server
 	monitor   *Monitor
+	disk      *DiskManager
+	cluster   *ClusterAgent
+	capacity  *Capacity
+
+	rawConfig     RunnerConfig
+	imageConfigs  map[*Image]*RunnerConfig
+	nextVM        int
+	bundleSizesMB map[string]uint64
 }
 
-func NewRunner(id int, logger *zap.SugaredLogger, config *Config, runnerConfig RunnerConfig, server *Server, monitor *Monitor) *Runner {
-	return &Runner{
-		id:        id,
-		logger:    logger.Named(fmt.Sprintf("runner-%d", id)),
-		vmctlPath: config.VMCtlPath,
-		config:    &runnerConfig,
-		server:    server,
-		monitor:   monitor,
+// cloneRetryDelay is the delay before retrying a failed clone.
+const cloneRetryDelay time.Duration = 30 * time.Second
+
+func NewRunner(
+	id int,
+	logger *zap.SugaredLogger,
+	backend Backend,
+	runnerConfig RunnerConfig,
+	image *Image,
+	rollouts *Rollouts,
+	resources Resources,
+	scheduler *Scheduler,
+	server *Server,
+	monitor *Monitor,
+	disk *DiskManager,
+	cluster *ClusterAgent,
+	capacity *Capacity,
+) *Runner {
+	r := &Runner{
+		id:            id,
+		logger:        logger.Named(fmt.Sprintf("runner-%d", id)),
+		backend:       backend,
+		image:         image,
+		rollouts:      rollouts,
+		resources:     resources,
+		scheduler:     scheduler,
+		server:        server,
+		monitor:       monitor,
+		disk:          disk,
+		cluster:       cluster,
+		capacity:      capacity,
+		rawConfig:     runnerConfig,
+		imageConfigs:  make(map[*Image]*RunnerConfig),
+		bundleSizesMB: make(map[string]uint64),
 	}
+	r.config = r.configFor(image)
+	return r
+}
+
+// configFor returns the runner config using the image.
+func (r *Runner) configFor(image *Image) *RunnerConfig {
+	if image == nil {
+		return &r.rawConfig
+	}
+	if config, ok := r.imageConfigs[image]; ok {
+		return config
+	}
+
+	config := r.rawConfig
+	ApplyImage(&config, image)
+	r.imageConfigs[image] = &config
+	return &config
 }
 
-func (r *Runner) Run(ctx context.Context, g *errgroup.Group, serverPort int) {
+func (r *Runner) Run(ctx context.Context, g *errgroup.Group) {
 	g.Go(func() error {
-		return r.run(ctx, serverPort)
+		return r.run(ctx)
 	})
 }
 
-func (r *Runner) run(ctx context.Context, serverPort int) error {
-	workDir, err := os.MkdirTemp("", fmt.Sprintf("runner-%d-*", r.id))
+func (r *Runner) run(ctx context.Context) error {
+	workDir, err := r.disk.CreateWorkDir(r.id, r.config.Backend)
 	if err != nil {
 		return fmt.Errorf("failed to create working directory: %w", err)
 	}
 	r.logger.Infow("created working directory", "dir", workDir)
 
+	var prefetched *vmClone
 	defer func() {
+		if prefetched != nil {
+			<-prefetched.done
+			r.deleteVM(prefetched.vm)
+		}
 		r.logger.Infow("deleting working directory", "dir", workDir)
-		os.RemoveAll(workDir)
+		r.disk.RemoveWorkDir(workDir)
 	}()
 
-	bundlePath := filepath.Join(workDir, "vm.bundle")
-
 	for ctx.Err() == nil {
-		err = r.runVM(ctx, bundlePath, serverPort)
+		if !r.capacity.IsActive(r.id) {
+			if prefetched != nil {
+				<-prefetched.done
+				r.deleteVM(prefetched.vm)
+				prefetched = nil
+			}
+			r.logger.Info("slot is inactive by capacity schedule, waiting")
+			if err := r.capacity.WaitActive(ctx, r.id); err != nil {
+				break
+			}
+			r.logger.Info("slot is active, resuming")
+		}
+
+		clone := prefetched
+		prefetched = nil
+		if clone != nil && clone.vm.Image != r.nextImage() {
+			// Rollout decided after prefetched VM is cloned.
+			<-clone.done
+			r.logger.Infow("image changed, discarding prefetched VM", "name", clone.vm.Name)
+			r.deleteVM(clone.vm)
+			clone = nil
+		}
+		if clone == nil {
+			if err := r.disk.WaitFree(ctx, r.id); err != nil {
+				break
+			}
+			clone = r.cloneVM(ctx, workDir)
+		}
+
+		select {
+		case <-clone.done:
+		case <-ctx.Done():
+			<-clone.done
+		}
+		if clone.err != nil {
+			r.deleteVM(clone.vm)
+			if ctx.Err() != nil {
+				break
+			}
+			r.logger.Errorw("failed to clone VM, retrying", "error", clone.err, "delay", cloneRetryDelay)
+			select {
+			case <-ctx.Done():
+			case <-time.After(cloneRetryDelay):
+			}
+			continue
+		}
+
+		if r.config.Prefetch && r.capacity.IsActive(r.id) && r.canPrefetch() {
+			prefetched = r.cloneVM(ctx, workDir)
+		}
+
+		err = r.runVM(ctx, clone.vm)
 		if err != nil {
 			return fmt.Errorf("failed to run VM: %w", err)
 		}
@@ -61,16 +171,123 @@ func (r *Runner) run(ctx context.Context, serverPort int) error {
 	return nil
 }
 
-func (r *Runner) runVM(ctx context.Context, bundlePath string, serverPort int) error {
-	instance := NewRunnerInstance(r.logger, r.vmctlPath, bundlePath, r.config, r.monitor, serverPort)
+type vmClone struct {
+	vm   *VM
+	done chan struct{}
+	err  error
+}
+
+// cloneVM clones a fresh VM in background.
+func (r *Runner) cloneVM(ctx context.Context, workDir string) *vmClone {
+	r.nextVM++
+	// Work dir name is unique among coordinator processes sharing work
+	// root, so are VM names.
+	name := fmt.Sprintf("%s-%d", filepath.Base(workDir), r.nextVM)
+	image := r.nextImage()
+	clone := &vmClone{
+		vm: &VM{
+			Name:           name,
+			WorkDir:        filepath.Join(workDir, name),
+			Config:         r.configFor(image),
+			Image:          image,
+			ServerHostName: r.server.HostName(),
+		},
+		done: make(chan struct{}),
+	}
+
+	go func() {
+		defer close(clone.done)
+		if image != nil {
+			r.logger.Infow("cloning VM", "name", name, "image", image.Ref())
+			if err := image.Prepare(ctx); err != nil {
+				clone.err = err
+				return
+			}
+		} else {
+			r.logger.Infow("cloning VM", "name", name)
+		}
+		if err := os.MkdirAll(clone.vm.WorkDir, 0700); err != nil {
+			clone.err = err
+			return
+		}
+		if image != nil {
+			clone.err = image.Use(func() error {
+				return r.backend.Clone(ctx, clone.vm)
+			})
+		} else {
+			clone.err = r.backend.Clone(ctx, clone.vm)
+		}
+	}()
+
+	return clone
+}
+
+func (r *Runner) deleteVM(vm *VM) {
+	r.logger.Infow("deleting VM", "name", vm.Name)
+	if err := r.backend.Delete(context.Background(), vm); err != nil {
+		r.logger.Warnw("failed to delete VM", "name", vm.Name, "error", err)
+	}
+	os.RemoveAll(vm.WorkDir)
+}
+
+// nextImage returns the image of next VM, as assigned by rollouts.
+func (r *Runner) nextImage() *Image {
+	if r.image == nil {
+		return nil
+	}
+	return r.rollouts.ImageFor(r.id, r.image)
+}
+
+// canPrefetch checks whether there is enough disk space for another clone
+// of base VM bundle of next VM.
+func (r *Runner) canPrefetch() bool {
+	bundlePath := r.configFor(r.nextImage()).BaseVMBundlePath
+	size, ok := r.bundleSizesMB[bundlePath]
+	if !ok && bundlePath != "" {
+		var err error
+		size, err = dirSizeMB(bundlePath)
+		if err != nil {
+			r.logger.Warnw("cannot determine base VM bundle size", "error", err)
+			return false
+		}
+		r.bundleSizesMB[bundlePath] = size
+	}
+
+	// Cloned disk image may diverge from base image entirely.
+	if err := r.disk.CheckFree(size); err != nil {
+		r.logger.Warnw("skipping prefetch", "error", err)
+		return false
+	}
+	return true
+}
+
+func (r *Runner) runVM(ctx context.Context, vm *VM) error {
+	defer r.deleteVM(vm)
+
+	if err := r.scheduler.Acquire(ctx, r.id, r.resources); err != nil {
+		// Context is done.
+		return nil
+	}
+	defer r.scheduler.Release(r.resources)
+
+	if r.cluster != nil {
+		lease, err := r.cluster.Acquire(ctx, r.id, r.resources)
+		if err != nil {
+			// Context is done.
+			return nil
+		}
+		defer r.cluster.Release(lease)
+	}
+
+	instance := NewRunnerInstance(r.logger, r.id, r.backend, vm, r.monitor, r.server)
 
 	err := instance.Init(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to init VM: %w", err)
 	}
 
-	r.server.Instances.Store(instance.Token, instance)
-	defer r.server.Instances.Delete(instance.Token)
+	r.server.Instances.Store(instance.ID(), instance)
+	defer r.server.Instances.Delete(instance.ID())
 
 	return instance.Run(ctx)
 }
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/go-github/v45/github"
+	"github.com/oursky/github-ci-support/githublib"
+	"go.uber.org/zap"
+)
+
+const defaultRunnerGroup = "Default"
+
+// RunnerGroupConfig configures an organization runner group used by runners.
+type RunnerGroupConfig struct {
+	Name string `json:"name"`
+	// Visibility is "all", "selected" or "private"; not checked if empty.
+	Visibility string `json:"visibility,omitempty"`
+	// Repositories are names of organization repositories that must be able
+	// to use the group, with "selected" visibility.
+	Repositories []string `json:"repositories,omitempty"`
+	// Provision creates the group if missing, updates its visibility, and
+	// grants access to repositories; otherwise mismatches are errors.
+	Provision bool `json:"provision,omitempty"`
+}
+
+// SetupRunnerGroups validates, or provisions if configured, the runner
+// groups configured or used by runners.
+func SetupRunnerGroups(
+	ctx context.Context,
+	logger *zap.SugaredLogger,
+	service *GitHubRunnerService,
+	configs []RunnerGroupConfig,
+	runners []RunnerConfig,
+) error {
+	logger = logger.Named("runner-groups")
+
+	groupConfigs := make(map[string]RunnerGroupConfig)
+	for _, config := range configs {
+		if config.Name == "" {
+			return errors.New("runner group name is required")
+		}
+		switch config.Visibility {
+		case "", githublib.RunnerGroupVisibilityAll, githublib.RunnerGroupVisibilityPrivate:
+			if len(config.Repositories) > 0 {
+				return fmt.Errorf("runner group %q: repositories require selected visibility", config.Name)
+			}
+		case githublib.RunnerGroupVisibilitySelected:
+		default:
+			return fmt.Errorf("runner group %q: invalid visibility %q", config.Name, config.Visibility)
+		}
+		groupConfigs[config.Name] = config
+	}
+	for _, runner := range runners {
+		if runner.RunnerGroup == "" || runner.RunnerGroup == defaultRunnerGroup {
+			continue
+		}
+		if _, ok := groupConfigs[runner.RunnerGroup]; !ok {
+			groupConfigs[runner.RunnerGroup] = RunnerGroupConfig{Name: runner.RunnerGroup}
+		}
+	}
+	if len(groupConfigs) == 0 {
+		return nil
+	}
+
+	target, ok := service.target.(githublib.RunnerGroupTarget)
+	if !ok {
+		return fmt.Errorf("runner groups require organization target: %s", service.URL())
+	}
+
+	groups, err := target.ListRunnerGroups(ctx, service.client)
+	if err != nil {
+		return fmt.Errorf("cannot list runner groups: %w", err)
+	}
+	groupsByName := make(map[string]*github.RunnerGroup)
+	for _, group := range groups {
+		groupsByName[group.GetName()] = group
+	}
+
+	names := make([]string, 0, len(groupConfigs))
+	for name := range groupConfigs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		config := groupConfigs[name]
+		s := &runnerGroupSetup{logger: logger, target: target, client: service.client, config: config}
+		if err := s.setup(ctx, groupsByName[name]); err != nil {
+			return fmt.Errorf("runner group %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+type runnerGroupSetup struct {
+	logger *zap.SugaredLogger
+	target githublib.RunnerGroupTarget
+	client *github.Client
+	config RunnerGroupConfig
+}
+
+func (s *runnerGroupSetup) setup(ctx context.Context, group *github.RunnerGroup) error {
+	repoIDs, err := s.repositoryIDs(ctx)
+	if err != nil {
+		return err
+	}
+
+	if group == nil {
+		if !s.config.Provision {
+			return errors.New("group does not exist")
+		}
+		visibility := s.config.Visibility
+		if visibility == "" {
+			visibility = githublib.RunnerGroupVisibilityAll
+		}
+		group, err = s.target.CreateRunnerGroup(ctx, s.client, s.config.Name, visibility, repoIDs)
+		if err != nil {
+			return fmt.Errorf("cannot create group: %w", err)
+		}
+		s.logger.Infow("created runner group",
+			"name", s.config.Name,
+			"id", group.GetID(),
+			"visibility", visibility,
+			"repositories", s.config.Repositories,
+		)
+		return nil
+	}
+
+	if s.config.Visibility != "" && group.GetVisibility() != s.config.Visibility {
+		if !s.config.Provision {
+			return fmt.Errorf("visibility is %q, expected %q", group.GetVisibility(), s.config.Visibility)
+		}
+		if err := s.target.SetRunnerGroupVisibility(ctx, s.client, group.GetID(), s.config.Visibility); err != nil {
+			return fmt.Errorf("cannot set visibility: %w", err)
+		}
+		s.logger.Infow("updated runner group visibility", "name", s.config.Name, "visibility", s.config.Visibility)
+	}
+
+	if len(repoIDs) > 0 {
+		repos, err := s.target.ListRunnerGroupRepositories(ctx, s.client, group.GetID())
+		if err != nil {
+			return fmt.Errorf("cannot list repository access: %w", err)
+		}
+		allowed := make(map[int64]bool)
+		for _, repo := range repos {
+			allowed[repo.GetID()] = true
+		}
+
+		var missing []string
+		for i, id := range repoIDs {
+			if !allowed[id] {
+				missing = append(missing, s.config.Repositories[i])
+			}
+		}
+		if len(missing) > 0 {
+			if !s.config.Provision {
+				return fmt.Errorf("repositories not allowed to use group: %s", strings.Join(missing, ", "))
+			}
+			for _, repo := range repos {
+				if !containsID(repoIDs, repo.GetID()) {
+					repoIDs = append(repoIDs, repo.GetID())
+				}
+			}
+			if err := s.target.SetRunnerGroupRepositories(ctx, s.client, group.GetID(), repoIDs); err != nil {
+				return fmt.Errorf("cannot set repository access: %w", err)
+			}
+			s.logger.Infow("granted repository access to runner group", "name", s.config.Name, "repositories", missing)
+		}
+	}
+
+	s.logger.Infow("runner group is ready", "name", s.config.Name, "id", group.GetID())
+	return nil
+}
+
+func (s *runnerGroupSetup) repositoryIDs(ctx context.Context) ([]int64, error) {
+	var ids []int64
+	for _, name := range s.config.Repositories {
+		id, err := s.target.GetRepositoryID(ctx, s.client, name)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get repository %q: %w", name, err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func containsID(ids []int64, id int64) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
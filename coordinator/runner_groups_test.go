@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v45/github"
+	"github.com/oursky/github-ci-support/githublib"
+	"go.uber.org/zap"
+)
+
+// fakeGroupTarget records changes to runner groups; repositories "repo-<n>"
+// have ID n.
+type fakeGroupTarget struct {
+	githublib.RunnerTarget
+	// repos are IDs of repositories allowed to use the group.
+	repos []int64
+	calls []string
+}
+
+func (t *fakeGroupTarget) ListRunnerGroups(ctx context.Context, client *github.Client) ([]*github.RunnerGroup, error) {
+	return nil, nil
+}
+
+func (t *fakeGroupTarget) CreateRunnerGroup(ctx context.Context, client *github.Client, name string, visibility string, repoIDs []int64) (*github.RunnerGroup, error) {
+	t.calls = append(t.calls, fmt.Sprintf("create %s %s %v", name, visibility, repoIDs))
+	return &github.RunnerGroup{ID: github.Int64(1), Name: github.String(name)}, nil
+}
+
+func (t *fakeGroupTarget) SetRunnerGroupVisibility(ctx context.Context, client *github.Client, groupID int64, visibility string) error {
+	t.calls = append(t.calls, fmt.Sprintf("visibility %s", visibility))
+	return nil
+}
+
+func (t *fakeGroupTarget) ListRunnerGroupRepositories(ctx context.Context, client *github.Client, groupID int64) ([]*github.Repository, error) {
+	var repos []*github.Repository
+	for _, id := range t.repos {
+		repos = append(repos, &github.Repository{ID: github.Int64(id)})
+	}
+	return repos, nil
+}
+
+func (t *fakeGroupTarget) SetRunnerGroupRepositories(ctx context.Context, client *github.Client, groupID int64, repoIDs []int64) error {
+	t.calls = append(t.calls, fmt.Sprintf("repositories %v", repoIDs))
+	return nil
+}
+
+func (t *fakeGroupTarget) GetRepositoryID(ctx context.Context, client *github.Client, name string) (int64, error) {
+	var id int64
+	if _, err := fmt.Sscanf(name, "repo-%d", &id); err != nil {
+		return 0, fmt.Errorf("repository not found: %s", name)
+	}
+	return id, nil
+}
+
+func TestRunnerGroupSetup(t *testing.T) {
+	selected := &github.RunnerGroup{ID: github.Int64(1), Name: github.String("ci"), Visibility: github.String("selected")}
+	cases := []struct {
+		name   string
+		config RunnerGroupConfig
+		group  *github.RunnerGroup
+		repos  []int64
+		err    string
+		calls  string
+	}{
+		{
+			name:   "missing group",
+			config: RunnerGroupConfig{Name: "ci"},
+			err:    "group does not exist",
+		},
+		{
+			name:   "create missing group",
+			config: RunnerGroupConfig{Name: "ci", Provision: true},
+			calls:  "create ci all []",
+		},
+		{
+			name:   "create missing group with repositories",
+			config: RunnerGroupConfig{Name: "ci", Visibility: "selected", Repositories: []string{"repo-2", "repo-3"}, Provision: true},
+			calls:  "create ci selected [2 3]",
+		},
+		{
+			name:   "missing repository",
+			config: RunnerGroupConfig{Name: "ci", Visibility: "selected", Repositories: []string{"other"}, Provision: true},
+			err:    `cannot get repository "other"`,
+		},
+		{
+			name:   "matching group",
+			config: RunnerGroupConfig{Name: "ci", Visibility: "selected", Repositories: []string{"repo-2"}},
+			group:  selected,
+			repos:  []int64{1, 2},
+		},
+		{
+			name:   "visibility not checked",
+			config: RunnerGroupConfig{Name: "ci"},
+			group:  selected,
+		},
+		{
+			name:   "visibility mismatch",
+			config: RunnerGroupConfig{Name: "ci", Visibility: "all"},
+			group:  selected,
+			err:    `visibility is "selected", expected "all"`,
+		},
+		{
+			name:   "update visibility",
+			config: RunnerGroupConfig{Name: "ci", Visibility: "all", Provision: true},
+			group:  selected,
+			calls:  "visibility all",
+		},
+		{
+			name:   "missing repository access",
+			config: RunnerGroupConfig{Name: "ci", Visibility: "selected", Repositories: []string{"repo-2", "repo-3", "repo-4"}},
+			group:  selected,
+			repos:  []int64{1, 3},
+			err:    "repositories not allowed to use group: repo-2, repo-4",
+		},
+		{
+			name:   "grant repository access",
+			config: RunnerGroupConfig{Name: "ci", Visibility: "selected", Repositories: []string{"repo-2", "repo-3"}, Provision: true},
+			group:  selected,
+			repos:  []int64{1, 3},
+			calls:  "repositories [2 3 1]",
+		},
+	}
+	for _, c := range cases {
+		target := &fakeGroupTarget{repos: c.repos}
+		s := &runnerGroupSetup{logger: zap.NewNop().Sugar(), target: target, config: c.config}
+		err := s.setup(context.Background(), c.group)
+		if c.err == "" && err != nil {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+		} else if c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("%s: expected error %q, got %v", c.name, c.err, err)
+		}
+		if calls := strings.Join(target.calls, "; "); calls != c.calls {
+			t.Errorf("%s: unexpected calls: %q", c.name, calls)
+		}
+	}
+}
@@ -3,8 +3,7 @@ package main
 import (
 	"bufio"
 	"context"
-	"crypto/rand"
-	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -13,24 +12,27 @@ import (
 	"sync"
 	"sync/atomic"
 	"syscall"
+	"time"
 
 	"go.uber.org/zap"
 )
 
 type RunnerInstance struct {
-	logger     *zap.SugaredLogger
-	vmctlPath  string
-	bundlePath string
-	Config     *RunnerConfig
-	monitor    *Monitor
-	serverPort int
-	serverURL  string
-
-	id         uint32
-	Token      string
-	runnerID   int64
-	runnerName string
-	hostName   string
+	logger  *zap.SugaredLogger
+	backend Backend
+	vm      *VM
+	Config  *RunnerConfig
+	monitor *Monitor
+	server  *Server
+
+	id             uint32
+	slot           int
+	Token          string
+	tokenExpiresAt time.Time
+	runnerID       int64
+	runnerName     string
+	hostName       string
+	xcodeVersions  []string
 
 	termLock  *sync.Mutex
 	term      int
@@ -41,51 +43,45 @@ type RunnerInstance struct {
 
 var nextID uint32 = 0
 
-func NewRunnerInstance(logger *zap.SugaredLogger, vmctlPath, bundlePath string, config *RunnerConfig, monitor *Monitor, serverPort int) *RunnerInstance {
+func NewRunnerInstance(logger *zap.SugaredLogger, slot int, backend Backend, vm *VM, monitor *Monitor, server *Server) *RunnerInstance {
 	id := atomic.AddUint32(&nextID, 1)
+	logger = logger.Named(fmt.Sprintf("vm-%d", id))
+	if vm.Image != nil {
+		logger = logger.With("image", vm.Image.Ref())
+	}
+
 	return &RunnerInstance{
-		id:         id,
-		logger:     logger.Named(fmt.Sprintf("vm-%d", id)),
-		vmctlPath:  vmctlPath,
-		bundlePath: bundlePath,
-		Config:     config,
-		monitor:    monitor,
-		serverPort: serverPort,
-		serverURL:  "",
-		termLock:   new(sync.Mutex),
-		term:       0,
-		terminate:  make(chan struct{}),
-		kill:       make(chan struct{}),
-		messages:   make(chan any),
+		id:        id,
+		slot:      slot,
+		logger:    logger,
+		backend:   backend,
+		vm:        vm,
+		Config:    vm.Config,
+		monitor:   monitor,
+		server:    server,
+		termLock:  new(sync.Mutex),
+		term:      0,
+		terminate: make(chan struct{}),
+		kill:      make(chan struct{}),
+		messages:  make(chan any),
 	}
 }
 
-func (r *RunnerInstance) vmctl(ctx context.Context, args ...string) *exec.Cmd {
-	return exec.CommandContext(ctx, r.vmctlPath, args...)
+func (r *RunnerInstance) ID() uint32 {
+	return r.id
 }
 
 func (r *RunnerInstance) Init(ctx context.Context) error {
-	cmd := r.vmctl(ctx, "clone", r.Config.BaseVMBundlePath, r.bundlePath)
-	r.logger.Debugw("cloning vm", "cmd", cmd.String())
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to clone VM: %w", err)
-	}
+	r.logger.Infow("using vm", "name", r.vm.Name)
 
-	var buf [12]byte
-	if _, err := rand.Read(buf[:]); err != nil {
-		return fmt.Errorf("failed to generate token: %w", err)
-	}
-	r.Token = fmt.Sprintf("%s-%d", base64.RawURLEncoding.EncodeToString(buf[:]), r.id)
-	r.logger.Infow("generated token", "mac", r.Token)
+	r.Token, r.tokenExpiresAt = r.server.IssueToken(r.id)
+	r.logger.Infow("issued token", "expiresAt", r.tokenExpiresAt)
 
-	hostName, err := os.Hostname()
+	bootstrapMsg, err := r.bootstrapMessage()
 	if err != nil {
-		return fmt.Errorf("cannot get hostname: %w", err)
+		return fmt.Errorf("cannot encode bootstrap message: %w", err)
 	}
-	if !strings.HasSuffix(hostName, ".local") {
-		hostName = hostName + ".local"
-	}
-	r.serverURL = fmt.Sprintf("http://%s:%d", hostName, r.serverPort)
+	r.vm.Bootstrap = bootstrapMsg
 
 	return nil
 }
@@ -115,7 +111,10 @@ func (r *RunnerInstance) NeedTerminate() <-chan struct{} {
 }
 
 func (r *RunnerInstance) start(ctx context.Context) (*exec.Cmd, io.WriteCloser, io.ReadCloser, error) {
-	cmd := r.vmctl(ctx, "start", "--config", r.Config.VMConfigPath, "--bundle", r.bundlePath)
+	cmd, err := r.backend.Command(ctx, r.vm)
+	if err != nil {
+		return nil, nil, nil, err
+	}
 	cmd.SysProcAttr = &syscall.SysProcAttr{
 		Setpgid: true,
 	}
@@ -166,8 +165,24 @@ func (r *RunnerInstance) Run(ctx context.Context) error {
 		}
 	}()
 
-	bootstrapMsg := fmt.Sprintf("%s %s\n", r.serverURL, r.Token)
-	in.Write([]byte(bootstrapMsg))
+	if backend, ok := r.backend.(ConsoleBackend); ok {
+		consoleDone := make(chan struct{})
+		defer func() { <-consoleDone }()
+		followCtx, stopFollow := context.WithCancel(context.Background())
+		defer stopFollow()
+		go func() {
+			defer close(consoleDone)
+			log := r.logger.Named("console")
+			err := followFile(followCtx, backend.ConsolePath(r.vm), func(line string) {
+				log.Infof(line)
+			})
+			if err != nil {
+				log.Errorw("cannot read VM console", "error", err)
+			}
+		}()
+	}
+
+	in.Write([]byte(r.vm.Bootstrap))
 
 	completed := make(chan error, 1)
 	go func() {
@@ -181,7 +196,7 @@ func (r *RunnerInstance) Run(ctx context.Context) error {
 			r.handleMessage(msg)
 
 		case err = <-completed:
-			return err
+			return r.exited(err)
 
 		case <-ctx.Done():
 			terminate = true
@@ -194,28 +209,89 @@ func (r *RunnerInstance) Run(ctx context.Context) error {
 	r.logger.Infow("terminating VM gracefully")
 	select {
 	case err = <-completed:
-		return err
+		return r.exited(err)
 	case <-r.kill:
 		r.logger.Infow("killing VM")
 		return cmd.Process.Kill()
 	}
 }
 
+// exited handles exit of VM command. Non-zero exit status of backend
+// command is an exit of the instance, e.g. guest agent failed; only failure
+// of waiting for the command is an error.
+func (r *RunnerInstance) exited(err error) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		r.logger.Warnw("VM exited with error", "exitCode", exitErr.ExitCode())
+		return nil
+	}
+	return err
+}
+
 func (r *RunnerInstance) handleMessage(msg any) {
 	switch msg := msg.(type) {
 	case RunnerMsgRegister:
 		r.runnerName = msg.Name
 		r.hostName = msg.HostName
+		r.logger.Infow("guest registered",
+			"protocolVersion", msg.ProtocolVersion,
+			"capabilities", msg.Capabilities,
+		)
 
 	case RunnerMsgUpdate:
 		if msg.RunnerID != nil {
 			r.runnerID = *msg.RunnerID
 		}
+		if msg.XcodeVersions != nil {
+			r.xcodeVersions = msg.XcodeVersions
+		}
 	}
 
 	r.monitor.Post(MonitorMsgUpdate{
-		InstanceID: r.id,
-		RunnerName: r.runnerName,
-		RunnerID:   r.runnerID,
+		InstanceID:    r.id,
+		RunnerName:    r.runnerName,
+		RunnerID:      r.runnerID,
+		XcodeVersions: r.xcodeVersions,
 	})
 }
+
+// consolePollInterval is the interval of polling console file for output.
+const consolePollInterval = 500 * time.Millisecond
+
+// followFile calls fn with lines appended to the file, until context is
+// done and remaining lines are read.
+func followFile(ctx context.Context, path string, fn func(line string)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	var partial string
+	done := false
+	for {
+		line, err := reader.ReadString('\n')
+		partial += line
+		if err == nil {
+			fn(strings.TrimRight(partial, "\r\n"))
+			partial = ""
+			continue
+		} else if err != io.EOF {
+			return err
+		}
+
+		if done {
+			if partial != "" {
+				fn(partial)
+			}
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			// Read output appended before context is done.
+			done = true
+		case <-time.After(consolePollInterval):
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+type commandBackend struct {
+	args []string
+}
+
+func (b *commandBackend) Clone(ctx context.Context, vm *VM) error {
+	return nil
+}
+
+func (b *commandBackend) Command(ctx context.Context, vm *VM) (*exec.Cmd, error) {
+	return exec.CommandContext(ctx, b.args[0], b.args[1:]...), nil
+}
+
+func (b *commandBackend) Delete(ctx context.Context, vm *VM) error {
+	return nil
+}
+
+func TestRunnerInstanceExit(t *testing.T) {
+	cases := []struct {
+		name  string
+		args  []string
+		fatal bool
+	}{
+		{"success", []string{"sh", "-c", "exit 0"}, false},
+		{"failure", []string{"sh", "-c", "exit 1"}, false},
+		{"not launched", []string{"/nonexistent/backend"}, true},
+	}
+	for _, c := range cases {
+		monitor := &Monitor{messages: make(chan any, 10)}
+		backend := &commandBackend{args: c.args}
+		instance := NewRunnerInstance(zap.NewNop().Sugar(), 0, backend, &VM{Name: "vm", Config: &RunnerConfig{}}, monitor, nil)
+
+		err := instance.Run(context.Background())
+		if (err != nil) != c.fatal {
+			t.Errorf("%s: unexpected result %v", c.name, err)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+const labelRetryDelay time.Duration = 30 * time.Second
+
+var regexLabelInvalidChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// RuntimeLabelsConfig enables labels computed at runtime, which are updated
+// on live runners without re-registration.
+type RuntimeLabelsConfig struct {
+	// Image adds "image-<name>-<version>" of the base image.
+	Image bool `json:"image,omitempty"`
+	// Host adds "host-<host>" of the coordinator host.
+	Host bool `json:"host,omitempty"`
+	// Xcode adds "xcode-<version>" for each Xcode version reported by guest.
+	Xcode bool `json:"xcode,omitempty"`
+	// Health adds "health-ok", or "health-low-disk" if host is low on disk
+	// space.
+	Health bool `json:"health,omitempty"`
+}
+
+func (c RuntimeLabelsConfig) Enabled() bool {
+	return c.Image || c.Host || c.Xcode || c.Health
+}
+
+type labelState struct {
+	runnerName string
+	labels     []string
+	isApplied  bool
+	retryAt    time.Time
+}
+
+// RunnerLabeler applies labels of live runners in background.
+type RunnerLabeler struct {
+	logger    *zap.SugaredLogger
+	service   RunnerService
+	ownership *Ownership
+	disk      *DiskManager
+	host      string
+	notify    chan struct{}
+
+	lock    *sync.Mutex
+	runners map[int64]*labelState
+}
+
+func NewRunnerLabeler(logger *zap.SugaredLogger, service RunnerService, ownership *Ownership, disk *DiskManager, host string) *RunnerLabeler {
+	return &RunnerLabeler{
+		logger:    logger.Named("labeler"),
+		service:   service,
+		ownership: ownership,
+		disk:      disk,
+		host:      host,
+		notify:    make(chan struct{}, 1),
+		lock:      new(sync.Mutex),
+		runners:   make(map[int64]*labelState),
+	}
+}
+
+// Labels computes all custom labels of a runner: configured labels, followed
+// by enabled runtime labels.
+func (l *RunnerLabeler) Labels(config *RunnerConfig, image *Image, xcodeVersions []string) []string {
+	labels := append([]string(nil), l.ownership.RunnerLabels(config.Labels)...)
+
+	runtime := config.RuntimeLabels
+	if runtime.Image && image != nil {
+		labels = append(labels, labelValue("image", image.Config.Name+"-"+image.Config.Version))
+	}
+	if runtime.Host {
+		labels = append(labels, labelValue("host", l.host))
+	}
+	if runtime.Xcode {
+		for _, version := range xcodeVersions {
+			labels = append(labels, labelValue("xcode", version))
+		}
+	}
+	if runtime.Health {
+		if err := l.disk.CheckFree(0); err != nil {
+			labels = append(labels, "health-low-disk")
+		} else {
+			labels = append(labels, "health-ok")
+		}
+	}
+	return labels
+}
+
+func labelValue(prefix string, value string) string {
+	return prefix + "-" + regexLabelInvalidChars.ReplaceAllString(value, "-")
+}
+
+// Update sets desired labels of the runner; labels are applied if changed.
+func (l *RunnerLabeler) Update(runnerID int64, runnerName string, labels []string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	state, ok := l.runners[runnerID]
+	if !ok {
+		state = &labelState{runnerName: runnerName}
+		l.runners[runnerID] = state
+	} else if equalLabels(state.labels, labels) {
+		return
+	}
+	state.labels = labels
+	state.isApplied = false
+
+	select {
+	case l.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Forget stops applying labels of the runner.
+func (l *RunnerLabeler) Forget(runnerID int64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	delete(l.runners, runnerID)
+}
+
+func (l *RunnerLabeler) Run(ctx context.Context, g *errgroup.Group) {
+	g.Go(func() error {
+		ticker := time.NewTicker(labelRetryDelay)
+		defer ticker.Stop()
+		for {
+			l.apply(ctx)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-l.notify:
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+func (l *RunnerLabeler) apply(ctx context.Context) {
+	type update struct {
+		runnerID   int64
+		runnerName string
+		labels     []string
+	}
+
+	l.lock.Lock()
+	now := time.Now()
+	var updates []update
+	for id, state := range l.runners {
+		if state.isApplied || now.Before(state.retryAt) {
+			continue
+		}
+		updates = append(updates, update{runnerID: id, runnerName: state.runnerName, labels: state.labels})
+	}
+	l.lock.Unlock()
+
+	for _, u := range updates {
+		if ctx.Err() != nil {
+			return
+		}
+		err := l.service.SetRunnerLabels(ctx, u.runnerID, u.labels)
+
+		l.lock.Lock()
+		if state, ok := l.runners[u.runnerID]; ok {
+			if err != nil {
+				state.retryAt = time.Now().Add(labelRetryDelay)
+			} else if equalLabels(state.labels, u.labels) {
+				state.isApplied = true
+			}
+		}
+		l.lock.Unlock()
+
+		if err != nil {
+			l.logger.Warnw("failed to update runner labels",
+				"runnerID", u.runnerID,
+				"runnerName", u.runnerName,
+				"error", err,
+			)
+		} else {
+			l.logger.Infow("updated runner labels",
+				"runnerID", u.runnerID,
+				"runnerName", u.runnerName,
+				"labels", u.labels,
+			)
+		}
+	}
+}
+
+func equalLabels(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
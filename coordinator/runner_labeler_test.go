@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// labelService records labels set on runners, and fails while err is set.
+type labelService struct {
+	RunnerService
+	err   error
+	calls []string
+}
+
+func (s *labelService) SetRunnerLabels(ctx context.Context, id int64, labels []string) error {
+	s.calls = append(s.calls, fmt.Sprintf("%d:%v", id, labels))
+	return s.err
+}
+
+func (s *labelService) takeCalls() []string {
+	calls := s.calls
+	s.calls = nil
+	return calls
+}
+
+func TestRunnerLabelerApply(t *testing.T) {
+	ownership, _ := NewOwnership(nil)
+	service := &labelService{}
+	labeler := NewRunnerLabeler(zap.NewNop().Sugar(), service, ownership, nil, "host")
+	ctx := context.Background()
+
+	labeler.Update(1, "runner-1", []string{"xcode-14"})
+	labeler.Update(2, "runner-2", []string{"xcode-13"})
+	labeler.apply(ctx)
+	if calls := service.takeCalls(); len(calls) != 2 {
+		t.Errorf("unexpected calls: %v", calls)
+	}
+
+	// Applied labels are not applied again, unless changed.
+	labeler.Update(1, "runner-1", []string{"xcode-14"})
+	labeler.apply(ctx)
+	if calls := service.takeCalls(); len(calls) != 0 {
+		t.Errorf("unexpected calls of applied labels: %v", calls)
+	}
+	labeler.Update(1, "runner-1", []string{"xcode-14", "health-ok"})
+	labeler.apply(ctx)
+	if calls := fmt.Sprint(service.takeCalls()); calls != "[1:[xcode-14 health-ok]]" {
+		t.Errorf("unexpected calls of changed labels: %v", calls)
+	}
+
+	// Failed updates are retried after delay.
+	service.err = errors.New("failed")
+	labeler.Update(2, "runner-2", []string{"xcode-14"})
+	labeler.apply(ctx)
+	if calls := fmt.Sprint(service.takeCalls()); calls != "[2:[xcode-14]]" {
+		t.Errorf("unexpected calls: %v", calls)
+	}
+	labeler.apply(ctx)
+	if calls := service.takeCalls(); len(calls) != 0 {
+		t.Errorf("unexpected calls before retry delay: %v", calls)
+	}
+	if retryAt := labeler.runners[2].retryAt; retryAt.Before(time.Now().Add(labelRetryDelay / 2)) {
+		t.Errorf("unexpected retry time: %v", retryAt)
+	}
+
+	service.err = nil
+	labeler.runners[2].retryAt = time.Now()
+	labeler.apply(ctx)
+	labeler.apply(ctx)
+	if calls := fmt.Sprint(service.takeCalls()); calls != "[2:[xcode-14]]" {
+		t.Errorf("unexpected calls of retry: %v", calls)
+	}
+
+	// Forgotten runners are not updated.
+	labeler.Update(1, "runner-1", nil)
+	labeler.Forget(1)
+	labeler.apply(ctx)
+	if calls := service.takeCalls(); len(calls) != 0 {
+		t.Errorf("unexpected calls of forgotten runner: %v", calls)
+	}
+}
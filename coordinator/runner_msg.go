@@ -1,10 +1,13 @@
 package main
 
 type RunnerMsgRegister struct {
-	Name     string
-	HostName string
+	Name            string
+	HostName        string
+	ProtocolVersion int
+	Capabilities    []string
 }
 
 type RunnerMsgUpdate struct {
-	RunnerID *int64
+	RunnerID      *int64
+	XcodeVersions []string
 }
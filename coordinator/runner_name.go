@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+const defaultRunnerNameTemplate = "{{.Host}}-{{.Pool}}-{{.InstanceID}}"
+
+var runnerNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// RunnerNameData is the data available to runner name templates.
+type RunnerNameData struct {
+	// Host is the host name of coordinator, without ".local" suffix.
+	Host string
+	// Pool is the pool name of runner config.
+	Pool       string
+	Slot       int
+	InstanceID uint32
+}
+
+func NewRunnerNameData(host string, config *RunnerConfig, slot int, instanceID uint32) RunnerNameData {
+	return RunnerNameData{
+		Host:       strings.TrimSuffix(host, ".local"),
+		Pool:       config.Pool,
+		Slot:       slot,
+		InstanceID: instanceID,
+	}
+}
+
+// RenderRunnerName renders the runner name template of runner config.
+func RenderRunnerName(config *RunnerConfig, data RunnerNameData) (string, error) {
+	text := config.NameTemplate
+	if text == "" {
+		text = defaultRunnerNameTemplate
+	}
+
+	tmpl, err := template.New("name").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid name template: %w", err)
+	}
+	var name strings.Builder
+	if err := tmpl.Execute(&name, data); err != nil {
+		return "", fmt.Errorf("invalid name template: %w", err)
+	}
+	if !runnerNamePattern.MatchString(name.String()) {
+		return "", fmt.Errorf("invalid runner name: %q", name.String())
+	}
+	return name.String(), nil
+}
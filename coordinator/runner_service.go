@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/google/go-github/v45/github"
+	"github.com/oursky/github-ci-support/githublib"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+// RunnerService provides GitHub runner operations to the coordinator, either
+// directly or through cluster controller.
+type RunnerService interface {
+	// URL is the GitHub URL of runner target.
+	URL() string
+	RegistrationToken(ctx context.Context) (*githublib.RegistrationToken, error)
+	DeleteRunner(ctx context.Context, id int64) error
+	// SetRunnerLabels replaces custom labels of the runner.
+	SetRunnerLabels(ctx context.Context, id int64, labels []string) error
+	// RunSync syncs remote runners to result channel until context is done;
+	// result channel is closed on exit.
+	RunSync(ctx context.Context, g *errgroup.Group, result chan<- *RemoteRunners)
+	// Resync requests next sync as soon as allowed.
+	Resync()
+}
+
+// GitHubRunnerService talks to GitHub directly.
+type GitHubRunnerService struct {
+	target       githublib.RunnerTarget
+	client       *github.Client
+	tokens       *githublib.RegistrationTokenStore
+	synchronizer *Synchronizer
+}
+
+func NewGitHubRunnerService(logger *zap.SugaredLogger, target githublib.RunnerTarget, client *github.Client) *GitHubRunnerService {
+	return &GitHubRunnerService{
+		target:       target,
+		client:       client,
+		tokens:       githublib.NewRegistrationTokenStore(target, client),
+		synchronizer: NewSynchronizer(logger, target, client),
+	}
+}
+
+func (s *GitHubRunnerService) URL() string {
+	return s.target.URL()
+}
+
+func (s *GitHubRunnerService) RegistrationToken(ctx context.Context) (*githublib.RegistrationToken, error) {
+	return s.tokens.Get()
+}
+
+func (s *GitHubRunnerService) DeleteRunner(ctx context.Context, id int64) error {
+	err := s.target.DeleteRunner(ctx, s.client, id)
+	var errResp *github.ErrorResponse
+	if errors.As(err, &errResp) && errResp.Response != nil && errResp.Response.StatusCode == http.StatusNotFound {
+		// Already deleted.
+		return nil
+	}
+	return err
+}
+
+func (s *GitHubRunnerService) SetRunnerLabels(ctx context.Context, id int64, labels []string) error {
+	_, err := s.target.SetRunnerLabels(ctx, s.client, id, labels)
+	return err
+}
+
+func (s *GitHubRunnerService) RunSync(ctx context.Context, g *errgroup.Group, result chan<- *RemoteRunners) {
+	s.synchronizer.Run(ctx, g, result)
+}
+
+func (s *GitHubRunnerService) Resync() {
+	s.synchronizer.Resync()
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/oursky/github-ci-support/githublib"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// FakeTarget is the runner target simulating GitHub, for testing with
+	// fake backend.
+	FakeTarget = "fake"
+
+	fakeSyncInterval time.Duration = 5 * time.Second
+)
+
+// FakeRunnerService simulates GitHub: runners listed by the lister are
+// online until deleted.
+type FakeRunnerService struct {
+	logger *zap.SugaredLogger
+	lister func() []RemoteRunner
+	resync chan struct{}
+
+	lock    *sync.Mutex
+	deleted map[int64]bool
+}
+
+func NewFakeRunnerService(logger *zap.SugaredLogger, lister func() []RemoteRunner) *FakeRunnerService {
+	return &FakeRunnerService{
+		logger:  logger.Named("fake-github"),
+		lister:  lister,
+		resync:  make(chan struct{}, 1),
+		lock:    new(sync.Mutex),
+		deleted: make(map[int64]bool),
+	}
+}
+
+func (s *FakeRunnerService) URL() string {
+	return "https://github.com/fake"
+}
+
+func (s *FakeRunnerService) RegistrationToken(ctx context.Context) (*githublib.RegistrationToken, error) {
+	return &githublib.RegistrationToken{Value: "fake", ExpiresAt: time.Now().Add(1 * time.Hour)}, nil
+}
+
+func (s *FakeRunnerService) DeleteRunner(ctx context.Context, id int64) error {
+	s.logger.Infow("deleting runner", "runnerID", id)
+	s.lock.Lock()
+	s.deleted[id] = true
+	s.lock.Unlock()
+	return nil
+}
+
+func (s *FakeRunnerService) SetRunnerLabels(ctx context.Context, id int64, labels []string) error {
+	s.logger.Infow("setting runner labels", "runnerID", id, "labels", labels)
+	return nil
+}
+
+func (s *FakeRunnerService) Resync() {
+	select {
+	case s.resync <- struct{}{}:
+	default:
+	}
+}
+
+func (s *FakeRunnerService) RunSync(ctx context.Context, g *errgroup.Group, result chan<- *RemoteRunners) {
+	g.Go(func() error {
+		defer close(result)
+
+		for epoch := int64(1); ; epoch++ {
+			remote := &RemoteRunners{
+				BeginTime: time.Now(),
+				Epoch:     epoch,
+				Runners:   make(map[string]RemoteRunner),
+			}
+			s.lock.Lock()
+			for _, runner := range s.lister() {
+				if !s.deleted[runner.ID] {
+					remote.Runners[runner.Name] = runner
+				}
+			}
+			s.lock.Unlock()
+
+			select {
+			case result <- remote:
+			case <-ctx.Done():
+				return nil
+			}
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-s.resync:
+			case <-time.After(fakeSyncInterval):
+			}
+		}
+	})
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// macOS software license allows at most 2 concurrent macOS guests.
+const defaultMaxMacOSGuests = 2
+
+type Resources struct {
+	CPUCount    int    `json:"cpuCount"`
+	MemoryMB    uint64 `json:"memoryMB"`
+	MacOSGuests int    `json:"macOSGuests"`
+}
+
+func (r Resources) Add(o Resources) Resources {
+	return Resources{
+		CPUCount:    r.CPUCount + o.CPUCount,
+		MemoryMB:    r.MemoryMB + o.MemoryMB,
+		MacOSGuests: r.MacOSGuests + o.MacOSGuests,
+	}
+}
+
+func (r Resources) Sub(o Resources) Resources {
+	return Resources{
+		CPUCount:    r.CPUCount - o.CPUCount,
+		MemoryMB:    r.MemoryMB - o.MemoryMB,
+		MacOSGuests: r.MacOSGuests - o.MacOSGuests,
+	}
+}
+
+// RunnerResources determines the resources required by an instance of the
+// runner config.
+func RunnerResources(config *RunnerConfig) (Resources, error) {
+	res := Resources{CPUCount: config.CPUCount, MemoryMB: config.MemoryMB}
+
+	switch config.Backend {
+	case "", BackendVMCtl:
+		res.MacOSGuests = 1
+		data, err := os.ReadFile(config.VMConfigPath)
+		if err != nil {
+			return res, err
+		}
+		var vmConfig struct {
+			CPUCount int    `json:"cpuCount"`
+			MemoryMB uint64 `json:"memoryMB"`
+		}
+		if err := json.Unmarshal(data, &vmConfig); err != nil {
+			return res, fmt.Errorf("malformed VM config: %w", err)
+		}
+		if res.CPUCount == 0 {
+			res.CPUCount = vmConfig.CPUCount
+		}
+		if res.MemoryMB == 0 {
+			res.MemoryMB = vmConfig.MemoryMB
+		}
+
+	case BackendTart:
+		res.MacOSGuests = 1
+	}
+
+	return res, nil
+}
+
+type schedulerWaiter struct {
+	slot    int
+	req     Resources
+	ready   chan struct{}
+	granted bool
+}
+
+// Scheduler tracks resources committed to running instances against host
+// capacity, and queues instance starts that would overcommit the host.
+type Scheduler struct {
+	logger   *zap.SugaredLogger
+	capacity Resources
+
+	lock      *sync.Mutex
+	committed Resources
+	queue     []*schedulerWaiter
+	reason    string
+}
+
+func NewScheduler(logger *zap.SugaredLogger, config *HostConfig) *Scheduler {
+	capacity := Resources{
+		CPUCount:    config.CPUCount,
+		MemoryMB:    config.MemoryMB,
+		MacOSGuests: config.MaxMacOSGuests,
+	}
+	if capacity.CPUCount == 0 {
+		capacity.CPUCount = runtime.NumCPU()
+	}
+	if capacity.MacOSGuests == 0 {
+		capacity.MacOSGuests = defaultMaxMacOSGuests
+	}
+
+	return &Scheduler{
+		logger:   logger.Named("scheduler"),
+		capacity: capacity,
+		lock:     new(sync.Mutex),
+	}
+}
+
+// Validate checks whether the request can ever be satisfied by the host.
+func (s *Scheduler) Validate(req Resources) error {
+	if reason := s.insufficient(Resources{}, req); reason != "" {
+		return fmt.Errorf("exceeds host capacity: %s", reason)
+	}
+	return nil
+}
+
+// Acquire waits until the requested resources are available and commits
+// them. Requests are granted in FIFO order.
+func (s *Scheduler) Acquire(ctx context.Context, slot int, req Resources) error {
+	w := &schedulerWaiter{slot: slot, req: req, ready: make(chan struct{})}
+
+	s.lock.Lock()
+	s.queue = append(s.queue, w)
+	s.grant()
+	if !w.granted {
+		s.logger.Infow("slot waiting for capacity", "slot", slot, "reason", s.reason)
+	}
+	s.lock.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if w.granted {
+		s.committed = s.committed.Sub(req)
+	} else {
+		for i, q := range s.queue {
+			if q == w {
+				s.queue = append(s.queue[:i], s.queue[i+1:]...)
+				break
+			}
+		}
+	}
+	s.grant()
+	return ctx.Err()
+}
+
+func (s *Scheduler) Release(req Resources) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.committed = s.committed.Sub(req)
+	s.grant()
+}
+
+// Waiting returns the slots waiting for capacity, with reason.
+func (s *Scheduler) Waiting() map[int]string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	waiting := make(map[int]string)
+	for i, w := range s.queue {
+		if i == 0 {
+			waiting[w.slot] = s.reason
+		} else {
+			waiting[w.slot] = fmt.Sprintf("queued behind slot %d", s.queue[0].slot)
+		}
+	}
+	return waiting
+}
+
+func (s *Scheduler) Committed() (committed Resources, capacity Resources) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.committed, s.capacity
+}
+
+func (s *Scheduler) grant() {
+	s.reason = ""
+	for len(s.queue) > 0 {
+		w := s.queue[0]
+		if reason := s.insufficient(s.committed, w.req); reason != "" {
+			s.reason = reason
+			return
+		}
+
+		s.committed = s.committed.Add(w.req)
+		s.queue = s.queue[1:]
+		w.granted = true
+		close(w.ready)
+	}
+}
+
+func (s *Scheduler) insufficient(committed Resources, req Resources) string {
+	var reasons []string
+	if s.capacity.CPUCount > 0 && committed.CPUCount+req.CPUCount > s.capacity.CPUCount {
+		reasons = append(reasons, fmt.Sprintf("CPU: need %d, committed %d of %d",
+			req.CPUCount, committed.CPUCount, s.capacity.CPUCount))
+	}
+	if s.capacity.MemoryMB > 0 && committed.MemoryMB+req.MemoryMB > s.capacity.MemoryMB {
+		reasons = append(reasons, fmt.Sprintf("memory: need %dMB, committed %dMB of %dMB",
+			req.MemoryMB, committed.MemoryMB, s.capacity.MemoryMB))
+	}
+	if committed.MacOSGuests+req.MacOSGuests > s.capacity.MacOSGuests {
+		reasons = append(reasons, fmt.Sprintf("macOS guests: committed %d of %d",
+			committed.MacOSGuests, s.capacity.MacOSGuests))
+	}
+	return strings.Join(reasons, "; ")
+}
@@ -2,47 +2,140 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/google/go-github/v45/github"
 	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
 
-	"github.com/oursky/github-ci-support/githublib"
+	"github.com/oursky/github-ci-support/coordinatorclient"
 )
 
+const defaultServerAddr = "0.0.0.0"
+
 type Server struct {
-	logger *zap.SugaredLogger
-	target githublib.RunnerTarget
-	client *github.Client
-	token  *githublib.RegistrationTokenStore
+	logger    *zap.SugaredLogger
+	config    *ServerConfig
+	service   RunnerService
+	ownership *Ownership
+
+	hostName   string
+	runnerHost string
+	hosts      []string
+	ca         *CertificateAuthority
+	tokenKey   []byte
+	urls       []string
 
 	Instances *sync.Map
 }
 
-func NewServer(logger *zap.SugaredLogger, target githublib.RunnerTarget, client *github.Client) *Server {
+func NewServer(logger *zap.SugaredLogger, config *ServerConfig, service RunnerService, ownership *Ownership) (*Server, error) {
+	hostName, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("cannot get hostname: %w", err)
+	}
+	if !strings.HasSuffix(hostName, ".local") {
+		hostName = hostName + ".local"
+	}
+
+	// Guests without mDNS can reach server by IP address.
+	hosts := []string{hostName}
+	if ip := net.ParseIP(config.Addr); ip != nil && !ip.IsUnspecified() {
+		// Server is reachable only by the bound address.
+		hosts = []string{config.Addr}
+	} else if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
+				hosts = append(hosts, ipNet.IP.String())
+			}
+		}
+	}
+
+	tokenKey := make([]byte, 32)
+	if _, err := rand.Read(tokenKey); err != nil {
+		return nil, fmt.Errorf("cannot generate token key: %w", err)
+	}
+
+	var ca *CertificateAuthority
+	if config.TLS {
+		ca, err = NewCertificateAuthority()
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &Server{
-		logger:    logger.Named("server"),
-		target:    target,
-		client:    client,
-		token:     githublib.NewRegistrationTokenStore(target, client),
-		Instances: new(sync.Map),
+		logger:     logger.Named("server"),
+		config:     config,
+		service:    service,
+		ownership:  ownership,
+		hostName:   hostName,
+		runnerHost: hostName,
+		hosts:      hosts,
+		ca:         ca,
+		tokenKey:   tokenKey,
+		Instances:  new(sync.Map),
+	}, nil
+}
+
+func (s *Server) HostName() string {
+	return s.hostName
+}
+
+// SetRunnerHost sets the host name used in runner names, to distinguish
+// cluster agents sharing a host.
+func (s *Server) SetRunnerHost(host string) {
+	s.runnerHost = host
+}
+
+// RunnerHost returns the host name used in runner names.
+func (s *Server) RunnerHost() string {
+	return strings.TrimSuffix(s.runnerHost, ".local")
+}
+
+// URL returns the preferred server URL for guests; available after Run.
+func (s *Server) URL() string {
+	if len(s.urls) == 0 {
+		return ""
+	}
+	return s.urls[0]
+}
+
+// URLs returns the server URLs for guests, in order of preference;
+// available after Run.
+func (s *Server) URLs() []string {
+	return s.urls
+}
+
+// CACertPEM returns the CA certificate of server if TLS is enabled.
+func (s *Server) CACertPEM() []byte {
+	if s.ca == nil {
+		return nil
 	}
+	return s.ca.CertPEM()
 }
 
-func (s *Server) Run(ctx context.Context, g *errgroup.Group) int {
-	listener, err := net.Listen("tcp", "0.0.0.0:0")
-	port := 0
+func (s *Server) Run(ctx context.Context, g *errgroup.Group) {
+	listener, err := s.listen()
 	if err == nil {
-		port = listener.Addr().(*net.TCPAddr).Port
+		port := listener.Addr().(*net.TCPAddr).Port
+		scheme := "http"
+		if s.ca != nil {
+			scheme = "https"
+		}
+		for _, host := range s.hosts {
+			s.urls = append(s.urls, fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(host, strconv.Itoa(port))))
+		}
 	}
 
 	g.Go(func() error {
@@ -52,8 +145,31 @@ func (s *Server) Run(ctx context.Context, g *errgroup.Group) int {
 		s.runHTTP(ctx, listener)
 		return nil
 	})
+}
 
-	return port
+func (s *Server) listen() (net.Listener, error) {
+	addr := s.config.Addr
+	if addr == "" {
+		addr = defaultServerAddr
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(addr, strconv.Itoa(s.config.Port)))
+	if err != nil {
+		return nil, err
+	}
+	if s.ca == nil {
+		return listener, nil
+	}
+
+	cert, err := s.ca.IssueServerCertificate(append(s.hosts, "localhost", "127.0.0.1"))
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return tls.NewListener(listener, &tls.Config{
+		Certificates: []tls.Certificate{*cert},
+		MinVersion:   tls.VersionTLS12,
+	}), nil
 }
 
 func (s *Server) runHTTP(ctx context.Context, listener net.Listener) {
@@ -65,12 +181,15 @@ func (s *Server) runHTTP(ctx context.Context, listener net.Listener) {
 		ErrorLog:     zap.NewStdLog(s.logger.Desugar()),
 	}
 
-	mux.HandleFunc("/register", s.register)
-	mux.HandleFunc("/update", s.update)
-	mux.HandleFunc("/wait", s.wait)
+	mux.HandleFunc(coordinatorclient.PathRegister, s.register)
+	mux.HandleFunc(coordinatorclient.PathUpdate, s.update)
+	mux.HandleFunc(coordinatorclient.PathWait, s.wait)
+	mux.HandleFunc(coordinatorclient.PathRenew, s.renew)
 
-	addr := listener.Addr().(*net.TCPAddr)
-	s.logger.Infow("server started", "addr", addr.String())
+	s.logger.Infow("server started",
+		"addr", listener.Addr().String(),
+		"urls", s.urls,
+	)
 
 	// Do not shutdown on signal: let runner call wait API
 	// Shutdown along with the process.
@@ -87,31 +206,46 @@ func (s *Server) register(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	name := r.FormValue("name")
-	hostName := r.FormValue("hostName")
-	instance.Post(RunnerMsgRegister{Name: name, HostName: hostName})
+	req := coordinatorclient.DecodeRegisterRequest(r.Form)
+	// Runner names are assigned by coordinator and namespaced, so that
+	// runners of other coordinators are never touched.
+	name, err := RenderRunnerName(instance.Config, NewRunnerNameData(s.runnerHost, instance.Config, instance.slot, instance.id))
+	if err != nil {
+		s.logger.Errorw("cannot assign runner name", "error", err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	name = s.ownership.RunnerName(name)
+	if req.Name != "" && req.Name != name {
+		s.logger.Debugw("overriding guest runner name", "requested", req.Name, "name", name)
+	}
+	protocolVersion, capabilities := coordinatorclient.Negotiate(
+		req.ProtocolVersion, req.Capabilities,
+		coordinatorclient.ProtocolVersion, coordinatorclient.Capabilities,
+	)
+	instance.Post(RunnerMsgRegister{
+		Name:            name,
+		HostName:        req.HostName,
+		ProtocolVersion: protocolVersion,
+		Capabilities:    capabilities,
+	})
 
-	token, err := s.token.Get()
+	token, err := s.service.RegistrationToken(r.Context())
 	if err != nil {
 		s.logger.Errorw("cannot get registration token", "error", err)
 		rw.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	type resp struct {
-		Name      string `json:"name"`
-		GitHubURL string `json:"gitHubURL"`
-		Token     string `json:"token"`
-		Group     string `json:"group"`
-		Labels    string `json:"labels"`
-	}
 	rw.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(rw).Encode(resp{
-		Name:      name,
-		GitHubURL: s.target.URL(),
-		Token:     token.Value,
-		Group:     instance.Config.RunnerGroup,
-		Labels:    strings.Join(instance.Config.Labels, ","),
+	json.NewEncoder(rw).Encode(coordinatorclient.RegisterResponse{
+		Name:            name,
+		GitHubURL:       s.service.URL(),
+		Token:           token.Value,
+		Group:           instance.Config.RunnerGroup,
+		Labels:          strings.Join(s.ownership.RunnerLabels(instance.Config.Labels), ","),
+		ProtocolVersion: protocolVersion,
+		Capabilities:    capabilities,
 	})
 }
 
@@ -121,18 +255,13 @@ func (s *Server) update(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	runnerIDStr := r.FormValue("runnerID")
-	var runnerID *int64
-	if runnerIDStr != "" {
-		id, err := strconv.ParseInt(runnerIDStr, 10, 64)
-		if err != nil {
-			rw.WriteHeader(http.StatusBadRequest)
-			rw.Write([]byte(err.Error()))
-			return
-		}
-		runnerID = &id
+	req, err := coordinatorclient.DecodeUpdateRequest(r.Form)
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		rw.Write([]byte(err.Error()))
+		return
 	}
-	instance.Post(RunnerMsgUpdate{RunnerID: runnerID})
+	instance.Post(RunnerMsgUpdate{RunnerID: req.RunnerID, XcodeVersions: req.XcodeVersions})
 
 	rw.WriteHeader(http.StatusNoContent)
 }
@@ -146,12 +275,24 @@ func (s *Server) wait(rw http.ResponseWriter, r *http.Request) {
 	select {
 	case <-instance.NeedTerminate():
 		rw.WriteHeader(http.StatusOK)
-		rw.Write([]byte("stop"))
-	case <-time.After(60 * time.Second):
+		rw.Write([]byte(coordinatorclient.WaitStop))
+	case <-time.After(coordinatorclient.WaitTimeout):
 		rw.WriteHeader(http.StatusRequestTimeout)
 	}
 }
 
+func (s *Server) renew(rw http.ResponseWriter, r *http.Request) {
+	instance, ok := s.check(rw, r, false)
+	if !ok {
+		return
+	}
+
+	token, expiresAt := s.IssueToken(instance.ID())
+	instance.logger.Debugw("renewed token", "expiresAt", expiresAt)
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(coordinatorclient.RenewResponse{Token: token, ExpiresAt: expiresAt})
+}
+
 func (s *Server) check(rw http.ResponseWriter, r *http.Request, parseForm bool) (*RunnerInstance, bool) {
 	authz := r.Header.Get("Authorization")
 	bearer, token, ok := strings.Cut(authz, " ")
@@ -160,14 +301,20 @@ func (s *Server) check(rw http.ResponseWriter, r *http.Request, parseForm bool)
 		return nil, false
 	}
 
-	instance, ok := s.Instances.Load(token)
+	instanceID, err := s.verifyToken(token)
+	if err != nil {
+		s.reqError(rw, err.Error())
+		return nil, false
+	}
+
+	instance, ok := s.Instances.Load(instanceID)
 	if !ok {
-		s.reqError(rw, "invalid token")
+		s.reqError(rw, errInvalidToken.Error())
 		return nil, false
 	}
 
 	if parseForm {
-		err := r.ParseForm()
+		err = r.ParseForm()
 		if err != nil {
 			err = fmt.Errorf("malformed request: %w", err)
 			s.reqError(rw, err.Error())
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultTokenTTL time.Duration = 1 * time.Hour
+
+var (
+	errInvalidToken = errors.New("invalid token")
+	errTokenExpired = errors.New("token expired")
+)
+
+// IssueToken issues a bearer token bound to the instance. The token is
+// signed by the server and expires after the configured TTL; guests renew it
+// before expiry. It is also rejected once its instance is removed from the
+// server.
+func (s *Server) IssueToken(instanceID uint32) (string, time.Time) {
+	ttl := time.Duration(s.config.TokenTTL)
+	if ttl <= 0 {
+		ttl = defaultTokenTTL
+	}
+	expiresAt := time.Now().Add(ttl).Truncate(time.Second)
+
+	payload := fmt.Sprintf("%d.%d", instanceID, expiresAt.Unix())
+	return payload + "." + s.signToken(payload), expiresAt
+}
+
+func (s *Server) verifyToken(token string) (uint32, error) {
+	payload, signature, ok := cutLast(token, ".")
+	if !ok {
+		return 0, errInvalidToken
+	}
+	if !hmac.Equal([]byte(signature), []byte(s.signToken(payload))) {
+		return 0, errInvalidToken
+	}
+
+	idStr, expiresAtStr, ok := strings.Cut(payload, ".")
+	if !ok {
+		return 0, errInvalidToken
+	}
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		return 0, errInvalidToken
+	}
+	expiresAt, err := strconv.ParseInt(expiresAtStr, 10, 64)
+	if err != nil {
+		return 0, errInvalidToken
+	}
+	if time.Now().After(time.Unix(expiresAt, 0)) {
+		return 0, errTokenExpired
+	}
+
+	return uint32(id), nil
+}
+
+func (s *Server) signToken(payload string) string {
+	mac := hmac.New(sha256.New, s.tokenKey)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
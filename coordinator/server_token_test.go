@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServerToken(t *testing.T) {
+	s := &Server{config: &ServerConfig{TokenTTL: Duration(time.Hour)}, tokenKey: []byte("key")}
+
+	token, expiresAt := s.IssueToken(7)
+	if d := time.Until(expiresAt); d <= 59*time.Minute || d > time.Hour {
+		t.Errorf("unexpected expiry: %s", expiresAt)
+	}
+	if id, err := s.verifyToken(token); err != nil || id != 7 {
+		t.Errorf("unexpected result: %d, %v", id, err)
+	}
+
+	expired := fmt.Sprintf("7.%d", time.Now().Add(-time.Second).Unix())
+	if _, err := s.verifyToken(expired + "." + s.signToken(expired)); !errors.Is(err, errTokenExpired) {
+		t.Errorf("expected expired token, got %v", err)
+	}
+
+	other := &Server{config: &ServerConfig{}, tokenKey: []byte("other key")}
+	for _, invalid := range []string{
+		"",
+		"7",
+		strings.Replace(token, "7.", "8.", 1),
+		token + "x",
+		func() string { token, _ := other.IssueToken(7); return token }(),
+	} {
+		if _, err := s.verifyToken(invalid); !errors.Is(err, errInvalidToken) {
+			t.Errorf("%q: expected invalid token, got %v", invalid, err)
+		}
+	}
+}
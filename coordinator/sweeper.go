@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	defaultSweepOfflineAfter time.Duration = 1 * time.Hour
+	defaultSweepInterval     time.Duration = 10 * time.Minute
+)
+
+// SweeperConfig enables deletion of orphan runners: offline runners owned by
+// this coordinator but not tracked by it, e.g. after failed deletions. Zero
+// values use defaults (offline for 1 hour, sweep every 10 minutes).
+type SweeperConfig struct {
+	OfflineAfter Duration `json:"offlineAfter,omitempty"`
+	Interval     Duration `json:"interval,omitempty"`
+	// DryRun only logs orphan runners without deleting them.
+	DryRun bool `json:"dryRun,omitempty"`
+	// AuditLog is the path of audit log; defaults to "sweeper-audit.log" in
+	// state dir.
+	AuditLog string `json:"auditLog,omitempty"`
+}
+
+type SweeperAuditEntry struct {
+	Time         time.Time `json:"time"`
+	Action       string    `json:"action"`
+	RunnerID     int64     `json:"runnerID"`
+	RunnerName   string    `json:"runnerName"`
+	OfflineSince time.Time `json:"offlineSince"`
+	Error        string    `json:"error,omitempty"`
+}
+
+type orphanRunner struct {
+	runner       RemoteRunner
+	offlineSince time.Time
+	// dryRunAudited is set once the orphan is recorded in dry-run mode.
+	dryRunAudited bool
+}
+
+// Sweeper deletes orphan runners observed through remote runner syncs.
+// Offline time is counted from first observation, so runners are not deleted
+// sooner after coordinator restarts.
+type Sweeper struct {
+	logger       *zap.SugaredLogger
+	service      RunnerService
+	ownership    *Ownership
+	offlineAfter time.Duration
+	interval     time.Duration
+	dryRun       bool
+	auditLog     string
+
+	lock    *sync.Mutex
+	orphans map[int64]*orphanRunner
+	now     time.Time
+}
+
+func NewSweeper(logger *zap.SugaredLogger, config *SweeperConfig, service RunnerService, ownership *Ownership, auditLog string) *Sweeper {
+	offlineAfter := time.Duration(config.OfflineAfter)
+	if offlineAfter <= 0 {
+		offlineAfter = defaultSweepOfflineAfter
+	}
+	interval := time.Duration(config.Interval)
+	if interval <= 0 {
+		interval = defaultSweepInterval
+	}
+	// Never delete runners not namespaced to this coordinator.
+	dryRun := config.DryRun || ownership.ID() == ""
+
+	return &Sweeper{
+		logger:       logger.Named("sweeper"),
+		service:      service,
+		ownership:    ownership,
+		offlineAfter: offlineAfter,
+		interval:     interval,
+		dryRun:       dryRun,
+		auditLog:     auditLog,
+		lock:         new(sync.Mutex),
+		orphans:      make(map[int64]*orphanRunner),
+	}
+}
+
+// Observe updates orphan candidates from a remote runners sync; tracked
+// contains names of runners tracked locally.
+func (s *Sweeper) Observe(remote *RemoteRunners, tracked map[string]bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.now = remote.BeginTime
+	seen := make(map[int64]bool)
+	for _, runner := range remote.Runners {
+		if runner.IsOnline || tracked[runner.Name] || !s.ownership.Owns(runner) {
+			continue
+		}
+		seen[runner.ID] = true
+		if _, ok := s.orphans[runner.ID]; !ok {
+			s.orphans[runner.ID] = &orphanRunner{runner: runner, offlineSince: remote.BeginTime}
+		}
+	}
+	for id := range s.orphans {
+		if !seen[id] {
+			delete(s.orphans, id)
+		}
+	}
+}
+
+func (s *Sweeper) Run(ctx context.Context, g *errgroup.Group) {
+	s.logger.Infow("sweeper started",
+		"offlineAfter", s.offlineAfter.String(),
+		"interval", s.interval.String(),
+		"dryRun", s.dryRun,
+		"auditLog", s.auditLog,
+	)
+
+	g.Go(func() error {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+				s.sweep(ctx)
+			}
+		}
+	})
+}
+
+func (s *Sweeper) sweep(ctx context.Context) {
+	s.lock.Lock()
+	var expired []*orphanRunner
+	for _, orphan := range s.orphans {
+		if s.dryRun && orphan.dryRunAudited {
+			continue
+		}
+		if s.now.Sub(orphan.offlineSince) > s.offlineAfter {
+			expired = append(expired, orphan)
+		}
+	}
+	s.lock.Unlock()
+
+	sort.Slice(expired, func(i, j int) bool {
+		return expired[i].offlineSince.Before(expired[j].offlineSince)
+	})
+
+	deleted := false
+	for _, orphan := range expired {
+		if ctx.Err() != nil {
+			return
+		}
+
+		entry := SweeperAuditEntry{
+			Time:         time.Now(),
+			RunnerID:     orphan.runner.ID,
+			RunnerName:   orphan.runner.Name,
+			OfflineSince: orphan.offlineSince,
+		}
+		if s.dryRun {
+			entry.Action = "dry-run"
+			s.logger.Infow("would delete orphan runner",
+				"runnerID", orphan.runner.ID,
+				"runnerName", orphan.runner.Name,
+				"offlineSince", orphan.offlineSince,
+			)
+
+			s.lock.Lock()
+			orphan.dryRunAudited = true
+			s.lock.Unlock()
+		} else if err := s.service.DeleteRunner(ctx, orphan.runner.ID); err != nil {
+			entry.Action = "failed"
+			entry.Error = err.Error()
+			s.logger.Warnw("failed to delete orphan runner",
+				"runnerID", orphan.runner.ID,
+				"runnerName", orphan.runner.Name,
+				"error", err,
+			)
+		} else {
+			entry.Action = "deleted"
+			deleted = true
+			s.logger.Infow("deleted orphan runner",
+				"runnerID", orphan.runner.ID,
+				"runnerName", orphan.runner.Name,
+				"offlineSince", orphan.offlineSince,
+			)
+
+			s.lock.Lock()
+			delete(s.orphans, orphan.runner.ID)
+			s.lock.Unlock()
+		}
+
+		if err := s.audit(entry); err != nil {
+			s.logger.Warnw("failed to write audit log", "error", err)
+		}
+	}
+
+	if deleted {
+		s.service.Resync()
+	}
+}
+
+func (s *Sweeper) audit(entry SweeperAuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.auditLog), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.auditLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestSweeperDryRunAuditsOnce(t *testing.T) {
+	ownership, err := NewOwnership(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditLog := filepath.Join(t.TempDir(), "sweeper-audit.log")
+	sweeper := NewSweeper(zap.NewNop().Sugar(), &SweeperConfig{DryRun: true}, nil, ownership, auditLog)
+
+	orphan := RemoteRunner{ID: 1, Name: "runner-1"}
+	begin := time.Now()
+	for i := 0; i < 3; i++ {
+		sweeper.Observe(&RemoteRunners{
+			BeginTime: begin.Add(time.Duration(i) * 2 * defaultSweepOfflineAfter),
+			Runners:   map[string]RemoteRunner{orphan.Name: orphan},
+		}, nil)
+		sweeper.sweep(context.Background())
+	}
+
+	data, err := os.ReadFile(auditLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(data, []byte("\n")); n != 1 {
+		t.Errorf("expected 1 audit entry, got %d:\n%s", n, data)
+	}
+}
+
+func TestSweeperWithoutOwnershipNeverDeletes(t *testing.T) {
+	ownership, err := NewOwnership(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditLog := filepath.Join(t.TempDir(), "sweeper-audit.log")
+	// Service is nil; deleting would panic.
+	sweeper := NewSweeper(zap.NewNop().Sugar(), &SweeperConfig{}, nil, ownership, auditLog)
+
+	other := RemoteRunner{ID: 1, Name: "other-coordinator-runner"}
+	begin := time.Now()
+	for i := 0; i < 2; i++ {
+		sweeper.Observe(&RemoteRunners{
+			BeginTime: begin.Add(time.Duration(i) * 2 * defaultSweepOfflineAfter),
+			Runners:   map[string]RemoteRunner{other.Name: other},
+		}, nil)
+		sweeper.sweep(context.Background())
+	}
+
+	data, err := os.ReadFile(auditLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte(`"action":"dry-run"`)) {
+		t.Errorf("expected dry-run audit entry, got:\n%s", data)
+	}
+}
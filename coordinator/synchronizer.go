@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/google/go-github/v45/github"
@@ -11,14 +12,21 @@ import (
 )
 
 const (
-	syncInterval time.Duration = 10 * time.Second
-	syncPageSize int           = 100
+	syncMinInterval      time.Duration = 10 * time.Second
+	syncMaxInterval      time.Duration = 60 * time.Second
+	syncResyncInterval   time.Duration = 3 * time.Second
+	syncBackoffFactor    float64       = 1.5
+	syncRetryAfter       time.Duration = 60 * time.Second
+	syncRateLimitReserve int           = 100
+	syncPageSize         int           = 100
 )
 
 type RemoteRunner struct {
-	ID       int64
-	Name     string
-	IsOnline bool
+	ID       int64    `json:"id"`
+	Name     string   `json:"name"`
+	IsOnline bool     `json:"isOnline"`
+	IsBusy   bool     `json:"isBusy,omitempty"`
+	Labels   []string `json:"labels,omitempty"`
 }
 
 type RemoteRunners struct {
@@ -40,10 +48,19 @@ func (r *RemoteRunners) Lookup(name string, id int64) (*RemoteRunner, bool) {
 	return nil, false
 }
 
+type cachedRunnersPage struct {
+	etag     string
+	nextPage int
+	runners  []RemoteRunner
+}
+
 type Synchronizer struct {
 	logger *zap.SugaredLogger
 	target githublib.RunnerTarget
 	client *github.Client
+
+	pages  map[int]*cachedRunnersPage
+	resync chan struct{}
 }
 
 func NewSynchronizer(logger *zap.SugaredLogger, target githublib.RunnerTarget, client *github.Client) *Synchronizer {
@@ -51,6 +68,8 @@ func NewSynchronizer(logger *zap.SugaredLogger, target githublib.RunnerTarget, c
 		logger: logger.Named("sync"),
 		target: target,
 		client: client,
+		pages:  make(map[int]*cachedRunnersPage),
+		resync: make(chan struct{}, 1),
 	}
 }
 
@@ -61,56 +80,195 @@ func (s *Synchronizer) Run(ctx context.Context, g *errgroup.Group, result chan<-
 	})
 }
 
+// Resync requests the next sync to be performed as soon as allowed,
+// instead of waiting for the regular interval.
+func (s *Synchronizer) Resync() {
+	select {
+	case s.resync <- struct{}{}:
+	default:
+	}
+}
+
 func (s *Synchronizer) run(ctx context.Context, cResult chan<- *RemoteRunners) {
+	defer close(cResult)
+
 	epoch := int64(1)
-	beginTime := time.Now()
-	runners := make(map[string]RemoteRunner)
-	page := 1
+	interval := syncMinInterval
+	delay := syncMinInterval
+	allowResync := true
 
 	for {
+		if !s.wait(ctx, delay, allowResync) {
+			return
+		}
+
+		beginTime := time.Now()
+		runners, changed, rateDelay, err := s.sync(ctx)
+		if err != nil {
+			delay = s.errorDelay(err)
+			allowResync = false
+			s.logger.Warnw("failed to get runners", "error", err, "retryAfter", delay.String())
+			continue
+		}
+
+		if changed {
+			interval = syncMinInterval
+		} else {
+			interval = time.Duration(float64(interval) * syncBackoffFactor)
+			if interval > syncMaxInterval {
+				interval = syncMaxInterval
+			}
+		}
+		delay = interval
+		allowResync = true
+		if rateDelay > delay {
+			delay = rateDelay
+			allowResync = false
+		}
+
+		s.logger.Infow("runners synchronized",
+			"epoch", epoch,
+			"beginTime", beginTime,
+			"count", len(runners),
+			"changed", changed,
+			"nextSync", delay.String(),
+		)
+		result := &RemoteRunners{BeginTime: beginTime, Epoch: epoch, Runners: runners}
 		select {
+		case cResult <- result:
 		case <-ctx.Done():
-			close(cResult)
 			return
+		}
+
+		epoch++
+	}
+}
 
-		case <-time.After(syncInterval):
-			s.logger.Infow("fetching page", "page", page)
-			runnersPage, nextPage, err := s.target.GetRunners(ctx, s.client, page, syncPageSize)
-			if err != nil {
-				s.logger.Warnw("failed to get runners", "error", err)
-				break
+// wait waits for the delay. If allowResync is set, a resync request after
+// the minimal resync interval ends the wait early. Returns false if the
+// context is done.
+func (s *Synchronizer) wait(ctx context.Context, delay time.Duration, allowResync bool) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	minDelay := time.NewTimer(syncResyncInterval)
+	defer minDelay.Stop()
+
+	var resync <-chan struct{}
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+
+		case <-timer.C:
+			return true
+
+		case <-minDelay.C:
+			if allowResync {
+				resync = s.resync
 			}
 
-			for _, r := range runnersPage {
-				runners[r.GetName()] = RemoteRunner{
+		case <-resync:
+			s.logger.Debug("resync requested")
+			return true
+		}
+	}
+}
+
+func (s *Synchronizer) sync(ctx context.Context) (runners map[string]RemoteRunner, changed bool, rateDelay time.Duration, err error) {
+	runners = make(map[string]RemoteRunner)
+	visited := make(map[int]bool)
+	page := 1
+
+	for page != 0 {
+		cached := s.pages[page]
+		etag := ""
+		if cached != nil {
+			etag = cached.etag
+		}
+
+		s.logger.Debugw("fetching page", "page", page)
+		result, err := s.target.GetRunnersPage(ctx, s.client, page, syncPageSize, etag)
+		if err != nil {
+			return nil, false, 0, err
+		}
+
+		if d := s.rateLimitDelay(result.Rate); d > rateDelay {
+			rateDelay = d
+		}
+
+		visited[page] = true
+		if !result.NotModified {
+			changed = true
+			cached = &cachedRunnersPage{etag: result.ETag, nextPage: result.NextPage}
+			for _, r := range result.Runners {
+				cached.runners = append(cached.runners, RemoteRunner{
 					ID:       r.GetID(),
 					Name:     r.GetName(),
 					IsOnline: r.GetStatus() == "online",
-				}
+					IsBusy:   r.GetBusy(),
+					Labels:   runnerLabels(r.Labels),
+				})
 			}
+			s.pages[page] = cached
+		}
 
-			if nextPage != 0 {
-				page = nextPage
-				break
-			}
+		for _, r := range cached.runners {
+			runners[r.Name] = r
+		}
+		page = cached.nextPage
+	}
 
-			s.logger.Infow("runners synchronized",
-				"epoch", epoch,
-				"beginTime", beginTime,
-				"count", len(runners),
-			)
-			result := &RemoteRunners{BeginTime: beginTime, Epoch: epoch, Runners: runners}
-			select {
-			case cResult <- result:
-			case <-ctx.Done():
-				close(cResult)
-				return
-			}
+	for p := range s.pages {
+		if !visited[p] {
+			delete(s.pages, p)
+		}
+	}
+
+	return runners, changed, rateDelay, nil
+}
+
+// runnerLabels returns names of runner labels, or nil if labels are unknown.
+func runnerLabels(labels []*github.RunnerLabels) []string {
+	if labels == nil {
+		return nil
+	}
+	names := make([]string, 0, len(labels))
+	for _, l := range labels {
+		names = append(names, l.GetName())
+	}
+	return names
+}
 
-			epoch++
-			beginTime = time.Now()
-			runners = make(map[string]RemoteRunner)
-			page = 1
+// rateLimitDelay returns how long to wait before next sync to keep the
+// reserved API quota available for other clients.
+func (s *Synchronizer) rateLimitDelay(rate github.Rate) time.Duration {
+	if rate.Limit == 0 || rate.Remaining > syncRateLimitReserve {
+		return 0
+	}
+	s.logger.Warnw("approaching rate limit",
+		"remaining", rate.Remaining,
+		"limit", rate.Limit,
+		"reset", rate.Reset.Time,
+	)
+	return time.Until(rate.Reset.Time)
+}
+
+func (s *Synchronizer) errorDelay(err error) time.Duration {
+	var rateLimitErr *github.RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		if d := time.Until(rateLimitErr.Rate.Reset.Time); d > syncMinInterval {
+			return d
 		}
+		return syncMinInterval
 	}
+
+	var abuseErr *github.AbuseRateLimitError
+	if errors.As(err, &abuseErr) {
+		if retryAfter := abuseErr.GetRetryAfter(); retryAfter > 0 {
+			return retryAfter
+		}
+		return syncRetryAfter
+	}
+
+	return syncMinInterval
 }
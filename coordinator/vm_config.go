@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// renderVMConfig renders a per-instance vmctl config from the template
+// config, applying overrides of runner config.
+func renderVMConfig(templatePath string, outputPath string, config *RunnerConfig, macAddress string) error {
+	data, err := os.ReadFile(templatePath)
+	if err != nil {
+		return err
+	}
+
+	var vmConfig map[string]any
+	if err := json.Unmarshal(data, &vmConfig); err != nil {
+		return fmt.Errorf("malformed VM config: %w", err)
+	}
+
+	if config.CPUCount > 0 {
+		vmConfig["cpuCount"] = config.CPUCount
+	}
+	if config.MemoryMB > 0 {
+		vmConfig["memoryMB"] = config.MemoryMB
+	}
+	vmConfig["macAddress"] = macAddress
+
+	// Disk paths are relative to the config file.
+	templateDir, err := filepath.Abs(filepath.Dir(templatePath))
+	if err != nil {
+		return err
+	}
+	if disks, ok := vmConfig["additionalDisks"].([]any); ok {
+		for _, disk := range disks {
+			if disk, ok := disk.(map[string]any); ok {
+				if path, ok := disk["path"].(string); ok && !filepath.IsAbs(path) {
+					disk["path"] = filepath.Join(templateDir, path)
+				}
+			}
+		}
+	}
+
+	data, err = json.MarshalIndent(vmConfig, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(outputPath, data, 0600)
+}
+
+// growDisk grows the disk image to the size; disks are never shrunk.
+func growDisk(path string, sizeMB uint64) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	size := int64(sizeMB) * 1024 * 1024
+	if info.Size() >= size {
+		return nil
+	}
+	return os.Truncate(path, size)
+}
+
+// MACAllocator allocates unique locally administered MAC addresses.
+type MACAllocator struct {
+	lock  *sync.Mutex
+	inUse map[string]bool
+}
+
+func NewMACAllocator() *MACAllocator {
+	return &MACAllocator{
+		lock:  new(sync.Mutex),
+		inUse: make(map[string]bool),
+	}
+}
+
+func (a *MACAllocator) Allocate() (string, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	for {
+		var buf [6]byte
+		if _, err := rand.Read(buf[:]); err != nil {
+			return "", fmt.Errorf("cannot generate MAC address: %w", err)
+		}
+		// Locally administered, unicast.
+		buf[0] = (buf[0] | 0x02) & 0xfe
+
+		mac := fmt.Sprintf("%02x:%02x:%02x:%02x:%02x:%02x", buf[0], buf[1], buf[2], buf[3], buf[4], buf[5])
+		if !a.inUse[mac] {
+			a.inUse[mac] = true
+			return mac, nil
+		}
+	}
+}
+
+func (a *MACAllocator) Release(mac string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	delete(a.inUse, mac)
+}
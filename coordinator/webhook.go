@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/google/go-github/v45/github"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+const defaultWebhookPath = "/webhook"
+
+type selfHostedRunnerEvent struct {
+	Action string         `json:"action,omitempty"`
+	Runner *github.Runner `json:"runner,omitempty"`
+}
+
+// Webhook receives GitHub webhook events and feeds runner status changes
+// to the monitor, so that changes are observed before next full sync.
+type Webhook struct {
+	logger  *zap.SugaredLogger
+	config  *WebhookConfig
+	monitor *Monitor
+}
+
+func NewWebhook(logger *zap.SugaredLogger, config *WebhookConfig, monitor *Monitor) *Webhook {
+	return &Webhook{
+		logger:  logger.Named("webhook"),
+		config:  config,
+		monitor: monitor,
+	}
+}
+
+func (w *Webhook) Run(ctx context.Context, g *errgroup.Group) {
+	g.Go(func() error {
+		listener, err := net.Listen("tcp", w.config.Addr)
+		if err != nil {
+			return fmt.Errorf("cannot setup webhook listener: %w", err)
+		}
+		w.runHTTP(ctx, listener)
+		return nil
+	})
+}
+
+func (w *Webhook) runHTTP(ctx context.Context, listener net.Listener) {
+	path := w.config.Path
+	if path == "" {
+		path = defaultWebhookPath
+	}
+
+	mux := http.NewServeMux()
+	server := &http.Server{
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		Handler:      mux,
+		ErrorLog:     zap.NewStdLog(w.logger.Desugar()),
+	}
+	mux.HandleFunc(path, w.handle)
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	w.logger.Infow("webhook server started", "addr", listener.Addr().String(), "path", path)
+	err := server.Serve(listener)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		w.logger.Errorw("failed to start webhook server", "error", err)
+	}
+}
+
+func (w *Webhook) handle(rw http.ResponseWriter, r *http.Request) {
+	payload, err := github.ValidatePayload(r, []byte(w.config.Secret))
+	if err != nil {
+		w.logger.Debugw("invalid webhook payload", "error", err)
+		rw.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	eventType := github.WebHookType(r)
+	w.logger.Debugw("received webhook",
+		"type", eventType,
+		"delivery", github.DeliveryID(r),
+	)
+
+	var update *MonitorMsgRemoteUpdate
+	var completed *MonitorMsgJobCompleted
+	switch eventType {
+	case "workflow_job":
+		var event github.WorkflowJobEvent
+		if err = json.Unmarshal(payload, &event); err != nil {
+			break
+		}
+		update = w.workflowJobUpdate(&event)
+		completed = w.workflowJobCompleted(&event)
+
+	case "self_hosted_runner":
+		var event selfHostedRunnerEvent
+		if err = json.Unmarshal(payload, &event); err != nil {
+			break
+		}
+		update = w.selfHostedRunnerUpdate(&event)
+	}
+	if err != nil {
+		w.logger.Debugw("malformed webhook payload", "type", eventType, "error", err)
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if update != nil {
+		if err := w.monitor.PostContext(r.Context(), *update); err != nil {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+	}
+	if completed != nil {
+		if err := w.monitor.PostContext(r.Context(), *completed); err != nil {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (w *Webhook) workflowJobUpdate(event *github.WorkflowJobEvent) *MonitorMsgRemoteUpdate {
+	job := event.GetWorkflowJob()
+	if event.GetAction() != "in_progress" || job.GetRunnerID() == 0 || job.GetRunnerName() == "" {
+		return nil
+	}
+
+	// A runner picking up a job must be online.
+	return &MonitorMsgRemoteUpdate{
+		Runner: RemoteRunner{
+			ID:       job.GetRunnerID(),
+			Name:     job.GetRunnerName(),
+			IsOnline: true,
+			IsBusy:   true,
+		},
+		Time: time.Now(),
+	}
+}
+
+func (w *Webhook) workflowJobCompleted(event *github.WorkflowJobEvent) *MonitorMsgJobCompleted {
+	job := event.GetWorkflowJob()
+	if event.GetAction() != "completed" || job.GetRunnerName() == "" {
+		return nil
+	}
+
+	return &MonitorMsgJobCompleted{
+		RunnerName: job.GetRunnerName(),
+		Conclusion: job.GetConclusion(),
+	}
+}
+
+func (w *Webhook) selfHostedRunnerUpdate(event *selfHostedRunnerEvent) *MonitorMsgRemoteUpdate {
+	runner := event.Runner
+	if runner == nil || runner.GetID() == 0 || runner.GetName() == "" {
+		return nil
+	}
+
+	update := &MonitorMsgRemoteUpdate{
+		Runner: RemoteRunner{
+			ID:       runner.GetID(),
+			Name:     runner.GetName(),
+			IsOnline: runner.GetStatus() == "online",
+			IsBusy:   runner.GetBusy(),
+			Labels:   runnerLabels(runner.Labels),
+		},
+		Time: time.Now(),
+	}
+	switch event.Action {
+	case "online":
+		update.Runner.IsOnline = true
+	case "offline":
+		update.Runner.IsOnline = false
+	case "deleted", "removed":
+		update.Deleted = true
+	}
+	return update
+}
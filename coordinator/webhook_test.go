@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func newTestWebhook(secret string) (*Webhook, *Monitor) {
+	monitor := &Monitor{messages: make(chan any, 10)}
+	return NewWebhook(zap.NewNop().Sugar(), &WebhookConfig{Secret: secret}, monitor), monitor
+}
+
+// postWebhook posts the event to webhook, signed with secret if not empty.
+func postWebhook(webhook *Webhook, eventType string, payload string, secret string) int {
+	req := httptest.NewRequest("POST", defaultWebhookPath, strings.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-GitHub-Event", eventType)
+	if secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(payload))
+		req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	rw := httptest.NewRecorder()
+	webhook.handle(rw, req)
+	return rw.Code
+}
+
+func takeMessages(monitor *Monitor) []any {
+	var messages []any
+	for {
+		select {
+		case msg := <-monitor.messages:
+			messages = append(messages, msg)
+		default:
+			return messages
+		}
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	payload := `{"action":"online","runner":{"id":1,"name":"runner-1","status":"online"}}`
+	cases := []struct {
+		name      string
+		secret    string
+		signature string
+		status    int
+	}{
+		{"signed", "secret", "secret", http.StatusNoContent},
+		{"unsigned", "secret", "", http.StatusUnauthorized},
+		{"signed with other secret", "secret", "other", http.StatusUnauthorized},
+		{"unsigned without secret", "", "", http.StatusNoContent},
+	}
+	for _, c := range cases {
+		webhook, monitor := newTestWebhook(c.secret)
+		if status := postWebhook(webhook, "self_hosted_runner", payload, c.signature); status != c.status {
+			t.Errorf("%s: unexpected status %d", c.name, status)
+		}
+		messages := takeMessages(monitor)
+		if c.status == http.StatusNoContent && len(messages) != 1 {
+			t.Errorf("%s: unexpected messages: %+v", c.name, messages)
+		} else if c.status != http.StatusNoContent && len(messages) != 0 {
+			t.Errorf("%s: unexpected messages of rejected payload: %+v", c.name, messages)
+		}
+	}
+}
+
+func TestWebhookEvents(t *testing.T) {
+	webhook, monitor := newTestWebhook("secret")
+
+	// describe formats monitor messages without time.
+	describe := func(messages []any) string {
+		var descs []string
+		for _, msg := range messages {
+			switch msg := msg.(type) {
+			case MonitorMsgRemoteUpdate:
+				r := msg.Runner
+				descs = append(descs, fmt.Sprintf("update %d %s online=%v busy=%v labels=%v deleted=%v",
+					r.ID, r.Name, r.IsOnline, r.IsBusy, r.Labels, msg.Deleted))
+			case MonitorMsgJobCompleted:
+				descs = append(descs, fmt.Sprintf("completed %s %s", msg.RunnerName, msg.Conclusion))
+			default:
+				descs = append(descs, fmt.Sprintf("%T", msg))
+			}
+		}
+		return strings.Join(descs, "; ")
+	}
+
+	cases := []struct {
+		name      string
+		eventType string
+		payload   string
+		status    int
+		messages  string
+	}{
+		{
+			"job queued", "workflow_job",
+			`{"action":"queued","workflow_job":{"id":1,"status":"queued"}}`,
+			http.StatusNoContent, "",
+		},
+		{
+			"job in progress", "workflow_job",
+			`{"action":"in_progress","workflow_job":{"id":1,"runner_id":2,"runner_name":"runner-2"}}`,
+			http.StatusNoContent, "update 2 runner-2 online=true busy=true labels=[] deleted=false",
+		},
+		{
+			"job in progress without runner", "workflow_job",
+			`{"action":"in_progress","workflow_job":{"id":1}}`,
+			http.StatusNoContent, "",
+		},
+		{
+			"job completed", "workflow_job",
+			`{"action":"completed","workflow_job":{"id":1,"runner_id":2,"runner_name":"runner-2","conclusion":"failure"}}`,
+			http.StatusNoContent, "completed runner-2 failure",
+		},
+		{
+			"job cancelled before assigned", "workflow_job",
+			`{"action":"completed","workflow_job":{"id":1,"conclusion":"cancelled"}}`,
+			http.StatusNoContent, "",
+		},
+		{
+			"runner online", "self_hosted_runner",
+			`{"action":"online","runner":{"id":2,"name":"runner-2","status":"offline","busy":false,"labels":[{"name":"self-hosted"},{"name":"xcode"}]}}`,
+			http.StatusNoContent, "update 2 runner-2 online=true busy=false labels=[self-hosted xcode] deleted=false",
+		},
+		{
+			"runner offline", "self_hosted_runner",
+			`{"action":"offline","runner":{"id":2,"name":"runner-2","status":"online","busy":true}}`,
+			http.StatusNoContent, "update 2 runner-2 online=false busy=true labels=[] deleted=false",
+		},
+		{
+			"runner removed", "self_hosted_runner",
+			`{"action":"removed","runner":{"id":2,"name":"runner-2","status":"offline"}}`,
+			http.StatusNoContent, "update 2 runner-2 online=false busy=false labels=[] deleted=true",
+		},
+		{
+			"runner without ID", "self_hosted_runner",
+			`{"action":"online","runner":{"name":"runner-2"}}`,
+			http.StatusNoContent, "",
+		},
+		{
+			"other event", "push",
+			`{"ref":"refs/heads/main"}`,
+			http.StatusNoContent, "",
+		},
+		{
+			"malformed event", "workflow_job",
+			`{"action":1}`,
+			http.StatusBadRequest, "",
+		},
+	}
+	for _, c := range cases {
+		if status := postWebhook(webhook, c.eventType, c.payload, "secret"); status != c.status {
+			t.Errorf("%s: unexpected status %d", c.name, status)
+		}
+		if messages := describe(takeMessages(monitor)); messages != c.messages {
+			t.Errorf("%s: unexpected messages: %q", c.name, messages)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package coordinatorclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type BootstrapMessage struct {
+	Version    int      `json:"version"`
+	ServerURLs []string `json:"serverURLs"`
+	Token      string   `json:"token"`
+	// TokenExpiresAt is the expiry of token, renewed through the server
+	// before then; zero if token does not expire.
+	TokenExpiresAt time.Time `json:"tokenExpiresAt"`
+	CACert         string    `json:"caCert,omitempty"`
+
+	Runner      BootstrapRunner      `json:"runner"`
+	Env         map[string]string    `json:"env,omitempty"`
+	Coordinator BootstrapCoordinator `json:"coordinator"`
+}
+
+type BootstrapRunner struct {
+	GitHubURL string   `json:"gitHubURL"`
+	Group     string   `json:"group,omitempty"`
+	Labels    []string `json:"labels,omitempty"`
+}
+
+type BootstrapCoordinator struct {
+	HostName   string `json:"hostName"`
+	InstanceID uint32 `json:"instanceID"`
+	Slot       int    `json:"slot"`
+}
+
+// Encode encodes the message as a single line, so that guest can read it
+// line-by-line.
+func (m *BootstrapMessage) Encode() (string, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	return string(data) + "\n", nil
+}
+
+// EncodeLegacy encodes the message as legacy "<serverURL> <token>" line.
+// Other fields are not sent to legacy guests.
+func (m *BootstrapMessage) EncodeLegacy() string {
+	return fmt.Sprintf("%s %s\n", m.ServerURLs[0], m.Token)
+}
+
+// ParseBootstrapMessage parses a bootstrap message line. Both JSON and
+// legacy "<serverURL> <token>" lines are accepted.
+func ParseBootstrapMessage(line string) (*BootstrapMessage, error) {
+	if strings.HasPrefix(line, "{") {
+		var msg BootstrapMessage
+		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			return nil, fmt.Errorf("malformed bootstrap message: %w", err)
+		}
+		if len(msg.ServerURLs) == 0 || msg.Token == "" {
+			return nil, errors.New("incomplete bootstrap message")
+		}
+		return &msg, nil
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) != 2 {
+		return nil, errors.New("malformed legacy bootstrap message")
+	}
+	return &BootstrapMessage{
+		Version:    0,
+		ServerURLs: []string{fields[0]},
+		Token:      fields[1],
+	}, nil
+}
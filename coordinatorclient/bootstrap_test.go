@@ -0,0 +1,44 @@
+package coordinatorclient
+
+import "testing"
+
+func TestBootstrapMessageLegacy(t *testing.T) {
+	msg := &BootstrapMessage{
+		Version:    ProtocolVersion,
+		ServerURLs: []string{"https://192.168.64.1:8080", "https://10.0.0.1:8080"},
+		Token:      "token",
+		CACert:     "-----BEGIN CERTIFICATE-----\n...\n-----END CERTIFICATE-----\n",
+		Env:        map[string]string{"KEY": "value"},
+	}
+
+	line := msg.EncodeLegacy()
+	if line != "https://192.168.64.1:8080 token\n" {
+		t.Fatalf("unexpected legacy line: %q", line)
+	}
+
+	parsed, err := ParseBootstrapMessage(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Version != 0 || len(parsed.ServerURLs) != 1 || parsed.ServerURLs[0] != msg.ServerURLs[0] || parsed.Token != msg.Token {
+		t.Errorf("unexpected parsed message: %+v", parsed)
+	}
+
+	for _, line := range []string{"", "https://192.168.64.1:8080", "https://192.168.64.1:8080 token extra"} {
+		if _, err := ParseBootstrapMessage(line); err == nil {
+			t.Errorf("%q: expected error", line)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	version, capabilities := Negotiate(2, []string{"future", CapabilityWait}, ProtocolVersion, Capabilities)
+	if version != ProtocolVersion || len(capabilities) != 1 || !HasCapability(capabilities, CapabilityWait) {
+		t.Errorf("unexpected result: %d %v", version, capabilities)
+	}
+
+	version, capabilities = Negotiate(0, nil, ProtocolVersion, Capabilities)
+	if version != 0 || HasCapability(capabilities, CapabilityWait) {
+		t.Errorf("unexpected result for legacy guest: %d %v", version, capabilities)
+	}
+}
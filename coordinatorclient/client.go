@@ -0,0 +1,289 @@
+package coordinatorclient
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const requestTimeout time.Duration = 10 * time.Second
+
+// Backoff configures retries of failed requests.
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+	Factor  float64
+	// Attempts is the maximum number of attempts; 0 means retry until
+	// context is done.
+	Attempts int
+}
+
+var DefaultBackoff = Backoff{
+	Initial:  1 * time.Second,
+	Max:      30 * time.Second,
+	Factor:   2,
+	Attempts: 0,
+}
+
+// StatusError is returned when the server responds with unexpected status.
+type StatusError struct {
+	Path       string
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: unexpected status %d: %s", e.Path, e.StatusCode, e.Message)
+}
+
+// Temporary reports whether the request may succeed if retried.
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+}
+
+// Client talks to the coordinator guest API. Expiring token is renewed
+// halfway through its remaining lifetime.
+type Client struct {
+	ServerURLs []string
+	HTTPClient *http.Client
+	Backoff    Backoff
+
+	lock    *sync.Mutex
+	token   string
+	renewAt time.Time
+	// serverURL is the index of server URL in use; requests may be sent
+	// concurrently, e.g. long-polling while updating.
+	serverURL int
+}
+
+func NewClient(serverURLs []string, token string, caCertPEM string) (*Client, error) {
+	if len(serverURLs) == 0 {
+		return nil, errors.New("no server URL")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if caCertPEM != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(caCertPEM)) {
+			return nil, errors.New("invalid CA certificate")
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+
+	return &Client{
+		ServerURLs: serverURLs,
+		HTTPClient: &http.Client{Transport: transport},
+		Backoff:    DefaultBackoff,
+		lock:       new(sync.Mutex),
+		token:      token,
+	}, nil
+}
+
+func NewClientFromBootstrap(msg *BootstrapMessage) (*Client, error) {
+	client, err := NewClient(msg.ServerURLs, msg.Token, msg.CACert)
+	if err != nil {
+		return nil, err
+	}
+	client.SetToken(msg.Token, msg.TokenExpiresAt)
+	return client, nil
+}
+
+// SetToken sets the token, expiring at expiresAt; zero if the token does not
+// expire.
+func (c *Client) SetToken(token string, expiresAt time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.token = token
+	c.renewAt = time.Time{}
+	if !expiresAt.IsZero() {
+		c.renewAt = time.Now().Add(time.Until(expiresAt) / 2)
+	}
+}
+
+// Renew renews the token.
+func (c *Client) Renew(ctx context.Context) error {
+	var resp RenewResponse
+	if _, err := c.postWithRetry(ctx, PathRenew, nil, &resp); err != nil {
+		return err
+	}
+	c.SetToken(resp.Token, resp.ExpiresAt)
+	return nil
+}
+
+// currentToken returns the token, and whether it should be renewed.
+func (c *Client) currentToken() (string, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.token, !c.renewAt.IsZero() && !time.Now().Before(c.renewAt)
+}
+
+func (c *Client) Register(ctx context.Context, req *RegisterRequest) (*RegisterResponse, error) {
+	var resp RegisterResponse
+	if _, err := c.postWithRetry(ctx, PathRegister, req.Encode(), &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) Update(ctx context.Context, req *UpdateRequest) error {
+	_, err := c.postWithRetry(ctx, PathUpdate, req.Encode(), nil)
+	return err
+}
+
+// Wait long-polls the coordinator once. Returns true if the guest should
+// stop, or false if the poll timed out.
+func (c *Client) Wait(ctx context.Context) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, WaitTimeout+requestTimeout)
+	defer cancel()
+
+	body, err := c.post(ctx, PathWait, nil)
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusRequestTimeout {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(string(body)) == WaitStop, nil
+}
+
+// WaitStop long-polls the coordinator until the guest is requested to stop,
+// retrying with backoff on failures. Returns error if context is done or
+// retries are exhausted.
+func (c *Client) WaitStop(ctx context.Context) error {
+	attempt := 0
+	for {
+		stop, err := c.Wait(ctx)
+		if err == nil {
+			attempt = 0
+			if stop {
+				return nil
+			}
+			continue
+		}
+
+		attempt++
+		if !c.shouldRetry(err, attempt) {
+			return err
+		}
+		if err := c.sleep(ctx, attempt); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *Client) postWithRetry(ctx context.Context, path string, form url.Values, result any) ([]byte, error) {
+	attempt := 0
+	for {
+		reqCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+		body, err := c.post(reqCtx, path, form)
+		cancel()
+		if err == nil {
+			if result != nil {
+				if err := json.Unmarshal(body, result); err != nil {
+					return nil, fmt.Errorf("%s: malformed response: %w", path, err)
+				}
+			}
+			return body, nil
+		}
+
+		attempt++
+		if !c.shouldRetry(err, attempt) {
+			return nil, err
+		}
+		if err := c.sleep(ctx, attempt); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (c *Client) post(ctx context.Context, path string, form url.Values) ([]byte, error) {
+	token, renew := c.currentToken()
+	if renew && path != PathRenew {
+		if err := c.Renew(ctx); err != nil {
+			return nil, fmt.Errorf("cannot renew token: %w", err)
+		}
+		token, _ = c.currentToken()
+	}
+
+	index, serverURL := c.currentServerURL()
+	req, err := http.NewRequestWithContext(ctx, "POST", serverURL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		// Try next server URL on connection failure.
+		c.nextServerURL(index)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &StatusError{Path: path, StatusCode: resp.StatusCode, Message: string(body)}
+	}
+	return body, nil
+}
+
+func (c *Client) currentServerURL() (int, string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.serverURL, c.ServerURLs[c.serverURL]
+}
+
+// nextServerURL switches from the failed server URL to next one; concurrent
+// failures of the same server URL switch once only.
+func (c *Client) nextServerURL(failed int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.serverURL == failed {
+		c.serverURL = (c.serverURL + 1) % len(c.ServerURLs)
+	}
+}
+
+func (c *Client) shouldRetry(err error, attempt int) bool {
+	if c.Backoff.Attempts > 0 && attempt >= c.Backoff.Attempts {
+		return false
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Temporary()
+	}
+	return true
+}
+
+func (c *Client) sleep(ctx context.Context, attempt int) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(c.Backoff.delay(attempt)):
+		return nil
+	}
+}
+
+// delay returns the delay before retrying failed attempt.
+func (b Backoff) delay(attempt int) time.Duration {
+	delay := b.Initial
+	for i := 1; i < attempt && delay < b.Max; i++ {
+		delay = time.Duration(float64(delay) * b.Factor)
+	}
+	if delay > b.Max {
+		delay = b.Max
+	}
+	return delay
+}
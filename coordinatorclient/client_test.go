@@ -0,0 +1,257 @@
+package coordinatorclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testServer is a stand-in coordinator guest API.
+type testServer struct {
+	server *httptest.Server
+
+	lock     *sync.Mutex
+	tokens   map[string]bool
+	requests []string
+	// statuses are statuses of next responses, before handling requests.
+	statuses []int
+}
+
+func newTestServer(t *testing.T) *testServer {
+	s := &testServer{lock: new(sync.Mutex), tokens: map[string]bool{"token-0": true}}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+func (s *testServer) serve(rw http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	s.requests = append(s.requests, r.URL.Path+" "+token)
+	valid := s.tokens[token]
+	status := http.StatusOK
+	if len(s.statuses) > 0 {
+		status, s.statuses = s.statuses[0], s.statuses[1:]
+	}
+	s.lock.Unlock()
+
+	if status != http.StatusOK {
+		http.Error(rw, http.StatusText(status), status)
+		return
+	}
+	if !valid {
+		http.Error(rw, "invalid token", http.StatusBadRequest)
+		return
+	}
+
+	switch r.URL.Path {
+	case PathRenew:
+		s.lock.Lock()
+		renewed := fmt.Sprintf("token-%d", len(s.tokens))
+		s.tokens[renewed] = true
+		s.lock.Unlock()
+		json.NewEncoder(rw).Encode(RenewResponse{Token: renewed, ExpiresAt: time.Now().Add(time.Hour)})
+	case PathRegister:
+		json.NewEncoder(rw).Encode(RegisterResponse{Name: r.FormValue("name")})
+	case PathWait:
+		rw.Write([]byte(WaitStop))
+	default:
+		rw.WriteHeader(http.StatusOK)
+	}
+}
+
+func (s *testServer) respond(statuses ...int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.statuses = statuses
+}
+
+func (s *testServer) takeRequests() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	requests := s.requests
+	s.requests = nil
+	return requests
+}
+
+func newTestClient(t *testing.T, serverURLs ...string) *Client {
+	client, err := NewClient(serverURLs, "token-0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Backoff = Backoff{Initial: time.Millisecond, Max: 10 * time.Millisecond, Factor: 2, Attempts: 5}
+	return client
+}
+
+func TestClientRenew(t *testing.T) {
+	server := newTestServer(t)
+	client := newTestClient(t, server.server.URL)
+	ctx := context.Background()
+
+	// Token is not renewed before halfway of its lifetime.
+	client.SetToken("token-0", time.Now().Add(time.Hour))
+	if err := client.Update(ctx, &UpdateRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if requests := server.takeRequests(); strings.Join(requests, ",") != PathUpdate+" token-0" {
+		t.Errorf("unexpected requests: %v", requests)
+	}
+
+	// Token is renewed before next request.
+	client.SetToken("token-0", time.Now())
+	if err := client.Update(ctx, &UpdateRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Update(ctx, &UpdateRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{PathRenew + " token-0", PathUpdate + " token-1", PathUpdate + " token-1"}
+	if requests := server.takeRequests(); strings.Join(requests, ",") != strings.Join(expected, ",") {
+		t.Errorf("unexpected requests: %v", requests)
+	}
+
+	// Token without expiry is never renewed.
+	client.SetToken("token-0", time.Time{})
+	if err := client.Update(ctx, &UpdateRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if requests := server.takeRequests(); len(requests) != 1 {
+		t.Errorf("unexpected requests: %v", requests)
+	}
+}
+
+func TestClientRetry(t *testing.T) {
+	server := newTestServer(t)
+	client := newTestClient(t, server.server.URL)
+	ctx := context.Background()
+
+	// Temporary failures are retried.
+	server.respond(http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusBadGateway)
+	resp, err := client.Register(ctx, &RegisterRequest{Name: "runner"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Name != "runner" {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+	if requests := server.takeRequests(); len(requests) != 4 {
+		t.Errorf("unexpected requests: %v", requests)
+	}
+
+	// Other failures are not retried.
+	server.respond(http.StatusBadRequest)
+	var statusErr *StatusError
+	if err := client.Update(ctx, &UpdateRequest{}); !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if requests := server.takeRequests(); len(requests) != 1 {
+		t.Errorf("unexpected requests: %v", requests)
+	}
+
+	// Retries are limited by attempts.
+	server.respond(503, 503, 503, 503, 503, 503)
+	if err := client.Update(ctx, &UpdateRequest{}); !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if requests := server.takeRequests(); len(requests) != 5 {
+		t.Errorf("unexpected requests: %v", requests)
+	}
+
+	// Retries stop when context is done.
+	server.respond(503, 503, 503, 503, 503, 503)
+	client.Backoff = Backoff{Initial: time.Hour, Max: time.Hour, Factor: 2}
+	ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if err := client.Update(ctx, &UpdateRequest{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	cases := []struct {
+		attempt int
+		delay   time.Duration
+	}{
+		{1, 1 * time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{5, 16 * time.Second},
+		{6, 30 * time.Second},
+		{100, 30 * time.Second},
+	}
+	for _, c := range cases {
+		if delay := DefaultBackoff.delay(c.attempt); delay != c.delay {
+			t.Errorf("attempt %d: got %s, expected %s", c.attempt, delay, c.delay)
+		}
+	}
+}
+
+func TestClientFailover(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	server := newTestServer(t)
+	client := newTestClient(t, down.URL, server.server.URL)
+	ctx := context.Background()
+
+	// Concurrent failures switch to next server URL once only.
+	wg := new(sync.WaitGroup)
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- client.Update(ctx, &UpdateRequest{})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if requests := server.takeRequests(); len(requests) != 10 {
+		t.Errorf("unexpected requests: %v", requests)
+	}
+	if index, _ := client.currentServerURL(); index != 1 {
+		t.Errorf("unexpected server URL: %d", index)
+	}
+}
+
+func TestClientWait(t *testing.T) {
+	server := newTestServer(t)
+	client := newTestClient(t, server.server.URL)
+	ctx := context.Background()
+
+	// Timed out long polls are not errors.
+	server.respond(http.StatusRequestTimeout)
+	if stop, err := client.Wait(ctx); err != nil || stop {
+		t.Errorf("unexpected result: %v, %v", stop, err)
+	}
+	if stop, err := client.Wait(ctx); err != nil || !stop {
+		t.Errorf("unexpected result: %v, %v", stop, err)
+	}
+	server.takeRequests()
+
+	// Long polls continue until requested to stop; failures are retried
+	// without counting timed out polls.
+	server.respond(408, 503, 408, 408, 503, 503, 503, 408)
+	if err := client.WaitStop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if requests := server.takeRequests(); len(requests) != 9 {
+		t.Errorf("unexpected requests: %v", requests)
+	}
+
+	server.respond(503, 503, 503, 503, 503)
+	if err := client.WaitStop(ctx); err == nil {
+		t.Error("expected error when retries are exhausted")
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/oursky/github-ci-support/coordinatorclient
+
+go 1.18
@@ -0,0 +1,151 @@
+package coordinatorclient
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProtocolVersion is the current guest protocol version.
+// Version 0 denotes legacy guests that do not negotiate protocol.
+const ProtocolVersion = 1
+
+const (
+	CapabilityBootstrapJSON = "bootstrap-json"
+	CapabilityWait          = "wait"
+)
+
+// Capabilities are the optional protocol features implemented in this
+// package.
+var Capabilities = []string{CapabilityBootstrapJSON, CapabilityWait}
+
+const (
+	PathRegister = "/register"
+	PathUpdate   = "/update"
+	PathWait     = "/wait"
+	PathRenew    = "/renew"
+
+	// WaitStop is the /wait response body requesting the guest to stop.
+	WaitStop = "stop"
+	// WaitTimeout is the server-side long-polling timeout of /wait.
+	WaitTimeout time.Duration = 60 * time.Second
+)
+
+type RegisterRequest struct {
+	Name            string
+	HostName        string
+	ProtocolVersion int
+	Capabilities    []string
+}
+
+func (r *RegisterRequest) Encode() url.Values {
+	form := url.Values{}
+	form.Set("name", r.Name)
+	form.Set("hostName", r.HostName)
+	form.Set("protocolVersion", strconv.Itoa(r.ProtocolVersion))
+	form.Set("capabilities", strings.Join(r.Capabilities, ","))
+	return form
+}
+
+func DecodeRegisterRequest(form url.Values) *RegisterRequest {
+	req := &RegisterRequest{
+		Name:     form.Get("name"),
+		HostName: form.Get("hostName"),
+	}
+	// Legacy guests do not send protocol version.
+	if version, err := strconv.Atoi(form.Get("protocolVersion")); err == nil && version > 0 {
+		req.ProtocolVersion = version
+	}
+	for _, c := range strings.Split(form.Get("capabilities"), ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			req.Capabilities = append(req.Capabilities, c)
+		}
+	}
+	return req
+}
+
+type RegisterResponse struct {
+	Name      string `json:"name"`
+	GitHubURL string `json:"gitHubURL"`
+	Token     string `json:"token"`
+	Group     string `json:"group"`
+	// Labels is comma-separated list of runner labels.
+	Labels string `json:"labels"`
+
+	ProtocolVersion int      `json:"protocolVersion,omitempty"`
+	Capabilities    []string `json:"capabilities,omitempty"`
+}
+
+// RenewResponse is the renewed token of the guest.
+type RenewResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type UpdateRequest struct {
+	RunnerID *int64
+	// XcodeVersions are the Xcode versions installed in guest; nil if not
+	// reported.
+	XcodeVersions []string
+}
+
+func (r *UpdateRequest) Encode() url.Values {
+	form := url.Values{}
+	if r.RunnerID != nil {
+		form.Set("runnerID", strconv.FormatInt(*r.RunnerID, 10))
+	}
+	if r.XcodeVersions != nil {
+		form.Set("xcodeVersions", strings.Join(r.XcodeVersions, ","))
+	}
+	return form
+}
+
+func DecodeUpdateRequest(form url.Values) (*UpdateRequest, error) {
+	req := &UpdateRequest{}
+	if runnerIDStr := form.Get("runnerID"); runnerIDStr != "" {
+		id, err := strconv.ParseInt(runnerIDStr, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		req.RunnerID = &id
+	}
+	if versions, ok := form["xcodeVersions"]; ok {
+		req.XcodeVersions = []string{}
+		for _, v := range strings.Split(strings.Join(versions, ","), ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				req.XcodeVersions = append(req.XcodeVersions, v)
+			}
+		}
+	}
+	return req, nil
+}
+
+// HasCapability checks whether the capability is in the negotiated
+// capabilities.
+func HasCapability(capabilities []string, capability string) bool {
+	for _, c := range capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// Negotiate determines the protocol version and capabilities supported by
+// both sides.
+func Negotiate(version int, capabilities []string, supportedVersion int, supportedCapabilities []string) (int, []string) {
+	if version > supportedVersion {
+		version = supportedVersion
+	}
+
+	var result []string
+	for _, c := range capabilities {
+		for _, sc := range supportedCapabilities {
+			if c == sc {
+				result = append(result, c)
+			}
+		}
+	}
+	return version, result
+}
@@ -0,0 +1,226 @@
+package githublib
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v45/github"
+)
+
+const jobsPageSize = 100
+
+// ErrRateLimitReserve is returned when listing stops to keep the reserved
+// API quota available.
+var ErrRateLimitReserve = errors.New("rate limit reserve reached")
+
+// ActiveJob is a queued or in-progress workflow job.
+type ActiveJob struct {
+	// Repository is full name of repository, i.e. "<owner>/<name>".
+	Repository string
+	RunID      int64
+	JobID      int64
+	Name       string
+	// Status is "queued" or "in_progress".
+	Status     string
+	Labels     []string
+	RunnerName string
+	StartedAt  time.Time
+}
+
+type cachedResponse struct {
+	etag     string
+	body     json.RawMessage
+	nextPage int
+}
+
+// JobLister lists active workflow jobs. Responses are cached and requested
+// conditionally, so unchanged pages do not count against rate limit.
+type JobLister struct {
+	client *github.Client
+	// MinRateRemaining is the API quota reserved for others; listing stops
+	// with ErrRateLimitReserve when remaining quota falls below it.
+	MinRateRemaining int
+
+	lock  *sync.Mutex
+	cache map[string]*cachedResponse
+	rate  github.Rate
+}
+
+func NewJobLister(client *github.Client) *JobLister {
+	return &JobLister{
+		client: client,
+		lock:   new(sync.Mutex),
+		cache:  make(map[string]*cachedResponse),
+	}
+}
+
+// Rate returns the rate limit observed in last request.
+func (l *JobLister) Rate() github.Rate {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.rate
+}
+
+// ListRepositoryJobs lists active jobs of the repository.
+func (l *JobLister) ListRepositoryJobs(ctx context.Context, owner string, repo string) ([]*ActiveJob, error) {
+	fullName := owner + "/" + repo
+
+	var runIDs []int64
+	for _, status := range []string{"queued", "in_progress"} {
+		for page := 1; page != 0; {
+			var runs github.WorkflowRuns
+			url := fmt.Sprintf("repos/%s/actions/runs?status=%s&page=%d&per_page=%d", fullName, status, page, jobsPageSize)
+			nextPage, err := l.get(ctx, url, &runs)
+			if err != nil {
+				return nil, err
+			}
+			for _, run := range runs.WorkflowRuns {
+				runIDs = append(runIDs, run.GetID())
+			}
+			page = nextPage
+		}
+	}
+
+	var jobs []*ActiveJob
+	for _, runID := range runIDs {
+		for page := 1; page != 0; {
+			var result github.Jobs
+			url := fmt.Sprintf("repos/%s/actions/runs/%d/jobs?filter=latest&page=%d&per_page=%d", fullName, runID, page, jobsPageSize)
+			nextPage, err := l.get(ctx, url, &result)
+			if err != nil {
+				return nil, err
+			}
+			for _, job := range result.Jobs {
+				if job.GetStatus() != "queued" && job.GetStatus() != "in_progress" {
+					continue
+				}
+				jobs = append(jobs, &ActiveJob{
+					Repository: fullName,
+					RunID:      runID,
+					JobID:      job.GetID(),
+					Name:       job.GetName(),
+					Status:     job.GetStatus(),
+					Labels:     job.Labels,
+					RunnerName: job.GetRunnerName(),
+					StartedAt:  job.GetStartedAt().Time,
+				})
+			}
+			page = nextPage
+		}
+	}
+
+	l.prune()
+	return jobs, nil
+}
+
+// ListOrganizationJobs lists active jobs of the repositories in
+// organization; all non-archived repositories if repos is empty.
+func (l *JobLister) ListOrganizationJobs(ctx context.Context, org string, repos []string) ([]*ActiveJob, error) {
+	if len(repos) == 0 {
+		for page := 1; page != 0; {
+			var result []*github.Repository
+			url := fmt.Sprintf("orgs/%s/repos?type=all&sort=pushed&page=%d&per_page=%d", org, page, jobsPageSize)
+			nextPage, err := l.get(ctx, url, &result)
+			if err != nil {
+				return nil, err
+			}
+			for _, repo := range result {
+				if !repo.GetArchived() && !repo.GetDisabled() {
+					repos = append(repos, repo.GetName())
+				}
+			}
+			page = nextPage
+		}
+	}
+
+	var jobs []*ActiveJob
+	for _, repo := range repos {
+		repoJobs, err := l.ListRepositoryJobs(ctx, org, repo)
+		if err != nil {
+			return nil, fmt.Errorf("%s/%s: %w", org, repo, err)
+		}
+		jobs = append(jobs, repoJobs...)
+	}
+	return jobs, nil
+}
+
+func (t *RunnerTargetRepository) ListActiveJobs(ctx context.Context, lister *JobLister, repos []string) ([]*ActiveJob, error) {
+	return lister.ListRepositoryJobs(ctx, t.Owner, t.Name)
+}
+
+func (t *RunnerTargetOrganization) ListActiveJobs(ctx context.Context, lister *JobLister, repos []string) ([]*ActiveJob, error) {
+	return lister.ListOrganizationJobs(ctx, t.Name, repos)
+}
+
+// get fetches the URL conditionally into v, and returns next page.
+func (l *JobLister) get(ctx context.Context, url string, v any) (int, error) {
+	l.lock.Lock()
+	if l.rate.Limit > 0 && l.rate.Remaining < l.MinRateRemaining && time.Now().Before(l.rate.Reset.Time) {
+		l.lock.Unlock()
+		return 0, ErrRateLimitReserve
+	}
+	cached := l.cache[url]
+	l.lock.Unlock()
+
+	req, err := l.client.NewRequest("GET", url, nil)
+	if err != nil {
+		return 0, err
+	}
+	if cached != nil {
+		req.Header.Set("If-None-Match", cached.etag)
+	}
+
+	var body json.RawMessage
+	resp, err := l.client.Do(ctx, req, &body)
+	if resp != nil {
+		l.lock.Lock()
+		l.rate = resp.Rate
+		l.lock.Unlock()
+	}
+
+	// Not modified responses may omit pagination links, so next page is
+	// cached with body.
+	nextPage := 0
+	var errResp *github.ErrorResponse
+	if cached != nil && errors.As(err, &errResp) && errResp.Response.StatusCode == http.StatusNotModified {
+		body = cached.body
+		nextPage = cached.nextPage
+	} else if err != nil {
+		return 0, err
+	} else {
+		nextPage = resp.NextPage
+		if etag := resp.Header.Get("ETag"); etag != "" {
+			l.lock.Lock()
+			l.cache[url] = &cachedResponse{etag: etag, body: body, nextPage: nextPage}
+			l.lock.Unlock()
+		}
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		return 0, err
+	}
+	return nextPage, nil
+}
+
+// prune limits cache size; cached responses of finished runs are not
+// requested again.
+func (l *JobLister) prune() {
+	const maxCacheSize = 10000
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if len(l.cache) <= maxCacheSize {
+		return
+	}
+	for url := range l.cache {
+		delete(l.cache, url)
+		if len(l.cache) <= maxCacheSize/2 {
+			return
+		}
+	}
+}
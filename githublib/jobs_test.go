@@ -0,0 +1,225 @@
+package githublib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v45/github"
+)
+
+// newTestClient returns a GitHub client sending requests to the handler.
+func newTestClient(t *testing.T, handler http.Handler) *github.Client {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+	return client
+}
+
+// jobsServer serves workflow runs and jobs of repository "o/r". Responses
+// have ETag of their page, so unchanged pages are not modified.
+type jobsServer struct {
+	lock *sync.Mutex
+	// runs are IDs of runs by status; served one run per page.
+	runs map[string][]int64
+	// jobs are statuses of jobs by run.
+	jobs          map[int64][]string
+	rateRemaining int
+	rateReset     time.Time
+
+	requests    int
+	notModified int
+}
+
+func newJobsServer() *jobsServer {
+	return &jobsServer{
+		lock: new(sync.Mutex),
+		runs: map[string][]int64{
+			"queued":      {1, 2},
+			"in_progress": {3},
+		},
+		jobs: map[int64][]string{
+			1: {"queued"},
+			2: {"queued", "completed"},
+			3: {"in_progress"},
+		},
+		rateRemaining: 5000,
+		rateReset:     time.Now().Add(time.Hour),
+	}
+}
+
+func (s *jobsServer) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.requests++
+
+	rw.Header().Set("X-RateLimit-Limit", "5000")
+	rw.Header().Set("X-RateLimit-Remaining", strconv.Itoa(s.rateRemaining))
+	rw.Header().Set("X-RateLimit-Reset", strconv.FormatInt(s.rateReset.Unix(), 10))
+
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	var body string
+	nextPage := 0
+	var runID int64
+	switch _, err := fmt.Sscanf(r.URL.Path, "/repos/o/r/actions/runs/%d/jobs", &runID); {
+	case err == nil:
+		jobs := ""
+		for i, status := range s.jobs[runID] {
+			if i > 0 {
+				jobs += ","
+			}
+			jobs += fmt.Sprintf(`{"id":%d,"status":%q,"labels":["self-hosted"]}`, runID*10+int64(i), status)
+		}
+		body = fmt.Sprintf(`{"total_count":%d,"jobs":[%s]}`, len(s.jobs[runID]), jobs)
+
+	case r.URL.Path == "/repos/o/r/actions/runs":
+		runs := s.runs[r.URL.Query().Get("status")]
+		if page < 1 || page > len(runs) {
+			body = `{"total_count":0,"workflow_runs":[]}`
+			break
+		}
+		body = fmt.Sprintf(`{"total_count":%d,"workflow_runs":[{"id":%d}]}`, len(runs), runs[page-1])
+		if page < len(runs) {
+			nextPage = page + 1
+		}
+
+	default:
+		http.NotFound(rw, r)
+		return
+	}
+
+	etag := fmt.Sprintf(`"%x"`, body)
+	rw.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		// GitHub omits pagination links in not modified responses.
+		s.notModified++
+		rw.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if nextPage != 0 {
+		next := *r.URL
+		query := next.Query()
+		query.Set("page", strconv.Itoa(nextPage))
+		next.RawQuery = query.Encode()
+		rw.Header().Set("Link", fmt.Sprintf(`<http://%s%s>; rel="next"`, r.Host, next.RequestURI()))
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Write([]byte(body))
+}
+
+func (s *jobsServer) takeCounts() (requests int, notModified int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	requests, notModified = s.requests, s.notModified
+	s.requests, s.notModified = 0, 0
+	return
+}
+
+func jobIDs(jobs []*ActiveJob) []int64 {
+	var ids []int64
+	for _, job := range jobs {
+		ids = append(ids, job.JobID)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func TestJobListerCache(t *testing.T) {
+	server := newJobsServer()
+	lister := NewJobLister(newTestClient(t, server))
+	ctx := context.Background()
+
+	// 2 pages of queued runs, 1 page of in-progress runs, and 3 pages of jobs.
+	jobs, err := lister.ListRepositoryJobs(ctx, "o", "r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := jobIDs(jobs); fmt.Sprint(ids) != "[10 20 30]" {
+		t.Errorf("unexpected jobs: %v", ids)
+	}
+	if jobs[0].Repository != "o/r" {
+		t.Errorf("unexpected repository: %s", jobs[0].Repository)
+	}
+	if requests, notModified := server.takeCounts(); requests != 6 || notModified != 0 {
+		t.Errorf("unexpected requests: %d, not modified %d", requests, notModified)
+	}
+	if rate := lister.Rate(); rate.Remaining != 5000 {
+		t.Errorf("unexpected rate: %+v", rate)
+	}
+
+	// Unchanged pages are not modified, and second page of queued runs is
+	// still listed without pagination links.
+	jobs, err = lister.ListRepositoryJobs(ctx, "o", "r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := jobIDs(jobs); fmt.Sprint(ids) != "[10 20 30]" {
+		t.Errorf("unexpected cached jobs: %v", ids)
+	}
+	if requests, notModified := server.takeCounts(); requests != 6 || notModified != 6 {
+		t.Errorf("unexpected requests: %d, not modified %d", requests, notModified)
+	}
+
+	// Changed pages are fetched again.
+	server.lock.Lock()
+	server.jobs[3] = []string{"completed"}
+	server.lock.Unlock()
+	jobs, err = lister.ListRepositoryJobs(ctx, "o", "r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := jobIDs(jobs); fmt.Sprint(ids) != "[10 20]" {
+		t.Errorf("unexpected updated jobs: %v", ids)
+	}
+	if requests, notModified := server.takeCounts(); requests != 6 || notModified != 5 {
+		t.Errorf("unexpected requests: %d, not modified %d", requests, notModified)
+	}
+}
+
+func TestJobListerRateLimitReserve(t *testing.T) {
+	server := newJobsServer()
+	server.rateRemaining = 100
+	lister := NewJobLister(newTestClient(t, server))
+	lister.MinRateRemaining = 500
+	ctx := context.Background()
+
+	// Listing stops after the first response reveals quota below reserve.
+	_, err := lister.ListRepositoryJobs(ctx, "o", "r")
+	if !errors.Is(err, ErrRateLimitReserve) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if requests, _ := server.takeCounts(); requests != 1 {
+		t.Errorf("unexpected requests: %d", requests)
+	}
+	_, err = lister.ListOrganizationJobs(ctx, "o", []string{"r"})
+	if !errors.Is(err, ErrRateLimitReserve) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if requests, _ := server.takeCounts(); requests != 0 {
+		t.Errorf("unexpected requests: %d", requests)
+	}
+
+	// Reserve no longer applies once quota is reset.
+	server.lock.Lock()
+	server.rateReset = time.Now().Add(-time.Minute)
+	server.lock.Unlock()
+	lister.lock.Lock()
+	lister.rate.Reset = github.Timestamp{Time: time.Now().Add(-time.Minute)}
+	lister.lock.Unlock()
+	if _, err := lister.ListRepositoryJobs(ctx, "o", "r"); err != nil {
+		t.Fatal(err)
+	}
+	if requests, _ := server.takeCounts(); requests != 6 {
+		t.Errorf("unexpected requests: %d", requests)
+	}
+}
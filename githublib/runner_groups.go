@@ -0,0 +1,104 @@
+package githublib
+
+import (
+	"context"
+
+	"github.com/google/go-github/v45/github"
+)
+
+const (
+	RunnerGroupVisibilityAll      = "all"
+	RunnerGroupVisibilitySelected = "selected"
+	RunnerGroupVisibilityPrivate  = "private"
+)
+
+// RunnerGroupTarget is a runner target supporting runner groups; only
+// organizations have runner groups.
+type RunnerGroupTarget interface {
+	RunnerTarget
+	ListRunnerGroups(ctx context.Context, client *github.Client) ([]*github.RunnerGroup, error)
+	CreateRunnerGroup(ctx context.Context, client *github.Client, name string, visibility string, repoIDs []int64) (*github.RunnerGroup, error)
+	SetRunnerGroupVisibility(ctx context.Context, client *github.Client, groupID int64, visibility string) error
+	ListRunnerGroupRepositories(ctx context.Context, client *github.Client, groupID int64) ([]*github.Repository, error)
+	SetRunnerGroupRepositories(ctx context.Context, client *github.Client, groupID int64, repoIDs []int64) error
+	GetRepositoryID(ctx context.Context, client *github.Client, name string) (int64, error)
+}
+
+func (t *RunnerTargetOrganization) ListRunnerGroups(
+	ctx context.Context, client *github.Client,
+) ([]*github.RunnerGroup, error) {
+	var groups []*github.RunnerGroup
+	opts := &github.ListOrgRunnerGroupOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		result, resp, err := client.Actions.ListOrganizationRunnerGroups(ctx, t.Name, opts)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, result.RunnerGroups...)
+		if resp.NextPage == 0 {
+			return groups, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+func (t *RunnerTargetOrganization) CreateRunnerGroup(
+	ctx context.Context, client *github.Client, name string, visibility string, repoIDs []int64,
+) (*github.RunnerGroup, error) {
+	group, _, err := client.Actions.CreateOrganizationRunnerGroup(ctx, t.Name, github.CreateRunnerGroupRequest{
+		Name:                  github.String(name),
+		Visibility:            github.String(visibility),
+		SelectedRepositoryIDs: repoIDs,
+	})
+	return group, err
+}
+
+func (t *RunnerTargetOrganization) SetRunnerGroupVisibility(
+	ctx context.Context, client *github.Client, groupID int64, visibility string,
+) error {
+	_, _, err := client.Actions.UpdateOrganizationRunnerGroup(ctx, t.Name, groupID, github.UpdateRunnerGroupRequest{
+		Visibility: github.String(visibility),
+	})
+	return err
+}
+
+func (t *RunnerTargetOrganization) ListRunnerGroupRepositories(
+	ctx context.Context, client *github.Client, groupID int64,
+) ([]*github.Repository, error) {
+	var repos []*github.Repository
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		result, resp, err := client.Actions.ListRepositoryAccessRunnerGroup(ctx, t.Name, groupID, opts)
+		if err != nil {
+			return nil, err
+		}
+		repos = append(repos, result.Repositories...)
+		if resp.NextPage == 0 {
+			return repos, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+func (t *RunnerTargetOrganization) SetRunnerGroupRepositories(
+	ctx context.Context, client *github.Client, groupID int64, repoIDs []int64,
+) error {
+	if repoIDs == nil {
+		repoIDs = []int64{}
+	}
+	_, err := client.Actions.SetRepositoryAccessRunnerGroup(ctx, t.Name, groupID, github.SetRepoAccessRunnerGroupRequest{
+		SelectedRepositoryIDs: repoIDs,
+	})
+	return err
+}
+
+// GetRepositoryID returns ID of the named repository in organization.
+func (t *RunnerTargetOrganization) GetRepositoryID(
+	ctx context.Context, client *github.Client, name string,
+) (int64, error) {
+	repo, _, err := client.Repositories.Get(ctx, t.Name, name)
+	if err != nil {
+		return 0, err
+	}
+	return repo.GetID(), nil
+}
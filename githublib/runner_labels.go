@@ -0,0 +1,54 @@
+package githublib
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/google/go-github/v45/github"
+)
+
+type runnerLabels struct {
+	TotalCount int                    `json:"total_count"`
+	Labels     []*github.RunnerLabels `json:"labels"`
+}
+
+type runnerLabelsRequest struct {
+	Labels []string `json:"labels"`
+}
+
+// doRunnerLabels performs a request on labels of the runner at path, and
+// returns all labels of the runner after the request.
+func doRunnerLabels(
+	ctx context.Context, client *github.Client, method string, path string, body any,
+) ([]*github.RunnerLabels, error) {
+	req, err := client.NewRequest(method, path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := &runnerLabels{}
+	if _, err := client.Do(ctx, req, labels); err != nil {
+		return nil, err
+	}
+	return labels.Labels, nil
+}
+
+func listRunnerLabels(ctx context.Context, client *github.Client, runnersPath string, id int64) ([]*github.RunnerLabels, error) {
+	return doRunnerLabels(ctx, client, "GET", fmt.Sprintf("%s/%d/labels", runnersPath, id), nil)
+}
+
+func addRunnerLabels(ctx context.Context, client *github.Client, runnersPath string, id int64, labels []string) ([]*github.RunnerLabels, error) {
+	return doRunnerLabels(ctx, client, "POST", fmt.Sprintf("%s/%d/labels", runnersPath, id), &runnerLabelsRequest{Labels: labels})
+}
+
+func setRunnerLabels(ctx context.Context, client *github.Client, runnersPath string, id int64, labels []string) ([]*github.RunnerLabels, error) {
+	if labels == nil {
+		labels = []string{}
+	}
+	return doRunnerLabels(ctx, client, "PUT", fmt.Sprintf("%s/%d/labels", runnersPath, id), &runnerLabelsRequest{Labels: labels})
+}
+
+func removeRunnerLabel(ctx context.Context, client *github.Client, runnersPath string, id int64, label string) ([]*github.RunnerLabels, error) {
+	return doRunnerLabels(ctx, client, "DELETE", fmt.Sprintf("%s/%d/labels/%s", runnersPath, id, url.PathEscape(label)), nil)
+}
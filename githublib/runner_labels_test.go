@@ -0,0 +1,96 @@
+package githublib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v45/github"
+)
+
+func TestRunnerLabels(t *testing.T) {
+	var method, path, body string
+	client := newTestClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		method, path, body = r.Method, r.URL.EscapedPath(), strings.TrimSpace(string(data))
+		if strings.HasSuffix(path, "/2/labels") {
+			rw.WriteHeader(http.StatusNotFound)
+			rw.Write([]byte(`{"message":"Not Found"}`))
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Write([]byte(`{"total_count":2,"labels":[{"id":1,"name":"self-hosted","type":"read-only"},{"id":2,"name":"xcode","type":"custom"}]}`))
+	}))
+	repo := &RunnerTargetRepository{Owner: "o", Name: "r"}
+	org := &RunnerTargetOrganization{Name: "o"}
+	ctx := context.Background()
+
+	cases := []struct {
+		name   string
+		target RunnerTarget
+		call   func(target RunnerTarget) error
+		method string
+		path   string
+		body   string
+	}{
+		{
+			"list", repo,
+			func(target RunnerTarget) error { return checkLabels(target.ListRunnerLabels(ctx, client, 1)) },
+			"GET", "/repos/o/r/actions/runners/1/labels", "",
+		},
+		{
+			"add", repo,
+			func(target RunnerTarget) error {
+				return checkLabels(target.AddRunnerLabels(ctx, client, 1, []string{"xcode"}))
+			},
+			"POST", "/repos/o/r/actions/runners/1/labels", `{"labels":["xcode"]}`,
+		},
+		{
+			"set", org,
+			func(target RunnerTarget) error {
+				return checkLabels(target.SetRunnerLabels(ctx, client, 1, []string{"xcode"}))
+			},
+			"PUT", "/orgs/o/actions/runners/1/labels", `{"labels":["xcode"]}`,
+		},
+		{
+			"set none", org,
+			func(target RunnerTarget) error { return checkLabels(target.SetRunnerLabels(ctx, client, 1, nil)) },
+			"PUT", "/orgs/o/actions/runners/1/labels", `{"labels":[]}`,
+		},
+		{
+			"remove", org,
+			func(target RunnerTarget) error {
+				return checkLabels(target.RemoveRunnerLabel(ctx, client, 1, "xcode 14/beta"))
+			},
+			"DELETE", "/orgs/o/actions/runners/1/labels/xcode%2014%2Fbeta", "",
+		},
+	}
+	for _, c := range cases {
+		method, path, body = "", "", ""
+		if err := c.call(c.target); err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		}
+		if method != c.method || path != c.path || body != c.body {
+			t.Errorf("%s: unexpected request: %s %s %s", c.name, method, path, body)
+		}
+	}
+
+	if _, err := repo.SetRunnerLabels(ctx, client, 2, []string{"xcode"}); err == nil {
+		t.Error("expected error of missing runner")
+	}
+}
+
+func checkLabels(labels []*github.RunnerLabels, err error) error {
+	if err != nil {
+		return err
+	}
+	data, _ := json.Marshal(labels)
+	if expected := `[{"id":1,"name":"self-hosted","type":"read-only"},{"id":2,"name":"xcode","type":"custom"}]`; string(data) != expected {
+		return fmt.Errorf("unexpected labels: %s", data)
+	}
+	return nil
+}
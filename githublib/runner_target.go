@@ -11,8 +11,20 @@ import (
 type RunnerTarget interface {
 	URL() string
 	GetRegistrationToken(ctx context.Context, client *github.Client) (*github.RegistrationToken, error)
-	GetRunners(ctx context.Context, client *github.Client, page int, pageSize int) (runners []*github.Runner, nextPage int, err error)
+	GetRunnersPage(ctx context.Context, client *github.Client, page int, pageSize int, etag string) (*RunnersPage, error)
 	DeleteRunner(ctx context.Context, client *github.Client, id int64) error
+
+	// Runner label methods return all labels of the runner after the change;
+	// only custom labels can be changed.
+	ListRunnerLabels(ctx context.Context, client *github.Client, id int64) ([]*github.RunnerLabels, error)
+	AddRunnerLabels(ctx context.Context, client *github.Client, id int64, labels []string) ([]*github.RunnerLabels, error)
+	SetRunnerLabels(ctx context.Context, client *github.Client, id int64, labels []string) ([]*github.RunnerLabels, error)
+	RemoveRunnerLabel(ctx context.Context, client *github.Client, id int64, label string) ([]*github.RunnerLabels, error)
+
+	// ListActiveJobs lists queued and in-progress jobs that may run on
+	// runners of the target; repos limits organization repositories
+	// listed, and is ignored for repositories.
+	ListActiveJobs(ctx context.Context, lister *JobLister, repos []string) ([]*ActiveJob, error)
 }
 
 var (
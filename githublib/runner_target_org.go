@@ -24,15 +24,10 @@ func (t *RunnerTargetOrganization) GetRegistrationToken(ctx context.Context, cli
 	return token, nil
 }
 
-func (t *RunnerTargetOrganization) GetRunners(
-	ctx context.Context, client *github.Client, page int, pageSize int,
-) ([]*github.Runner, int, error) {
-	runners, resp, err := client.Actions.ListOrganizationRunners(ctx, t.Name, &github.ListOptions{Page: page, PerPage: pageSize})
-	if err != nil {
-		return nil, 0, err
-	}
-
-	return runners.Runners, resp.NextPage, nil
+func (t *RunnerTargetOrganization) GetRunnersPage(
+	ctx context.Context, client *github.Client, page int, pageSize int, etag string,
+) (*RunnersPage, error) {
+	return getRunnersPage(ctx, client, t.runnersPath(), page, pageSize, etag)
 }
 
 func (t *RunnerTargetOrganization) DeleteRunner(
@@ -41,3 +36,31 @@ func (t *RunnerTargetOrganization) DeleteRunner(
 	_, err := client.Actions.RemoveOrganizationRunner(ctx, t.Name, id)
 	return err
 }
+
+func (t *RunnerTargetOrganization) runnersPath() string {
+	return fmt.Sprintf("orgs/%s/actions/runners", t.Name)
+}
+
+func (t *RunnerTargetOrganization) ListRunnerLabels(
+	ctx context.Context, client *github.Client, id int64,
+) ([]*github.RunnerLabels, error) {
+	return listRunnerLabels(ctx, client, t.runnersPath(), id)
+}
+
+func (t *RunnerTargetOrganization) AddRunnerLabels(
+	ctx context.Context, client *github.Client, id int64, labels []string,
+) ([]*github.RunnerLabels, error) {
+	return addRunnerLabels(ctx, client, t.runnersPath(), id, labels)
+}
+
+func (t *RunnerTargetOrganization) SetRunnerLabels(
+	ctx context.Context, client *github.Client, id int64, labels []string,
+) ([]*github.RunnerLabels, error) {
+	return setRunnerLabels(ctx, client, t.runnersPath(), id, labels)
+}
+
+func (t *RunnerTargetOrganization) RemoveRunnerLabel(
+	ctx context.Context, client *github.Client, id int64, label string,
+) ([]*github.RunnerLabels, error) {
+	return removeRunnerLabel(ctx, client, t.runnersPath(), id, label)
+}
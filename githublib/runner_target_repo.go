@@ -25,15 +25,10 @@ func (t *RunnerTargetRepository) GetRegistrationToken(ctx context.Context, clien
 	return token, nil
 }
 
-func (t *RunnerTargetRepository) GetRunners(
-	ctx context.Context, client *github.Client, page int, pageSize int,
-) ([]*github.Runner, int, error) {
-	runners, resp, err := client.Actions.ListRunners(ctx, t.Owner, t.Name, &github.ListOptions{Page: page, PerPage: pageSize})
-	if err != nil {
-		return nil, 0, err
-	}
-
-	return runners.Runners, resp.NextPage, nil
+func (t *RunnerTargetRepository) GetRunnersPage(
+	ctx context.Context, client *github.Client, page int, pageSize int, etag string,
+) (*RunnersPage, error) {
+	return getRunnersPage(ctx, client, t.runnersPath(), page, pageSize, etag)
 }
 
 func (t *RunnerTargetRepository) DeleteRunner(
@@ -42,3 +37,31 @@ func (t *RunnerTargetRepository) DeleteRunner(
 	_, err := client.Actions.RemoveRunner(ctx, t.Owner, t.Name, id)
 	return err
 }
+
+func (t *RunnerTargetRepository) runnersPath() string {
+	return fmt.Sprintf("repos/%s/%s/actions/runners", t.Owner, t.Name)
+}
+
+func (t *RunnerTargetRepository) ListRunnerLabels(
+	ctx context.Context, client *github.Client, id int64,
+) ([]*github.RunnerLabels, error) {
+	return listRunnerLabels(ctx, client, t.runnersPath(), id)
+}
+
+func (t *RunnerTargetRepository) AddRunnerLabels(
+	ctx context.Context, client *github.Client, id int64, labels []string,
+) ([]*github.RunnerLabels, error) {
+	return addRunnerLabels(ctx, client, t.runnersPath(), id, labels)
+}
+
+func (t *RunnerTargetRepository) SetRunnerLabels(
+	ctx context.Context, client *github.Client, id int64, labels []string,
+) ([]*github.RunnerLabels, error) {
+	return setRunnerLabels(ctx, client, t.runnersPath(), id, labels)
+}
+
+func (t *RunnerTargetRepository) RemoveRunnerLabel(
+	ctx context.Context, client *github.Client, id int64, label string,
+) ([]*github.RunnerLabels, error) {
+	return removeRunnerLabel(ctx, client, t.runnersPath(), id, label)
+}
@@ -0,0 +1,55 @@
+package githublib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/google/go-github/v45/github"
+)
+
+type RunnersPage struct {
+	Runners  []*github.Runner
+	NextPage int
+	ETag     string
+	Rate     github.Rate
+
+	// NotModified is set when the page is unchanged since the provided ETag;
+	// Runners and NextPage are not populated in this case.
+	NotModified bool
+}
+
+func getRunnersPage(
+	ctx context.Context, client *github.Client, path string, page int, pageSize int, etag string,
+) (*RunnersPage, error) {
+	url := fmt.Sprintf("%s?page=%d&per_page=%d", path, page, pageSize)
+	req, err := client.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	runners := &github.Runners{}
+	resp, err := client.Do(ctx, req, runners)
+
+	var errResp *github.ErrorResponse
+	if errors.As(err, &errResp) && errResp.Response.StatusCode == http.StatusNotModified {
+		return &RunnersPage{
+			ETag:        etag,
+			Rate:        resp.Rate,
+			NotModified: true,
+		}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &RunnersPage{
+		Runners:  runners.Runners,
+		NextPage: resp.NextPage,
+		ETag:     resp.Header.Get("ETag"),
+		Rate:     resp.Rate,
+	}, nil
+}
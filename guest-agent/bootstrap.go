@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/oursky/github-ci-support/coordinatorclient"
+)
+
+// ReadBootstrapMessage reads the bootstrap message from path, or stdin if
+// path is "-".
+func ReadBootstrapMessage(path string) (*coordinatorclient.BootstrapMessage, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		return coordinatorclient.ParseBootstrapMessage(line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, errors.New("no bootstrap message received")
+}
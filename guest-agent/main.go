@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"go.uber.org/zap"
+
+	"github.com/oursky/github-ci-support/coordinatorclient"
+)
+
+func main() {
+	var bootstrapPath string
+	var runnerDir string
+	var runnerVersion string
+	var shutdownCommand string
+	flag.StringVar(&bootstrapPath, "bootstrap", "-", "path to read bootstrap message from, '-' for stdin")
+	flag.StringVar(&runnerDir, "runner-dir", "actions-runner", "path to actions runner installation")
+	flag.StringVar(&runnerVersion, "runner-version", "", "actions runner version to install, latest if empty")
+	flag.StringVar(&shutdownCommand, "shutdown-command", "sudo shutdown -h now", "command to shutdown the VM after runner exits, empty to disable")
+
+	flag.Parse()
+
+	l, _ := zap.NewProduction()
+	defer l.Sync()
+	logger := l.Sugar()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+
+	agent := &Agent{
+		logger:        logger,
+		runner:        NewActionsRunner(logger, runnerDir),
+		runnerVersion: runnerVersion,
+	}
+
+	err := agent.Run(ctx, bootstrapPath)
+	if err != nil {
+		logger.Errorw("agent failed", "error", err)
+	}
+
+	if shutdownCommand != "" {
+		logger.Infow("shutting down", "cmd", shutdownCommand)
+		args := strings.Fields(shutdownCommand)
+		if err := exec.Command(args[0], args[1:]...).Run(); err != nil {
+			logger.Errorw("failed to shutdown", "error", err)
+		}
+	}
+
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+type Agent struct {
+	logger        *zap.SugaredLogger
+	runner        *ActionsRunner
+	runnerVersion string
+}
+
+func (a *Agent) Run(ctx context.Context, bootstrapPath string) error {
+	msg, err := ReadBootstrapMessage(bootstrapPath)
+	if err != nil {
+		return fmt.Errorf("cannot read bootstrap message: %w", err)
+	}
+	a.logger.Infow("received bootstrap message",
+		"version", msg.Version,
+		"serverURLs", msg.ServerURLs,
+		"instanceID", msg.Coordinator.InstanceID,
+	)
+
+	client, err := coordinatorclient.NewClientFromBootstrap(msg)
+	if err != nil {
+		return err
+	}
+
+	hostName, _ := os.Hostname()
+	reg, err := client.Register(ctx, &coordinatorclient.RegisterRequest{
+		Name:            hostName,
+		HostName:        hostName,
+		ProtocolVersion: coordinatorclient.ProtocolVersion,
+		Capabilities:    coordinatorclient.Capabilities,
+	})
+	if err != nil {
+		return fmt.Errorf("cannot register: %w", err)
+	}
+	a.logger.Infow("registered",
+		"name", reg.Name,
+		"protocolVersion", reg.ProtocolVersion,
+		"capabilities", reg.Capabilities,
+	)
+
+	if !a.runner.IsInstalled() {
+		if err := a.runner.Install(ctx, a.runnerVersion); err != nil {
+			return fmt.Errorf("cannot install runner: %w", err)
+		}
+	}
+
+	if err := a.runner.Configure(ctx, reg); err != nil {
+		return fmt.Errorf("cannot configure runner: %w", err)
+	}
+
+	runnerID, err := a.runner.RunnerID()
+	if err != nil {
+		return fmt.Errorf("cannot read runner ID: %w", err)
+	}
+	xcodeVersions := DetectXcodeVersions()
+	a.logger.Infow("detected Xcode versions", "versions", xcodeVersions)
+	if err := client.Update(ctx, &coordinatorclient.UpdateRequest{RunnerID: &runnerID, XcodeVersions: xcodeVersions}); err != nil {
+		return fmt.Errorf("cannot update runner ID: %w", err)
+	}
+
+	cmd, err := a.runner.Start(msg.Env)
+	if err != nil {
+		return fmt.Errorf("cannot start runner: %w", err)
+	}
+
+	completed := make(chan error, 1)
+	go func() {
+		completed <- cmd.Wait()
+	}()
+
+	stop := make(chan struct{})
+	waitCtx, cancelWait := context.WithCancel(ctx)
+	defer cancelWait()
+	// Older coordinators cannot request stop.
+	if coordinatorclient.HasCapability(reg.Capabilities, coordinatorclient.CapabilityWait) {
+		go a.wait(waitCtx, client, stop)
+	}
+
+	select {
+	case err = <-completed:
+		a.logger.Infow("runner exited", "error", err)
+		return err
+
+	case <-stop:
+	case <-ctx.Done():
+	}
+
+	a.logger.Info("stopping runner")
+	// Runner handles SIGINT gracefully.
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGINT)
+	err = <-completed
+	a.logger.Infow("runner exited", "error", err)
+	return err
+}
+
+func (a *Agent) wait(ctx context.Context, client *coordinatorclient.Client, stop chan<- struct{}) {
+	if err := client.WaitStop(ctx); err != nil {
+		if ctx.Err() == nil {
+			a.logger.Warnw("failed to wait for coordinator", "error", err)
+		}
+		return
+	}
+	a.logger.Info("coordinator requested stop")
+	close(stop)
+}
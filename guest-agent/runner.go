@@ -0,0 +1,288 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"go.uber.org/zap"
+
+	"github.com/oursky/github-ci-support/coordinatorclient"
+)
+
+const latestRunnerReleaseURL = "https://api.github.com/repos/actions/runner/releases/latest"
+
+// ActionsRunner manages the GitHub Actions runner installation in a
+// directory.
+type ActionsRunner struct {
+	logger *zap.SugaredLogger
+	dir    string
+}
+
+func NewActionsRunner(logger *zap.SugaredLogger, dir string) *ActionsRunner {
+	return &ActionsRunner{
+		logger: logger.Named("runner"),
+		dir:    dir,
+	}
+}
+
+func (r *ActionsRunner) IsInstalled() bool {
+	_, err := os.Stat(filepath.Join(r.dir, "config.sh"))
+	return err == nil
+}
+
+// Install downloads and extracts the runner of specified version, or the
+// latest version if empty.
+func (r *ActionsRunner) Install(ctx context.Context, version string) error {
+	if version == "" {
+		latest, err := latestRunnerVersion(ctx)
+		if err != nil {
+			return fmt.Errorf("cannot get latest runner version: %w", err)
+		}
+		version = latest
+	}
+
+	platform, err := runnerPlatform()
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf(
+		"https://github.com/actions/runner/releases/download/v%s/actions-runner-%s-%s.tar.gz",
+		version, platform, version,
+	)
+	r.logger.Infow("downloading runner", "url", url)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("cannot download runner: unexpected status %d", resp.StatusCode)
+	}
+
+	if err := os.MkdirAll(r.dir, 0755); err != nil {
+		return err
+	}
+	return extractTarGz(resp.Body, r.dir)
+}
+
+func (r *ActionsRunner) Configure(ctx context.Context, reg *coordinatorclient.RegisterResponse) error {
+	args := []string{
+		"--unattended",
+		"--ephemeral",
+		"--replace",
+		"--url", reg.GitHubURL,
+		"--token", reg.Token,
+		"--name", reg.Name,
+	}
+	if reg.Group != "" {
+		args = append(args, "--runnergroup", reg.Group)
+	}
+	if reg.Labels != "" {
+		args = append(args, "--labels", reg.Labels)
+	}
+
+	cmd := exec.CommandContext(ctx, filepath.Join(r.dir, "config.sh"), args...)
+	cmd.Dir = r.dir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	r.logger.Infow("configuring runner", "name", reg.Name)
+	return cmd.Run()
+}
+
+// RunnerID reads the runner ID from runner configuration.
+func (r *ActionsRunner) RunnerID() (int64, error) {
+	data, err := os.ReadFile(filepath.Join(r.dir, ".runner"))
+	if err != nil {
+		return 0, err
+	}
+	// The file may contain UTF-8 BOM.
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	var config struct {
+		AgentID int64 `json:"agentId"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return 0, fmt.Errorf("malformed runner config: %w", err)
+	}
+	return config.AgentID, nil
+}
+
+// Start starts the runner; the returned command should be waited on.
+func (r *ActionsRunner) Start(env map[string]string) (*exec.Cmd, error) {
+	cmd := exec.Command(filepath.Join(r.dir, "run.sh"))
+	cmd.Dir = r.dir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = os.Environ()
+	for k, v := range env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	r.logger.Info("starting runner")
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+func latestRunnerVersion(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", latestRunnerReleaseURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var release struct {
+		TagName string `json:"tag_name"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
+		return "", err
+	}
+	return strings.TrimPrefix(release.TagName, "v"), nil
+}
+
+func runnerPlatform() (string, error) {
+	var osName, arch string
+	switch runtime.GOOS {
+	case "darwin":
+		osName = "osx"
+	case "linux":
+		osName = "linux"
+	default:
+		return "", fmt.Errorf("unsupported OS: %s", runtime.GOOS)
+	}
+	switch runtime.GOARCH {
+	case "amd64":
+		arch = "x64"
+	case "arm64":
+		arch = "arm64"
+	default:
+		return "", fmt.Errorf("unsupported architecture: %s", runtime.GOARCH)
+	}
+	return osName + "-" + arch, nil
+}
+
+func extractTarGz(r io.Reader, dir string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	// Resolve dir itself, so that paths are checked against real paths.
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		path := filepath.Join(dir, header.Name)
+		if !isWithinDir(dir, path) {
+			return fmt.Errorf("invalid path in archive: %s", header.Name)
+		}
+		if path == dir {
+			continue
+		}
+		// Symlinks extracted earlier must not redirect entries outside dir.
+		parent, err := resolveExisting(filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		if !isWithinDir(dir, parent) {
+			return fmt.Errorf("invalid path in archive: %s", header.Name)
+		}
+		path = filepath.Join(parent, filepath.Base(path))
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, os.FileMode(header.Mode)|0700); err != nil {
+				return err
+			}
+
+		case tar.TypeSymlink:
+			if filepath.IsAbs(header.Linkname) || !isWithinDir(dir, filepath.Join(parent, header.Linkname)) {
+				return fmt.Errorf("invalid symlink in archive: %s -> %s", header.Name, header.Linkname)
+			}
+			if err := os.MkdirAll(parent, 0755); err != nil {
+				return err
+			}
+			if err := os.Symlink(header.Linkname, path); err != nil {
+				return err
+			}
+
+		case tar.TypeReg:
+			if err := os.MkdirAll(parent, 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode))
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func isWithinDir(dir string, path string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(os.PathSeparator))
+}
+
+// resolveExisting resolves symlinks in the longest existing prefix of path.
+func resolveExisting(path string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err == nil {
+		return resolved, nil
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	parent := filepath.Dir(path)
+	if parent == path {
+		return path, nil
+	}
+	resolvedParent, err := resolveExisting(parent)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(resolvedParent, filepath.Base(path)), nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type tarEntry struct {
+	name     string
+	linkname string
+	body     string
+}
+
+func makeTarGz(t *testing.T, entries []tarEntry) *bytes.Buffer {
+	buf := new(bytes.Buffer)
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		header := &tar.Header{Name: e.name, Mode: 0644}
+		switch {
+		case e.linkname != "":
+			header.Typeflag = tar.TypeSymlink
+			header.Linkname = e.linkname
+		case e.name[len(e.name)-1] == '/':
+			header.Typeflag = tar.TypeDir
+			header.Mode = 0755
+		default:
+			header.Typeflag = tar.TypeReg
+			header.Size = int64(len(e.body))
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func TestExtractTarGz(t *testing.T) {
+	dir := t.TempDir()
+	err := extractTarGz(makeTarGz(t, []tarEntry{
+		{name: "./"},
+		{name: "bin/"},
+		{name: "bin/run.sh", body: "#!/bin/sh\n"},
+		{name: "run.sh", linkname: "bin/run.sh"},
+		{name: "bin/self", linkname: "../bin"},
+	}), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "run.sh")); err != nil || string(data) != "#!/bin/sh\n" {
+		t.Errorf("unexpected symlinked file: %q %v", data, err)
+	}
+}
+
+func TestExtractTarGzEscape(t *testing.T) {
+	cases := []struct {
+		name    string
+		entries []tarEntry
+	}{
+		{"path", []tarEntry{{name: "../evil", body: "x"}}},
+		{"absolute symlink", []tarEntry{{name: "evil", linkname: "/etc"}}},
+		{"escaping symlink", []tarEntry{{name: "bin/evil", linkname: "../../etc"}}},
+		{"write through symlink", []tarEntry{
+			{name: "up", linkname: "."},
+			{name: "up/link", linkname: ".."},
+		}},
+		{"chained symlinks", []tarEntry{
+			{name: "a/", body: ""},
+			{name: "a/up", linkname: ".."},
+			{name: "a/up/up2", linkname: "../.."},
+		}},
+	}
+	for _, c := range cases {
+		root := t.TempDir()
+		dir := filepath.Join(root, "runner")
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := extractTarGz(makeTarGz(t, c.entries), dir); err == nil {
+			t.Errorf("%s: expected error", c.name)
+		}
+		if entries, _ := os.ReadDir(root); len(entries) != 1 {
+			t.Errorf("%s: extracted outside dir: %v", c.name, entries)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+)
+
+var regexBundleVersion = regexp.MustCompile(`<key>CFBundleShortVersionString</key>\s*<string>([^<]+)</string>`)
+
+// DetectXcodeVersions lists versions of Xcode installed in /Applications.
+func DetectXcodeVersions() []string {
+	paths, _ := filepath.Glob("/Applications/Xcode*.app/Contents/version.plist")
+
+	seen := make(map[string]bool)
+	versions := []string{}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		match := regexBundleVersion.FindSubmatch(data)
+		if match == nil || seen[string(match[1])] {
+			continue
+		}
+		seen[string(match[1])] = true
+		versions = append(versions, string(match[1]))
+	}
+	sort.Strings(versions)
+	return versions
+}